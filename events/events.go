@@ -0,0 +1,100 @@
+// Package events 链码事件的负载格式，链下监听程序可以直接导入本包解析事件
+//
+// 每个交易最多发出一个链码事件，事件名称为本交易唯一业务事件的Name，
+// 一个交易产生多个业务事件时事件名称为BatchEventName，负载均为Payload的JSON编码
+package events
+
+import "encoding/json"
+
+// 业务事件名称
+const (
+	// CompactStateChanged compact状态转换，EntityId为compactId
+	CompactStateChanged string = "CompactStateChanged"
+	// BidStateChanged 报价状态变化，EntityId为 compactId/powerPlantName
+	BidStateChanged string = "BidStateChanged"
+	// UserRegistered 用户注册，NewState为用户角色
+	UserRegistered string = "UserRegistered"
+	// UserCreditChanged 用户信用值或交易额度变化，状态为 credit/power
+	UserCreditChanged string = "UserCreditChanged"
+	// ElectionProposalStateChanged 选举提案状态变化
+	ElectionProposalStateChanged string = "ElectionProposalStateChanged"
+	// ElectionVoteCast 选举投票，Actors为投票人与候选人
+	ElectionVoteCast string = "ElectionVoteCast"
+	// CommitteeChanged 委员会变化，状态为逗号分隔的成员列表
+	CommitteeChanged string = "CommitteeChanged"
+	// BallotProposalStateChanged 投票提案状态变化
+	BallotProposalStateChanged string = "BallotProposalStateChanged"
+	// BallotVoteCast 投票提案投票，Detail中vote为 up 或 negative
+	BallotVoteCast string = "BallotVoteCast"
+	// VariableChanged 治理参数变化，状态为参数的旧值与新值
+	VariableChanged string = "VariableChanged"
+	// CertificateStateChanged 绿色电力证书发放或注销
+	CertificateStateChanged string = "CertificateStateChanged"
+	// CertificateTransferred 绿色电力证书转让，状态为原持有人与新持有人
+	CertificateTransferred string = "CertificateTransferred"
+	// EmissionFactorChanged powerPlant排放因子变化，EntityId为powerPlant，状态为旧值与新值
+	EmissionFactorChanged string = "EmissionFactorChanged"
+	// FrameworkStateChanged 框架合同状态变化
+	FrameworkStateChanged string = "FrameworkStateChanged"
+	// AdminAssignmentChanged compact的admin指派或指派状态变化，Actors为被指派的admin
+	AdminAssignmentChanged string = "AdminAssignmentChanged"
+	// CompactApprovalChanged 委员会成员批准或撤销批准大额compact，EntityId为 compactId/memberName
+	CompactApprovalChanged string = "CompactApprovalChanged"
+
+	// BatchEventName 一个交易产生多个业务事件时的链码事件名称
+	BatchEventName string = "Batch"
+)
+
+// 业务实体类型
+const (
+	CompactEntity          string = "Compact"
+	BidEntity              string = "Bid"
+	UserEntity             string = "User"
+	ElectionProposalEntity string = "ElectionProposal"
+	CommitteeEntity        string = "Committee"
+	BallotProposalEntity   string = "BallotProposal"
+	VariableEntity         string = "Variable"
+	CertificateEntity      string = "Certificate"
+	EmissionFactorEntity   string = "EmissionFactor"
+	FrameworkEntity        string = "Framework"
+	AdminAssignmentEntity  string = "AdminAssignment"
+	CompactApprovalEntity  string = "CompactApproval"
+)
+
+// Event 业务事件
+type Event struct {
+	Name       string            `json:"name"`
+	EntityType string            `json:"entity_type"`
+	EntityId   string            `json:"entity_id"`
+	OldState   string            `json:"old_state"`
+	NewState   string            `json:"new_state"`
+	Actors     []string          `json:"actors"`
+	Detail     map[string]string `json:"detail,omitempty"`
+	TxId       string            `json:"tx_id"`
+	EventTime  string            `json:"event_time"`
+}
+
+// Payload 链码事件负载，按发生顺序包含交易中的全部业务事件
+type Payload struct {
+	Events []Event `json:"events"`
+}
+
+// EventName 负载对应的链码事件名称
+func (p *Payload) EventName() string {
+	if len(p.Events) == 1 {
+		return p.Events[0].Name
+	}
+
+	return BatchEventName
+}
+
+// Parse 解析链码事件负载
+func Parse(payload []byte) (*Payload, error) {
+	p := new(Payload)
+
+	if err := json.Unmarshal(payload, p); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
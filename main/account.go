@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+)
+
+type AccountContract struct {
+	contractapi.Contract
+}
+
+// Account 用户账户，金额单位为厘(0.001元)
+type Account struct {
+	UserName 		string		`json:"user_name"`
+	Balance 		int64		`json:"balance"`
+	Escrowed 		int64		`json:"escrowed"`
+}
+
+// AccountEntry 账户流水
+type AccountEntry struct {
+	UserName 		string		`json:"user_name"`
+	EntryType 		string		`json:"entry_type"`
+	Amount 			int64		`json:"amount"`
+	EscrowChange 	int64		`json:"escrow_change"`
+	Balance 		int64		`json:"balance"`
+	Escrowed 		int64		`json:"escrowed"`
+	CompactId 		string		`json:"compact_id"`
+	TxId 			string		`json:"tx_id"`
+	EntryTime 		string		`json:"entry_time"`
+}
+
+// Deposit 充值 Withdraw 提现 Escrow 托管 Release 托管款支付给powerPlant Fee admin手续费 Refund 托管款退回
+const Deposit string = "Deposit"
+const Withdraw string = "Withdraw"
+const Escrow string = "Escrow"
+const Release string = "Release"
+const Fee string = "Fee"
+const Refund string = "Refund"
+
+// Deposit admin确认线下付款后为用户充值
+func (a *AccountContract) Deposit(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	amount int64) (*Account, error) {
+	// 1.判断调用者是否为admin
+	var r RoleContract
+	if _, err := r.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	// 2.判断金额
+	if amount <= 0 {
+		return nil, fmt.Errorf("Amount must be positive ! ")
+	}
+
+	// 3.判断用户是否存在
+	if !r.UserExist(ctx, userName) {
+		return nil, fmt.Errorf("%s does not exist", userName)
+	}
+
+	// 4.充值
+	return a.updateAccount(ctx, userName, []AccountEntry{{
+		EntryType: Deposit,
+		Amount: amount,
+	}})
+}
+
+// Withdraw 用户提现，线下付款由admin完成
+func (a *AccountContract) Withdraw(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	amount int64) (*Account, error) {
+	// 1.判断用户是否为调用者本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, userName); err != nil {
+		return nil, err
+	}
+
+	// 2.判断金额
+	if amount <= 0 {
+		return nil, fmt.Errorf("Amount must be positive ! ")
+	}
+
+	// 3.提现
+	return a.updateAccount(ctx, userName, []AccountEntry{{
+		EntryType: Withdraw,
+		Amount: -amount,
+	}})
+}
+
+// QueryAccount 获取用户账户，没有账户时余额为0
+func (a *AccountContract) QueryAccount(
+	ctx contractapi.TransactionContextInterface,
+	userName string) (*Account, error) {
+	// 1.获取账户信息
+	accountAsBytes, err := getState(ctx, AccountObjectType, userName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Account Info from world state. %s ", err.Error())
+	}
+
+	// 2.没有账户
+	account := &Account{
+		UserName: userName,
+	}
+
+	if accountAsBytes == nil {
+		return account, nil
+	}
+
+	// 3.赋值
+	_ = json.Unmarshal(accountAsBytes, account)
+
+	return account, nil
+}
+
+// QueryStatement 获取用户账户流水，按时间排序
+func (a *AccountContract) QueryStatement(
+	ctx contractapi.TransactionContextInterface,
+	userName string) ([]*AccountEntry, error) {
+	// 1.按用户查询流水
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(AccountEntryObjectType, []string{userName})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query AccountEntry Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	entries := []*AccountEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := new(AccountEntry)
+		_ = json.Unmarshal(kv.Value, entry)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// updateAccount 按流水更改账户余额与托管金额，并记录流水
+// 同一交易中读不到本交易写入的账户，因此对同一账户的全部变动需一次传入
+func (a *AccountContract) updateAccount(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	entries []AccountEntry) (*Account, error) {
+	// 1.获取账户
+	account, err := a.QueryAccount(ctx, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.获取交易时间
+	var t TimeContract
+	entryTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.逐条更改余额并记录流水
+	txId := ctx.GetStub().GetTxID()
+	for i, entry := range entries {
+		account.Balance, err = addAmount(account.Balance, entry.Amount)
+
+		if err != nil {
+			return nil, err
+		}
+
+		account.Escrowed, err = addAmount(account.Escrowed, entry.EscrowChange)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if account.Balance < 0 {
+			return nil, fmt.Errorf("%s balance is not enough ! ", userName)
+		}
+
+		if account.Escrowed < 0 {
+			return nil, fmt.Errorf("%s escrowed is not enough ! ", userName)
+		}
+
+		entry.UserName = userName
+		entry.Balance = account.Balance
+		entry.Escrowed = account.Escrowed
+		entry.TxId = txId
+		entry.EntryTime = entryTime
+
+		entryAsBytes, _ := json.Marshal(entry)
+		err = putState(ctx, entryAsBytes, AccountEntryObjectType, userName, entryTime, txId, fmt.Sprintf("%03d", i))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 4.账户上链
+	accountAsBytes, _ := json.Marshal(account)
+	err = putState(ctx, accountAsBytes, AccountObjectType, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// updateAccounts 按用户名排序逐个更改账户，保证各背书节点写入顺序一致
+func (a *AccountContract) updateAccounts(
+	ctx contractapi.TransactionContextInterface,
+	entries map[string][]AccountEntry) error {
+	userNames := make([]string, 0, len(entries))
+	for userName := range entries {
+		userNames = append(userNames, userName)
+	}
+	sort.Strings(userNames)
+
+	for _, userName := range userNames {
+		if _, err := a.updateAccount(ctx, userName, entries[userName]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// settleCompact 结算compact的托管款
+// 各分段按实际供电量与合同电量的较小值付款给powerPlant，admin按费率收取手续费，剩余托管款退回powerUser
+func (a *AccountContract) settleCompact(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact) error {
+	// 1.没有托管款的compact不结算
+	if compact.Escrowed == 0 {
+		return nil
+	}
+
+	// 2.读取手续费率
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// 3.逐个分段计算付款与手续费
+	entries := make(map[string][]AccountEntry)
+	paid := int64(0)
+	fee := int64(0)
+	for _, leg := range compact.Legs {
+		delivered := leg.Delivered
+		if delivered > leg.Quantity {
+			delivered = leg.Quantity
+		}
+
+		legPaid, err := leg.Price.Amount(delivered)
+
+		if err != nil {
+			return err
+		}
+
+		// 按千分之一的费率计算手续费，先整除避免乘法超出int64范围
+		rate := int64(variables[AdminFeeRate])
+		legFee := legPaid/1000*rate + legPaid%1000*rate/1000
+		paid += legPaid
+		fee += legFee
+
+		entries[leg.PowerPlantName] = append(entries[leg.PowerPlantName], AccountEntry{
+			EntryType: Release,
+			Amount: legPaid - legFee,
+			CompactId: compact.CompactId,
+		})
+	}
+
+	// 4.admin手续费
+	if fee > 0 {
+		entries[compact.AdminName] = append(entries[compact.AdminName], AccountEntry{
+			EntryType: Fee,
+			Amount: fee,
+			CompactId: compact.CompactId,
+		})
+	}
+
+	// 5.powerUser托管款减少，未付部分退回
+	entries[compact.PowerUserName] = append(entries[compact.PowerUserName], AccountEntry{
+		EntryType: Release,
+		EscrowChange: -paid,
+		CompactId: compact.CompactId,
+	}, AccountEntry{
+		EntryType: Refund,
+		Amount: compact.Escrowed - paid,
+		EscrowChange: -(compact.Escrowed - paid),
+		CompactId: compact.CompactId,
+	})
+
+	// 6.更改账户
+	return a.updateAccounts(ctx, entries)
+}
+
+// refundCompact compact取消或过期时把托管款全部退回powerUser
+func (a *AccountContract) refundCompact(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact) error {
+	if compact.Escrowed == 0 {
+		return nil
+	}
+
+	_, err := a.updateAccount(ctx, compact.PowerUserName, []AccountEntry{{
+		EntryType: Refund,
+		Amount: compact.Escrowed,
+		EscrowChange: -compact.Escrowed,
+		CompactId: compact.CompactId,
+	}})
+
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDepositAndWithdraw(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice", "bob")
+	deposit(t, s, "alice", 1000)
+
+	if _, err := s.invoke("alice", "AccountContract:Deposit", "alice", "1000"); err == nil {
+		t.Fatal("power user deposited")
+	}
+
+	var a AccountContract
+	for _, userName := range []string{"alice", "mallory"} {
+		if _, err := a.Deposit(s.ctx(userName), "alice", 1000); err == nil {
+			t.Fatalf("%s deposited without the router", userName)
+		}
+	}
+
+	if _, err := s.invoke("admin", "AccountContract:Deposit", "alice", "0"); err == nil {
+		t.Fatal("deposited zero")
+	}
+
+	if _, err := s.invoke("admin", "AccountContract:Deposit", "mallory", "1000"); err == nil {
+		t.Fatal("deposited to a missing user")
+	}
+
+	if _, err := s.invoke("admin", "AccountContract:Deposit", "alice", "9223372036854775807"); err == nil {
+		t.Fatal("deposit overflowed the balance")
+	}
+
+	if _, err := s.invoke("bob", "AccountContract:Withdraw", "alice", "100"); err == nil {
+		t.Fatal("bob withdrew from alice")
+	}
+
+	if _, err := s.invoke("alice", "AccountContract:Withdraw", "alice", "1001"); err == nil {
+		t.Fatal("withdrew more than the balance")
+	}
+
+	call(t, s, "alice", "AccountContract:Withdraw", "alice", "400")
+
+	if account := queryAccount(t, s, "alice"); account.Balance != 600 {
+		t.Fatalf("alice account = %+v", account)
+	}
+
+	entries, err := a.QueryStatement(s.ctx("alice"), "alice")
+	noError(t, err)
+
+	if len(entries) != 2 || entries[0].EntryType != Deposit || entries[1].EntryType != Withdraw || entries[1].Balance != 600 {
+		t.Fatalf("statement = %+v", entries)
+	}
+}
+
+func TestAcceptRequiresBalance(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 49999)
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.5")
+
+	if _, err := s.invoke("alice", "PowerTXContract:Accept", "c1"); err == nil {
+		t.Fatal("accepted without enough balance")
+	}
+
+	deposit(t, s, "alice", 1)
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+
+	if account := queryAccount(t, s, "alice"); account.Balance != 0 || account.Escrowed != 50000 {
+		t.Fatalf("alice account = %+v", account)
+	}
+
+	if compact := queryCompact(t, s, "c1"); compact.Escrowed != 50000 {
+		t.Fatalf("escrowed = %d, want 50000", compact.Escrowed)
+	}
+}
+
+func TestSettleEscrow(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+	dealCompact(t, s, "c1", "alice", "plant", 100)
+
+	s.advance(60 * 24 * time.Hour)
+	meterReading(t, s, "alice", "c1", 100)
+	meterReading(t, s, "plant", "c1", 90)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	// powerPlant按实际供电量收款，admin收取1%手续费，未付部分退回powerUser
+	if account := queryAccount(t, s, "plant"); account.Balance != 44550 {
+		t.Fatalf("plant account = %+v", account)
+	}
+
+	if account := queryAccount(t, s, "admin"); account.Balance != 450 {
+		t.Fatalf("admin account = %+v", account)
+	}
+
+	if account := queryAccount(t, s, "alice"); account.Balance != 1000000-45000 || account.Escrowed != 0 {
+		t.Fatalf("alice account = %+v", account)
+	}
+
+	if compact := queryCompact(t, s, "c1"); compact.Escrowed != 0 || compact.State != "Done" {
+		t.Fatalf("settled compact = %+v", compact)
+	}
+}
+
+func TestCancelCommitRefundsEscrow(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 100000)
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "p1", "PowerTXContract:PartialBid", "c1", "p1", "60", "0.4")
+	call(t, s, "alice", "PowerTXContract:AcceptBid", "c1", "p1")
+
+	if account := queryAccount(t, s, "alice"); account.Escrowed != 24000 {
+		t.Fatalf("alice account = %+v", account)
+	}
+
+	call(t, s, "alice", "PowerTXContract:CancelCommit", "c1")
+
+	if account := queryAccount(t, s, "alice"); account.Balance != 100000 || account.Escrowed != 0 {
+		t.Fatalf("alice account = %+v", account)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+// CompactApproval 委员会成员对大额compact执行Deal的批准，撤销后删除
+type CompactApproval struct {
+	CompactId 		string		`json:"compact_id"`
+	MemberName 		string		`json:"member_name"`
+	ApproveTime 	string		`json:"approve_time"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// CompactApprovalStatus 大额compact的批准情况，Members为可以批准的委员会成员，Required为执行Deal需要的批准数量
+type CompactApprovalStatus struct {
+	CompactId 		string				`json:"compact_id"`
+	Transaction 	int					`json:"transaction"`
+	Members 		[]string			`json:"members"`
+	Required 		int					`json:"required"`
+	Approvals 		[]*CompactApproval	`json:"approvals"`
+}
+
+// ApproveCompact 委员会成员批准已接受的大额compact执行Deal，compact的交易方不能批准
+func (p *PowerTXContract) ApproveCompact(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	memberName string) (*CompactApprovalStatus, error) {
+	// 1.判断调用者是否为memberName本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, memberName); err != nil {
+		return nil, err
+	}
+
+	// 2.获取compact的批准情况
+	compact, status, err := p.approvalStatus(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.State != CompactAccepted {
+		return nil, fmt.Errorf("Compact %s is %s, can not approve ! ", compact.CompactId, compact.State)
+	}
+
+	if status.Required == 0 {
+		return nil, fmt.Errorf("Compact %s does not need approval ! ", compactId)
+	}
+
+	// 3.判断成员能否批准，且没有批准过
+	if !status.isMember(memberName) {
+		return nil, fmt.Errorf("%s can not approve %s ! ", memberName, compactId)
+	}
+
+	for _, approval := range status.Approvals {
+		if approval.MemberName == memberName {
+			return nil, fmt.Errorf("%s has approved %s ! ", memberName, compactId)
+		}
+	}
+
+	// 4.批准上链
+	var t TimeContract
+	approveTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	approval := &CompactApproval{
+		CompactId: compactId,
+		MemberName: memberName,
+		ApproveTime: approveTime,
+		TxId: ctx.GetStub().GetTxID(),
+	}
+
+	approvalAsBytes, _ := json.Marshal(approval)
+	err = putState(ctx, approvalAsBytes, CompactApprovalObjectType, compactId, memberName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	status.Approvals = append(status.Approvals, approval)
+
+	// 5.发出事件
+	err = emitEvent(ctx, events.CompactApprovalChanged, events.CompactApprovalEntity, compactId+"/"+memberName,
+		"", "Approved", []string{memberName},
+		map[string]string{"approvals": fmt.Sprintf("%d", len(status.Approvals)), "required": fmt.Sprintf("%d", status.Required)})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// RevokeApproval 委员会成员在compact执行Deal前撤销自己的批准
+func (p *PowerTXContract) RevokeApproval(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	memberName string) (*CompactApprovalStatus, error) {
+	// 1.判断调用者是否为memberName本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, memberName); err != nil {
+		return nil, err
+	}
+
+	// 2.获取compact的批准情况，执行Deal后不能撤销
+	compact, status, err := p.approvalStatus(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.State != CompactAccepted {
+		return nil, fmt.Errorf("Compact %s is %s, can not revoke approval ! ", compact.CompactId, compact.State)
+	}
+
+	// 3.删除批准
+	approvals := []*CompactApproval{}
+	for _, approval := range status.Approvals {
+		if approval.MemberName != memberName {
+			approvals = append(approvals, approval)
+		}
+	}
+
+	if len(approvals) == len(status.Approvals) {
+		return nil, fmt.Errorf("%s has not approved %s ! ", memberName, compactId)
+	}
+
+	approvalKey, err := createKey(ctx, CompactApprovalObjectType, compactId, memberName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.GetStub().DelState(approvalKey); err != nil {
+		return nil, err
+	}
+
+	status.Approvals = approvals
+
+	// 4.发出事件
+	err = emitEvent(ctx, events.CompactApprovalChanged, events.CompactApprovalEntity, compactId+"/"+memberName,
+		"Approved", "Revoked", []string{memberName},
+		map[string]string{"approvals": fmt.Sprintf("%d", len(status.Approvals)), "required": fmt.Sprintf("%d", status.Required)})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// QueryCompactApprovals 获取compact的批准情况
+func (p *PowerTXContract) QueryCompactApprovals(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (*CompactApprovalStatus, error) {
+	_, status, err := p.approvalStatus(ctx, compactId)
+
+	return status, err
+}
+
+// QueryPendingApprovals 获取等待委员会成员批准的compact，即成员可以批准、尚未批准且批准数量不足的已接受compact
+func (p *PowerTXContract) QueryPendingApprovals(
+	ctx contractapi.TransactionContextInterface,
+	memberName string) ([]*CompactApprovalStatus, error) {
+	// 1.按状态索引查询已接受的compact
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(CompactByStateIndex, []string{CompactAccepted})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Compact index from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.逐个判断批准情况
+	pending := []*CompactApprovalStatus{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+
+		if err != nil || len(attributes) != 2 {
+			continue
+		}
+
+		_, status, err := p.approvalStatus(ctx, attributes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(status.Approvals) >= status.Required || !status.isMember(memberName) {
+			continue
+		}
+
+		approved := false
+		for _, approval := range status.Approvals {
+			if approval.MemberName == memberName {
+				approved = true
+			}
+		}
+
+		if !approved {
+			pending = append(pending, status)
+		}
+	}
+
+	return pending, nil
+}
+
+// checkApprovals 判断compact是否获得执行Deal需要的批准
+func (p *PowerTXContract) checkApprovals(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) error {
+	_, status, err := p.approvalStatus(ctx, compactId)
+
+	if err != nil {
+		return err
+	}
+
+	if len(status.Members) < status.Required {
+		return fmt.Errorf("Compact %s needs %d committee approvals but only %d members can approve ! ", compactId, status.Required, len(status.Members))
+	}
+
+	if len(status.Approvals) < status.Required {
+		return fmt.Errorf("Compact %s has %d of %d committee approvals ! ", compactId, len(status.Approvals), status.Required)
+	}
+
+	return nil
+}
+
+// putGeneratedCompact 合约生成的compact上链，需要委员会批准的大额compact置为已接受并指派admin，批准后由admin执行Deal
+// 其余compact直接置为Deal
+func (p *PowerTXContract) putGeneratedCompact(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact,
+	event string,
+	variables map[string]int) error {
+	// 1.判断是否需要批准
+	toState := CompactDeal
+	if compact.Transaction > variables[LargeCompactTransaction] {
+		toState = CompactAccepted
+	}
+
+	// 2.上链
+	if err := p.transition(ctx, compact, event, toState); err != nil {
+		return err
+	}
+
+	if err := p.putCompact(ctx, compact); err != nil {
+		return err
+	}
+
+	// 3.从委员会中指派执行Deal的admin
+	if toState == CompactAccepted {
+		if _, err := p.assignAdmin(ctx, compact, nil, variables); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// approvalStatus 获取compact的批准情况
+// 交易电量超过LargeCompactTransaction的compact需要CompactApprovalThreshold个委员会成员批准，
+// compact的交易方不能批准，只统计当前委员会成员的批准
+func (p *PowerTXContract) approvalStatus(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (*Compact, *CompactApprovalStatus, error) {
+	// 1.获取compact交易信息
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := &CompactApprovalStatus{
+		CompactId: compactId,
+		Transaction: compact.Transaction,
+		Members: []string{},
+		Approvals: []*CompactApproval{},
+	}
+
+	// 2.判断是否为大额compact
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if compact.Transaction <= variables[LargeCompactTransaction] {
+		return compact, status, nil
+	}
+
+	// 3.可以批准的委员会成员，没有委员会或可以批准的成员不足时不能执行Deal
+	status.Required = variables[CompactApprovalThreshold]
+
+	var e ElectionContract
+	committee := e.QueryCommittee(ctx)
+
+	if committee == nil {
+		return compact, status, nil
+	}
+
+	parties := map[string]bool{compact.PowerUserName: true}
+	for _, leg := range compact.compactLegs() {
+		parties[leg.PowerPlantName] = true
+	}
+
+	for _, member := range committee.Users {
+		if !parties[member] {
+			status.Members = append(status.Members, member)
+		}
+	}
+
+	// 4.获取批准记录
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(CompactApprovalObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to query CompactApproval Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		approval := new(CompactApproval)
+		_ = json.Unmarshal(kv.Value, approval)
+
+		if status.isMember(approval.MemberName) {
+			status.Approvals = append(status.Approvals, approval)
+		}
+	}
+
+	return compact, status, nil
+}
+
+// isMember 判断userName是否为可以批准compact的委员会成员
+func (s *CompactApprovalStatus) isMember(userName string) bool {
+	for _, member := range s.Members {
+		if member == userName {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// pendingApprovals 获取委员会成员尚未批准的compact
+func pendingApprovals(t *testing.T, s *testStub, memberName string) []*CompactApprovalStatus {
+	t.Helper()
+
+	var pending []*CompactApprovalStatus
+	noError(t, json.Unmarshal(call(t, s, memberName, "PowerTXContract:QueryPendingApprovals", memberName), &pending))
+
+	return pending
+}
+
+func TestCompactApprovals(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "m1", "m2", "m3", "m4", "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 20000000)
+	setCommittee(t, s, "m1", "m2", "m3", "m4", "p1")
+
+	commitCompact(t, s, "c1", "alice", 20000)
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	admin := adminAssignments(t, s, "c1")[0].AdminName
+
+	// 大额compact需要3个委员会成员批准才能执行Deal
+	if _, err := s.invoke(admin, "PowerTXContract:Deal", "c1", admin); err == nil {
+		t.Fatal("deal without approvals")
+	}
+
+	// 交易方不能批准，成员只能以自己的名义批准
+	if _, err := s.invoke("p1", "PowerTXContract:ApproveCompact", "c1", "p1"); err == nil {
+		t.Fatal("approved by a party of the compact")
+	}
+
+	if _, err := s.invoke("m2", "PowerTXContract:ApproveCompact", "c1", "m1"); err == nil {
+		t.Fatal("approved for another member")
+	}
+
+	call(t, s, "m1", "PowerTXContract:ApproveCompact", "c1", "m1")
+	call(t, s, "m2", "PowerTXContract:ApproveCompact", "c1", "m2")
+
+	if _, err := s.invoke("m1", "PowerTXContract:ApproveCompact", "c1", "m1"); err == nil {
+		t.Fatal("approved twice")
+	}
+
+	if pending := pendingApprovals(t, s, "m3"); len(pending) != 1 || pending[0].CompactId != "c1" {
+		t.Fatalf("m3 pending = %+v", pending)
+	}
+
+	if pending := pendingApprovals(t, s, "m1"); len(pending) != 0 {
+		t.Fatalf("m1 pending = %+v", pending)
+	}
+
+	// 撤销的批准不计入
+	call(t, s, "m3", "PowerTXContract:ApproveCompact", "c1", "m3")
+	call(t, s, "m3", "PowerTXContract:RevokeApproval", "c1", "m3")
+
+	if _, err := s.invoke(admin, "PowerTXContract:Deal", "c1", admin); err == nil {
+		t.Fatal("deal after an approval was revoked")
+	}
+
+	call(t, s, "m4", "PowerTXContract:ApproveCompact", "c1", "m4")
+
+	status := new(CompactApprovalStatus)
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:QueryCompactApprovals", "c1"), status))
+
+	if status.Required != 3 || len(status.Approvals) != 3 || len(status.Members) != 4 {
+		t.Fatalf("status = %+v", status)
+	}
+
+	call(t, s, admin, "PowerTXContract:Deal", "c1", admin)
+
+	if _, err := s.invoke("m4", "PowerTXContract:RevokeApproval", "c1", "m4"); err == nil {
+		t.Fatal("approval revoked after the deal")
+	}
+}
+
+func TestSmallCompactNeedsNoApproval(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "m1", "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+	setCommittee(t, s, "m1")
+	acceptedCompact(t, s, "c1", "alice", "p1")
+
+	if _, err := s.invoke("m1", "PowerTXContract:ApproveCompact", "c1", "m1"); err == nil {
+		t.Fatal("approved a small compact")
+	}
+
+	call(t, s, "m1", "PowerTXContract:Deal", "c1", "m1")
+}
+
+func TestApprovalsNeedEnoughMembers(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "m1", "m2", "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 20000000)
+	setCommittee(t, s, "m1", "m2", "p1")
+
+	commitCompact(t, s, "c1", "alice", 20000)
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	call(t, s, "m1", "PowerTXContract:ApproveCompact", "c1", "m1")
+	call(t, s, "m2", "PowerTXContract:ApproveCompact", "c1", "m2")
+
+	// 交易方不能批准，可以批准的成员少于3个时不能执行Deal
+	admin := adminAssignments(t, s, "c1")[0].AdminName
+
+	if _, err := s.invoke(admin, "PowerTXContract:Deal", "c1", admin); err == nil {
+		t.Fatal("deal with fewer eligible members than required")
+	}
+}
+
+func TestClearedLargeCompactNeedsApproval(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "m1", "m2", "m3", "u1")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "u1", 20000000)
+	setCommittee(t, s, "m1", "m2", "m3")
+	openMarket(t, s)
+
+	call(t, s, "u1", "MarketContract:SubmitBuyOrder", "D1", "b1", "u1", "20000", "0.6")
+	call(t, s, "p1", "MarketContract:SubmitSellOrder", "D1", "s1", "p1", "20000", "0.4")
+
+	s.advance(48 * time.Hour)
+	call(t, s, "admin", "MarketContract:ClearMarket", "D1")
+
+	// 出清生成的大额compact等待批准，由指派的admin执行Deal
+	compactId := generatedCompactId("market", "D1", "1")
+
+	if compact := queryCompact(t, s, compactId); compact.State != CompactAccepted {
+		t.Fatalf("compact state = %s", compact.State)
+	}
+
+	admin := adminAssignments(t, s, compactId)[0].AdminName
+
+	if _, err := s.invoke(admin, "PowerTXContract:Deal", compactId, admin); err == nil {
+		t.Fatal("deal without approvals")
+	}
+
+	for _, member := range []string{"m1", "m2", "m3"} {
+		call(t, s, member, "PowerTXContract:ApproveCompact", compactId, member)
+	}
+
+	call(t, s, admin, "PowerTXContract:Deal", compactId, admin)
+
+	if compact := queryCompact(t, s, compactId); compact.State != CompactDeal {
+		t.Fatalf("compact state = %s", compact.State)
+	}
+}
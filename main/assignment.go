@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+// AdminAssignment compact被接受后从委员会中指派的admin，只有被指派的admin可以在Deadline前执行Deal
+// State为 Assigned 已指派 Completed 已执行Deal Expired 超过期限被重新指派，Attempt从1开始
+type AdminAssignment struct {
+	CompactId 		string		`json:"compact_id"`
+	AdminName 		string		`json:"admin_name"`
+	Attempt 		int			`json:"attempt"`
+	State 			string		`json:"state"`
+	AssignTime 		string		`json:"assign_time"`
+	Deadline 		string		`json:"deadline"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// ReassignDealAdmin 指派的admin超过期限未执行Deal，或已不是委员会成员时，委员会成员或compact的交易方可以为compact重新指派admin
+// 委员会成立前被接受的compact也可以由此指派admin
+func (p *PowerTXContract) ReassignDealAdmin(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	userName string) (*AdminAssignment, error) {
+	// 1.判断调用者是否为userName本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, userName); err != nil {
+		return nil, err
+	}
+
+	// 2.获取compact交易信息，只有已接受的compact需要指派admin
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.State != CompactAccepted {
+		return nil, fmt.Errorf("Compact %s is %s, can not assign admin ! ", compact.CompactId, compact.State)
+	}
+
+	// 2.1调用者必须为当前委员会成员或compact的交易方
+	parties := map[string]bool{compact.PowerUserName: true}
+	for _, leg := range compact.compactLegs() {
+		parties[leg.PowerPlantName] = true
+	}
+
+	var e ElectionContract
+	if !e.isCommitteeMember(ctx, userName) && !parties[userName] {
+		return nil, fmt.Errorf("%s can not reassign admin of %s ! ", userName, compactId)
+	}
+
+	// 3.判断当前指派是否超过期限，被指派的admin已不是委员会成员时可以立即重新指派
+	assignments, err := p.QueryAdminAssignments(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(assignments) > 0 {
+		current := assignments[len(assignments) - 1]
+
+		if e.isCommitteeMember(ctx, current.AdminName) {
+			var t TimeContract
+			expired, err := t.CompareWithNow(ctx, current.Deadline)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !expired {
+				return nil, fmt.Errorf("%s is assigned to %s until %s ! ", current.AdminName, compactId, current.Deadline)
+			}
+		}
+
+		// 3.1当前指派过期
+		current.State = "Expired"
+
+		if err := p.putAdminAssignment(ctx, current); err != nil {
+			return nil, err
+		}
+	}
+
+	// 4.重新指派
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	assignment, err := p.assignAdmin(ctx, compact, assignments, variables)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if assignment == nil {
+		return nil, fmt.Errorf("Committee has no member to assign ! ")
+	}
+
+	return assignment, nil
+}
+
+// QueryAdminAssignments 获取compact的全部admin指派记录，按指派次数排序，最后一条为当前指派
+func (p *PowerTXContract) QueryAdminAssignments(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*AdminAssignment, error) {
+	// 1.按compactId查询指派记录
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(AdminAssignmentObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query AdminAssignment Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	assignments := []*AdminAssignment{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		assignment := new(AdminAssignment)
+		_ = json.Unmarshal(kv.Value, assignment)
+		assignments = append(assignments, assignment)
+	}
+
+	return assignments, nil
+}
+
+// currentAssignment 获取compact当前的admin指派，没有指派时返回nil
+func (p *PowerTXContract) currentAssignment(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (*AdminAssignment, error) {
+	assignments, err := p.QueryAdminAssignments(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(assignments) == 0 {
+		return nil, nil
+	}
+
+	return assignments[len(assignments) - 1], nil
+}
+
+// assignAdmin 以txId为种子从委员会成员中确定地选出compact的admin，compact的交易方不参与指派
+// 优先选择之前没有被指派过的成员，委员会没有可指派的成员时返回nil
+// txId由提交交易的客户端生成，调用者可以反复生成交易直到选出想要的成员，因此指派只用于分摊执行Deal的工作，
+// 不作为防止合谋的手段，大额compact仍需委员会成员批准，variables为交易开始时读取的治理参数
+func (p *PowerTXContract) assignAdmin(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact,
+	previous []*AdminAssignment,
+	variables map[string]int) (*AdminAssignment, error) {
+	// 1.获取委员会
+	var e ElectionContract
+	committee := e.QueryCommittee(ctx)
+
+	if committee == nil {
+		return nil, nil
+	}
+
+	// 2.排除compact的交易方
+	parties := map[string]bool{compact.PowerUserName: true}
+	for _, leg := range compact.compactLegs() {
+		parties[leg.PowerPlantName] = true
+	}
+
+	assigned := make(map[string]bool)
+	for _, assignment := range previous {
+		assigned[assignment.AdminName] = true
+	}
+
+	members, fresh := []string{}, []string{}
+	for _, member := range committee.Users {
+		if parties[member] {
+			continue
+		}
+
+		members = append(members, member)
+		if !assigned[member] {
+			fresh = append(fresh, member)
+		}
+	}
+
+	if len(fresh) > 0 {
+		members = fresh
+	}
+
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	// 3.按txId与compactId的哈希选出admin
+	txId := ctx.GetStub().GetTxID()
+	seed := sha256.Sum256([]byte(txId + "|" + compact.CompactId))
+	adminName := members[binary.BigEndian.Uint64(seed[:8]) % uint64(len(members))]
+
+	// 4.按指派期限计算截止时间
+	var t TimeContract
+	assignTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 5.指派记录上链
+	assignment := &AdminAssignment{
+		CompactId: compact.CompactId,
+		AdminName: adminName,
+		Attempt: len(previous) + 1,
+		State: "Assigned",
+		AssignTime: assignTime,
+		Deadline: t.addHours(assignTime, variables[DealAssignmentHours]),
+		TxId: txId,
+	}
+
+	if err := p.putAdminAssignment(ctx, assignment); err != nil {
+		return nil, err
+	}
+
+	return assignment, nil
+}
+
+// putAdminAssignment 指派记录上链，指派状态变化时发出事件
+func (p *PowerTXContract) putAdminAssignment(
+	ctx contractapi.TransactionContextInterface,
+	assignment *AdminAssignment) error {
+	// 1.上链
+	assignmentAsBytes, _ := json.Marshal(assignment)
+	err := putState(ctx, assignmentAsBytes, AdminAssignmentObjectType, assignment.CompactId, fmt.Sprintf("%03d", assignment.Attempt))
+
+	if err != nil {
+		return err
+	}
+
+	// 2.发出事件
+	oldState := ""
+	if assignment.State != "Assigned" {
+		oldState = "Assigned"
+	}
+
+	return emitEvent(ctx, events.AdminAssignmentChanged, events.AdminAssignmentEntity, assignment.CompactId,
+		oldState, assignment.State, []string{assignment.AdminName},
+		map[string]string{"attempt": fmt.Sprintf("%d", assignment.Attempt), "deadline": assignment.Deadline})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// acceptedCompact powerUser提交并接受plant对compactId的报价
+func acceptedCompact(t *testing.T, s *testStub, compactId string, userName string, plantName string) {
+	t.Helper()
+
+	commitCompact(t, s, compactId, userName, 100)
+	call(t, s, plantName, "PowerTXContract:Bid", compactId, plantName, "0.5")
+	call(t, s, userName, "PowerTXContract:Accept", compactId)
+}
+
+// adminAssignments 获取compact的admin指派记录
+func adminAssignments(t *testing.T, s *testStub, compactId string) []*AdminAssignment {
+	t.Helper()
+
+	var assignments []*AdminAssignment
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:QueryAdminAssignments", compactId), &assignments))
+
+	return assignments
+}
+
+// reassign userName通过路由重新指派compact的admin
+func reassign(s *testStub, userName string, compactId string) (*AdminAssignment, error) {
+	payload, err := s.invoke(userName, "PowerTXContract:ReassignDealAdmin", compactId, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	assignment := new(AdminAssignment)
+	return assignment, json.Unmarshal(payload, assignment)
+}
+
+func TestAssignDealAdmin(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "m1", "m2", "m3", "alice", "mallory")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+	setCommittee(t, s, "m1", "m2", "m3", "p1")
+	acceptedCompact(t, s, "c1", "alice", "p1")
+
+	// 接受后从委员会中指派admin，交易方不参与指派
+	assignments := adminAssignments(t, s, "c1")
+
+	if len(assignments) != 1 || assignments[0].AdminName == "p1" || assignments[0].State != "Assigned" {
+		t.Fatalf("assignments = %+v", assignments)
+	}
+
+	first := assignments[0].AdminName
+	other := "m1"
+	if first == "m1" {
+		other = "m2"
+	}
+
+	if _, err := s.invoke(other, "PowerTXContract:Deal", "c1", other); err == nil {
+		t.Fatal("deal by a member not assigned")
+	}
+
+	if _, err := reassign(s, "alice", "c1"); err == nil {
+		t.Fatal("reassigned before the deadline")
+	}
+
+	// 超过期限后被指派的admin不能执行Deal，只有委员会成员或交易方可以重新指派
+	s.advance(25 * time.Hour)
+
+	if _, err := s.invoke(first, "PowerTXContract:Deal", "c1", first); err == nil {
+		t.Fatal("deal after the deadline")
+	}
+
+	if _, err := reassign(s, "mallory", "c1"); err == nil {
+		t.Fatal("reassigned by an outsider")
+	}
+
+	assignment, err := reassign(s, "alice", "c1")
+	noError(t, err)
+
+	if assignment.AdminName == first || assignment.AdminName == "p1" || assignment.Attempt != 2 {
+		t.Fatalf("reassignment = %+v", assignment)
+	}
+
+	call(t, s, assignment.AdminName, "PowerTXContract:Deal", "c1", assignment.AdminName)
+
+	if compact := queryCompact(t, s, "c1"); compact.State != CompactDeal || compact.AdminName != assignment.AdminName {
+		t.Fatalf("compact = %+v", compact)
+	}
+
+	assignments = adminAssignments(t, s, "c1")
+
+	if len(assignments) != 2 || assignments[0].State != "Expired" || assignments[1].State != "Completed" {
+		t.Fatalf("assignments = %+v", assignments)
+	}
+}
+
+func TestReassignRemovedDealAdmin(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "m1", "m2", "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+	setCommittee(t, s, "m1", "m2")
+	acceptedCompact(t, s, "c1", "alice", "p1")
+
+	first := adminAssignments(t, s, "c1")[0].AdminName
+	remaining := "m1"
+	if first == "m1" {
+		remaining = "m2"
+	}
+
+	// 被指派的admin离开委员会后不能执行Deal，期限内也可以立即重新指派
+	setCommittee(t, s, remaining)
+
+	if _, err := s.invoke(first, "PowerTXContract:Deal", "c1", first); err == nil {
+		t.Fatal("deal by a removed member")
+	}
+
+	assignment, err := reassign(s, "alice", "c1")
+	noError(t, err)
+
+	if assignment.AdminName != remaining || assignment.Attempt != 2 {
+		t.Fatalf("reassignment = %+v", assignment)
+	}
+
+	call(t, s, remaining, "PowerTXContract:Deal", "c1", remaining)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
 	"sort"
 )
 
@@ -26,6 +27,7 @@ type BallotProposal struct {
 	EndTime 			string					`json:"end_time"`
 	Variable 			string                 	`json:"variable"`
 	Value               int                     `json:"value"`
+	Target              string                  `json:"target,omitempty" metadata:"target,optional"`
 	Result 				bool					`json:"result"`
 }
 
@@ -59,17 +61,24 @@ func (b *BallotContract) CreateBallotProposal(
 		return nil, fmt.Errorf("End time earlier than start time ! ")
 	}
 
-	// 3.查看proposer是否存在
+	// 3.查看proposer是否存在，且为调用者本人
 	var r RoleContract
-	proposer, err := r.QueryUser(ctx, proposerName)
+	proposer, err := r.checkCaller(ctx, proposerName)
 
 	if err != nil {
 		return nil, fmt.Errorf("query proposer false, %s", err.Error())
 	}
 
 	// 4.查看powerUser信用值， 若小于某个额度，则拒绝发起提案
-	if proposer.UserCredit - CreditBorder < 0 {
-		return nil, fmt.Errorf("proposer credit less than %d", CreditBorder)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if proposer.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("proposer credit less than %d", variables[CreditBorder])
 	}
 
 	// 5.获取候选人
@@ -83,7 +92,11 @@ func (b *BallotContract) CreateBallotProposal(
 			//获取用户
 			user, _ := r.QueryUser(ctx, userName)
 
-			votingProposals, _ := b.QueryVoterProposals(ctx, userName)
+			votingProposals, err := b.QueryVoterProposals(ctx, userName)
+			if err != nil {
+				votingProposals = new(VotingProposals)
+			}
+
     		proposal := VoteProposal{
 				Voted: 0,
 				ProposalName: ballotProposalName,
@@ -92,7 +105,7 @@ func (b *BallotContract) CreateBallotProposal(
 			votingProposalsAsBytes, _ := json.Marshal(votingProposals)
 
 			// 上链
-			err = ctx.GetStub().PutState("VotingProposals" + userName, votingProposalsAsBytes)
+			err = putState(ctx, votingProposalsAsBytes, VotingProposalsObjectType, userName)
 
 			if err != nil {
 				return nil, err
@@ -113,7 +126,10 @@ func (b *BallotContract) CreateBallotProposal(
 		for _, userName := range leagueUserList.Users {
 			//获取用户
 			user, _ := r.QueryUser(ctx, userName)
-			votingProposals, _ := b.QueryVoterProposals(ctx, userName)
+			votingProposals, err := b.QueryVoterProposals(ctx, userName)
+			if err != nil {
+				votingProposals = new(VotingProposals)
+			}
 
 			proposal := VoteProposal{
 				Voted: 0,
@@ -124,7 +140,7 @@ func (b *BallotContract) CreateBallotProposal(
 			votingProposalsAsBytes, _ := json.Marshal(votingProposals)
 
 			// 上链
-			err = ctx.GetStub().PutState("VotingProposals" + userName, votingProposalsAsBytes)
+			err = putState(ctx, votingProposalsAsBytes, VotingProposalsObjectType, userName)
 
 			if err != nil {
 				return nil, err
@@ -156,7 +172,15 @@ func (b *BallotContract) CreateBallotProposal(
 	ballotProposalAsBytes, _ := json.Marshal(ballotProposal)
 
 	// 9.上链
-	err = ctx.GetStub().PutState(ballotProposalName, ballotProposalAsBytes)
+	err = putState(ctx, ballotProposalAsBytes, BallotProposalObjectType, ballotProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 10.发出事件
+	err = emitEvent(ctx, events.BallotProposalStateChanged, events.BallotProposalEntity, ballotProposalName,
+		"", ballotProposal.State, []string{proposerName}, map[string]string{"proposal_type": proposalType})
 
 	if err != nil {
 		return nil, err
@@ -185,14 +209,20 @@ func (b *BallotContract) VoteBallotProposal(
 	}
 
 	// 3.判断是否到达选举时间
-	//var t TimeContract
-	//if !t.CompareWithNow(ballotProposal.StartTime) || t.CompareWithNow(ballotProposal.EndTime) {
-	//	return nil, fmt.Errorf("The proposal is not voting ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, ballotProposal.StartTime, ballotProposal.EndTime)
 
-	// 3.判断投票人是否存在
-	if !r.UserExist(ctx, voterName) {
-		return nil, fmt.Errorf("%s is not exist ! ", voterName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("The proposal is not voting ! ")
+	}
+
+	// 3.判断投票人是否存在，且为调用者本人
+	if _, err := r.checkCaller(ctx, voterName); err != nil {
+		return nil, err
 	}
 
 	// 4.获取投票人信息
@@ -222,7 +252,19 @@ func (b *BallotContract) VoteBallotProposal(
 
 	// 9.投票提案上链
 	ballotProposalAsBytes, _ := json.Marshal(ballotProposal)
-	err = ctx.GetStub().PutState(ballotProposalName, ballotProposalAsBytes)
+	err = putState(ctx, ballotProposalAsBytes, BallotProposalObjectType, ballotProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	voteDetail := "negative"
+	if vote {
+		voteDetail = "up"
+	}
+
+	err = emitEvent(ctx, events.BallotVoteCast, events.BallotProposalEntity, ballotProposalName,
+		ballotProposal.State, ballotProposal.State, []string{voterName}, map[string]string{"vote": voteDetail})
 
 	if err != nil {
 		return nil, err
@@ -248,19 +290,46 @@ func (b *BallotContract) VoteBallotProposal(
 	votingProposalsAsBytes, _ := json.Marshal(votingProposals)
 
 	// 上链
-	err = ctx.GetStub().PutState("VotingProposals" + voterName, votingProposalsAsBytes)
+	err = putState(ctx, votingProposalsAsBytes, VotingProposalsObjectType, voterName)
 
 	if err != nil {
 		return nil, err
 	}
 	// 9.更新信用值
-	_ = r.ChangeCredit(ctx, voterName, BallotAwardCredit)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = r.changeCredit(ctx, voterName, variables[BallotAwardCredit])
 
 	return ballotProposal, nil
 }
 
-// CheckBallotProposal 检查投票提案结果
+// CheckBallotProposal 检查投票提案结果，修改变量的提案只能由对应的检查交易结算
 func (b *BallotContract) CheckBallotProposal(
+	ctx contractapi.TransactionContextInterface,
+	ballotProposalName string) (*BallotProposal, error) {
+	// 1.判断是否为修改变量的提案
+	ballotProposal, err := b.QueryBallotProposal(ctx, ballotProposalName)
+
+	if err == nil {
+		if _, ok := defaultVariables[ballotProposal.Variable]; ok {
+			return nil, fmt.Errorf("%s must be checked by CheckChangeVariableProposal ! ", ballotProposalName)
+		}
+
+		if ballotProposal.Variable == EmissionFactorVariable {
+			return nil, fmt.Errorf("%s must be checked by CheckEmissionFactorProposal ! ", ballotProposalName)
+		}
+	}
+
+	return b.checkBallotProposal(ctx, ballotProposalName)
+}
+
+// checkBallotProposal 结算投票提案，每个提案只能结算一次
+func (b *BallotContract) checkBallotProposal(
 	ctx contractapi.TransactionContextInterface,
 	ballotProposalName string) (*BallotProposal, error) {
 	// 1.判断投票提案是否存在
@@ -275,13 +344,36 @@ func (b *BallotContract) CheckBallotProposal(
 		return nil, fmt.Errorf("Failed to query election proposal Info from world state. %s ", err.Error())
 	}
 
+	// 2.1判断调用者是否为注册用户
+	var r RoleContract
+	if _, err := r.QueryCaller(ctx); err != nil {
+		return nil, err
+	}
+
+	// 2.2过期的提案不能再结算
+	if ballotProposal.State == "Expired" {
+		return nil, fmt.Errorf("The proposal has expired ! ")
+	}
+
+	// 2.3已结算的提案不能再次结算，避免旧提案重复生效
+	if ballotProposal.State == "Done" {
+		return nil, fmt.Errorf("The proposal has been checked ! ")
+	}
+
 	// 3.判断是否到达投票时间
-	//var t TimeContract
-	//if !t.CompareWithNow(ballotProposal.EndTime) {
-	//	return nil, fmt.Errorf("The proposal is voting ! ")
-	//}
+	var t TimeContract
+	ended, err := t.CompareWithNow(ctx, ballotProposal.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ended {
+		return nil, fmt.Errorf("The proposal is voting ! ")
+	}
 
 	// 4.更改投票提案的状态
+	oldState := ballotProposal.State
 	ballotProposal.State = "Done"
 
 	if 	ballotProposal.NegativeVotes - ballotProposal.UpVotes < 0 &&
@@ -293,12 +385,23 @@ func (b *BallotContract) CheckBallotProposal(
 
 	// 5.投票提案上链
 	ballotProposalAsBytes, _ := json.Marshal(ballotProposal)
-	err = ctx.GetStub().PutState(ballotProposalName, ballotProposalAsBytes)
+	err = putState(ctx, ballotProposalAsBytes, BallotProposalObjectType, ballotProposalName)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// 6.发出事件
+	if oldState != ballotProposal.State {
+		err = emitEvent(ctx, events.BallotProposalStateChanged, events.BallotProposalEntity, ballotProposalName,
+			oldState, ballotProposal.State, []string{ballotProposal.ProposerName},
+			map[string]string{"result": fmt.Sprintf("%t", ballotProposal.Result)})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return ballotProposal, nil
 }
 
@@ -308,7 +411,7 @@ func (b *BallotContract) QueryBallotProposal(
 	ctx contractapi.TransactionContextInterface,
 	ballotProposalName string) (*BallotProposal, error) {
 	// 1.获取投票提案信息
-	ballotProposalAsBytes, err := ctx.GetStub().GetState(ballotProposalName)
+	ballotProposalAsBytes, err := getState(ctx, BallotProposalObjectType, ballotProposalName)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to query User Info from world state. %s", err.Error())
@@ -328,7 +431,7 @@ func (b *BallotContract) QueryBallotProposal(
 func (b *BallotContract) QueryVoterProposals(ctx contractapi.TransactionContextInterface,
 	voterName string) (*VotingProposals, error) {
 	// 1.获取投票提案信息
-	votingProposalsAsBytes, err := ctx.GetStub().GetState("VotingProposals" + voterName)
+	votingProposalsAsBytes, err := getState(ctx, VotingProposalsObjectType, voterName)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to query User Info from world state. %s ", err.Error())
@@ -353,7 +456,7 @@ func (b *BallotContract) QueryVoterProposals(ctx contractapi.TransactionContextI
 func (b *BallotContract) BallotProposalExist(
 	ctx contractapi.TransactionContextInterface,
 	ballotProposalName string) bool {
-	ballotProposalAsBytes, err := ctx.GetStub().GetState(ballotProposalName)
+	ballotProposalAsBytes, err := getState(ctx, BallotProposalObjectType, ballotProposalName)
 	// 1.获取投票提案
 	if err != nil {
 		return false
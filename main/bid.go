@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+// CompactBid powerPlant对compact的报价
+type CompactBid struct {
+	CompactId		string  	`json:"compact_id"`
+	PowerPlantName  string 		`json:"power_plant_name"`
+	Quantity 		int     	`json:"quantity"`
+	Price           Price 		`json:"price"`
+	State	    	string  	`json:"state"`
+	BidTime 		string		`json:"bid_time"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// QueryBid 获取powerPlant对compact的报价
+func (p *PowerTXContract) QueryBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string) (*CompactBid, error) {
+	// 1.获取报价信息
+	bidAsBytes, err := getState(ctx, BidObjectType, compactId, powerPlantName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Bid Info from world state. %s ", err.Error())
+	}
+
+	if bidAsBytes == nil {
+		return nil, fmt.Errorf("%s has not bid for %s ", powerPlantName, compactId)
+	}
+
+	// 2.赋值
+	bid := new(CompactBid)
+	_ = json.Unmarshal(bidAsBytes, bid)
+
+	return bid, nil
+}
+
+// QueryBids 获取compact的全部报价，按powerPlant名称排序
+func (p *PowerTXContract) QueryBids(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*CompactBid, error) {
+	// 1.按compactId查询报价
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(BidObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Bid Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	bids := []*CompactBid{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		bid := new(CompactBid)
+		_ = json.Unmarshal(kv.Value, bid)
+		bids = append(bids, bid)
+	}
+
+	return bids, nil
+}
+
+// queryActiveBids 获取compact仍在竞价中的报价
+func (p *PowerTXContract) queryActiveBids(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*CompactBid, error) {
+	bids, err := p.QueryBids(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	activeBids := []*CompactBid{}
+	for _, bid := range bids {
+		if bid.State == "Biding" {
+			activeBids = append(activeBids, bid)
+		}
+	}
+
+	return activeBids, nil
+}
+
+// putBid 报价上链，报价状态变化时发出事件
+func (p *PowerTXContract) putBid(
+	ctx contractapi.TransactionContextInterface,
+	bid *CompactBid) error {
+	// 1.获取旧的报价状态
+	oldState := ""
+	if oldBid, err := p.QueryBid(ctx, bid.CompactId, bid.PowerPlantName); err == nil {
+		oldState = oldBid.State
+	}
+
+	// 2.上链
+	bidAsBytes, _ := json.Marshal(bid)
+	err := putState(ctx, bidAsBytes, BidObjectType, bid.CompactId, bid.PowerPlantName)
+
+	if err != nil {
+		return err
+	}
+
+	// 3.发出事件
+	if oldState == bid.State {
+		return nil
+	}
+
+	return emitEvent(ctx, events.BidStateChanged, events.BidEntity, bid.CompactId+"/"+bid.PowerPlantName,
+		oldState, bid.State, []string{bid.PowerPlantName}, nil)
+}
+
+// acceptBids 按顺序接受报价，每个报价生成一个分段，直到覆盖compact的全部电量
+// compact全部覆盖后其余报价自动拒绝，否则compact继续接受报价
+func (p *PowerTXContract) acceptBids(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact,
+	activeBids []*CompactBid,
+	selectedBids []*CompactBid) (*Compact, error) {
+	// 1.逐个接受报价，报价电量超过剩余电量时只接受剩余部分
+	accepted := make(map[string]bool)
+	entries := []NegotiationEntry{}
+	escrow := int64(0)
+	for _, bid := range selectedBids {
+		remaining := compact.remainingTransaction()
+
+		if remaining <= 0 {
+			break
+		}
+
+		quantity := bid.Quantity
+		if quantity > remaining {
+			quantity = remaining
+		}
+
+		amount, err := bid.Price.Amount(quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		escrow, err = addAmount(escrow, amount)
+
+		if err != nil {
+			return nil, err
+		}
+
+		compact.Legs = append(compact.Legs, CompactLeg{
+			PowerPlantName: bid.PowerPlantName,
+			Quantity: quantity,
+			Price: bid.Price,
+		})
+
+		bid.State = "Accepted"
+		accepted[bid.PowerPlantName] = true
+		entries = append(entries, NegotiationEntry{
+			Action: "Accept",
+			Actor: compact.PowerUserName,
+			PowerPlantName: bid.PowerPlantName,
+			Quantity: quantity,
+			Price: bid.Price,
+		})
+
+		if err := p.putBid(ctx, bid); err != nil {
+			return nil, err
+		}
+	}
+
+	// 1.1托管powerUser为新分段支付的金额
+	var a AccountContract
+	_, err := a.updateAccount(ctx, compact.PowerUserName, []AccountEntry{{
+		EntryType: Escrow,
+		Amount: -escrow,
+		EscrowChange: escrow,
+		CompactId: compact.CompactId,
+	}})
+
+	if err != nil {
+		return nil, err
+	}
+
+	compact.Escrowed, err = addAmount(compact.Escrowed, escrow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 1.2记录协商过程
+	if err := p.appendNegotiation(ctx, compact, entries...); err != nil {
+		return nil, err
+	}
+
+	// 2.全部覆盖后拒绝其余报价
+	filled := compact.remainingTransaction() <= 0
+	biding := false
+	for _, bid := range activeBids {
+		if accepted[bid.PowerPlantName] {
+			continue
+		}
+
+		if !filled {
+			biding = true
+			continue
+		}
+
+		bid.State = "Rejected"
+
+		if err := p.putBid(ctx, bid); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3.compact交易结构体赋值
+	// 全部覆盖后Price为各分段的加权平均价格，单一分段时PowerPlantName为该分段的powerPlant
+	if filled {
+		amount := int64(0)
+		for _, leg := range compact.Legs {
+			legAmount, err := leg.Price.Amount(leg.Quantity)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if amount, err = addAmount(amount, legAmount); err != nil {
+				return nil, err
+			}
+		}
+
+		compact.Price = averagePrice(amount, compact.Transaction)
+		if len(compact.Legs) == 1 {
+			compact.PowerPlantName = compact.Legs[0].PowerPlantName
+		}
+
+		err = p.transition(ctx, compact, "Accept", CompactAccepted)
+
+		// 3.1从委员会中指派执行Deal的admin
+		var v VarChangeContract
+		var variables map[string]int
+		if err == nil {
+			variables, err = v.loadVariables(ctx)
+		}
+
+		if err == nil {
+			_, err = p.assignAdmin(ctx, compact, nil, variables)
+		}
+	} else if biding {
+		err = p.transition(ctx, compact, "Accept", CompactBiding)
+	} else {
+		err = p.transition(ctx, compact, "Accept", CompactCommitting)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 4.上链
+	err = p.putCompact(ctx, compact)
+
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	return compact, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+// bidStates 按powerPlant获取compact各报价的状态
+func bidStates(t *testing.T, s *testStub, compactId string) map[string]string {
+	t.Helper()
+
+	var p PowerTXContract
+	bids, err := p.QueryBids(s.ctx("alice"), compactId)
+	noError(t, err)
+
+	states := make(map[string]string)
+	for _, bid := range bids {
+		states[bid.PowerPlantName] = bid.State
+	}
+
+	return states
+}
+
+func TestMultipleBids(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1", "p2", "p3")
+	deposit(t, s, "alice", 100000)
+	commitCompact(t, s, "c1", "alice", 100)
+
+	var p PowerTXContract
+	noError(t, errOf(p.Bid(s.ctx("p1"), "c1", "p1", "0.6")))
+	noError(t, errOf(p.Bid(s.ctx("p2"), "c1", "p2", "0.55")))
+	noError(t, errOf(p.Bid(s.ctx("p3"), "c1", "p3", "0.52")))
+
+	// 每个powerPlant只能有一个竞价中的报价
+	if _, err := p.Bid(s.ctx("p2"), "c1", "p2", "0.5"); err == nil {
+		t.Fatal("second active bid accepted")
+	}
+
+	// powerPlant可以取消自己的报价
+	noError(t, errOf(p.CancelBid(s.ctx("p3"), "c1")))
+
+	if states := bidStates(t, s, "c1"); states["p3"] != "Canceled" {
+		t.Fatalf("p3 bid = %s, want Canceled", states["p3"])
+	}
+
+	// 接受价格最低的报价
+	compact, err := p.Accept(s.ctx("alice"), "c1")
+	noError(t, err)
+
+	if compact.State != "Accepted" || compact.PowerPlantName != "p2" || compact.Price != 550 {
+		t.Fatalf("accepted compact = %+v", compact)
+	}
+
+	states := bidStates(t, s, "c1")
+
+	if states["p1"] != "Rejected" || states["p2"] != "Accepted" || states["p3"] != "Canceled" {
+		t.Fatalf("bid states = %v", states)
+	}
+}
+
+func TestAcceptBidByPlant(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1", "p2")
+	deposit(t, s, "alice", 100000)
+	commitCompact(t, s, "c1", "alice", 100)
+
+	var p PowerTXContract
+	noError(t, errOf(p.Bid(s.ctx("p1"), "c1", "p1", "0.6")))
+	noError(t, errOf(p.Bid(s.ctx("p2"), "c1", "p2", "0.55")))
+
+	if _, err := s.invoke("p1", "PowerTXContract:AcceptBid", "c1", "p1"); err == nil {
+		t.Fatal("plant accepted its own bid")
+	}
+
+	if _, err := s.invoke("alice", "PowerTXContract:AcceptBid", "c1", "p3"); err == nil {
+		t.Fatal("accepted a missing bid")
+	}
+
+	compact, err := p.AcceptBid(s.ctx("alice"), "c1", "p1")
+	noError(t, err)
+
+	if compact.PowerPlantName != "p1" || compact.Price != 600 {
+		t.Fatalf("accepted compact = %+v", compact)
+	}
+
+	if states := bidStates(t, s, "c1"); states["p2"] != "Rejected" {
+		t.Fatalf("p2 bid = %s, want Rejected", states["p2"])
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+type CarbonContract struct {
+	contractapi.Contract
+}
+
+// EmissionFactorVariable 修改powerPlant排放因子的投票提案的变量名，提案的Target为powerPlant
+const EmissionFactorVariable string = "EmissionFactor"
+
+// EmissionFactor powerPlant的排放因子，单位为gCO2/kWh，由投票提案设置
+type EmissionFactor struct {
+	PowerPlantName  string 		`json:"power_plant_name"`
+	Factor 			int			`json:"factor"`
+	Version 		int			`json:"version"`
+	ProposalName 	string		`json:"proposal_name"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// CarbonEntry compact结算时记入用户的碳排放，powerUser为各分段的合计，powerPlant为本分段
+// Energy为实际供电量，单位为kWh，Emission单位为gCO2
+type CarbonEntry struct {
+	UserName 		string		`json:"user_name"`
+	CompactId 		string		`json:"compact_id"`
+	Energy 			int			`json:"energy"`
+	Emission 		int64		`json:"emission"`
+	StartTime 		string		`json:"start_time"`
+	EndTime 		string		`json:"end_time"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// CarbonFootprint 用户在一段时间内开始交割的compact的碳排放合计
+type CarbonFootprint struct {
+	UserName 		string			`json:"user_name"`
+	StartFrom 		string			`json:"start_from"`
+	StartTo 		string			`json:"start_to"`
+	Energy 			int				`json:"energy"`
+	Emission 		int64			`json:"emission"`
+	Entries 		[]*CarbonEntry	`json:"entries"`
+}
+
+// CreateEmissionFactorProposal 创建修改powerPlant排放因子的投票提案
+func (c *CarbonContract) CreateEmissionFactorProposal(
+	ctx contractapi.TransactionContextInterface,
+	ballotProposalName string,
+	proposerName string,
+	proposalType string,
+	startTime string,
+	endTime string,
+	powerPlantName string,
+	factor int) (*BallotProposal, error) {
+	// 1.检查powerPlant与排放因子
+	var r RoleContract
+	powerPlant, err := r.QueryUser(ctx, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerPlant.UserRole != PowerPlant {
+		return nil, fmt.Errorf("%s is not a powerPlant ! ", powerPlantName)
+	}
+
+	if factor < 0 {
+		return nil, fmt.Errorf("Emission factor can not be negative ! ")
+	}
+
+	// 2.发起提案
+	var b BallotContract
+	ballotProposal, err := b.CreateBallotProposal(ctx, ballotProposalName, proposerName, proposalType, startTime, endTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ballotProposal.Variable = EmissionFactorVariable
+	ballotProposal.Target = powerPlantName
+	ballotProposal.Value = factor
+
+	ballotProposalAsBytes, _ := json.Marshal(ballotProposal)
+
+	// 3.上链
+	err = putState(ctx, ballotProposalAsBytes, BallotProposalObjectType, ballotProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ballotProposal, nil
+}
+
+// CheckEmissionFactorProposal 检查投票结果，并更改powerPlant的排放因子
+func (c *CarbonContract) CheckEmissionFactorProposal(
+	ctx contractapi.TransactionContextInterface,
+	ballotProposalName string) (*BallotProposal, error) {
+	// 1.判断是否为修改排放因子的提案
+	var b BallotContract
+	ballotProposal, err := b.QueryBallotProposal(ctx, ballotProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ballotProposal.Variable != EmissionFactorVariable {
+		return nil, fmt.Errorf("%s is not an emission factor proposal ! ", ballotProposalName)
+	}
+
+	// 1.1检查结果，每个提案只结算一次
+	ballotProposal, err = b.checkBallotProposal(ctx, ballotProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.如果提案结果为true,更改powerPlant的排放因子
+	if ballotProposal.Result {
+		emissionFactor, err := c.QueryEmissionFactor(ctx, ballotProposal.Target)
+
+		if err != nil {
+			return nil, err
+		}
+
+		oldFactor := emissionFactor.Factor
+		emissionFactor.Factor = ballotProposal.Value
+		emissionFactor.Version++
+		emissionFactor.ProposalName = ballotProposalName
+		emissionFactor.TxId = ctx.GetStub().GetTxID()
+
+		// 2.1上链
+		emissionFactorAsBytes, _ := json.Marshal(emissionFactor)
+		err = putState(ctx, emissionFactorAsBytes, EmissionFactorObjectType, emissionFactor.PowerPlantName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// 2.2发出事件
+		err = emitEvent(ctx, events.EmissionFactorChanged, events.EmissionFactorEntity, emissionFactor.PowerPlantName,
+			fmt.Sprintf("%d", oldFactor), fmt.Sprintf("%d", emissionFactor.Factor), []string{ballotProposal.ProposerName},
+			map[string]string{"ballot_proposal_name": ballotProposalName, "version": fmt.Sprintf("%d", emissionFactor.Version)})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ballotProposal, nil
+}
+
+// QueryEmissionFactor 获取powerPlant的排放因子，未经提案设置时为DefaultEmissionFactor
+func (c *CarbonContract) QueryEmissionFactor(
+	ctx contractapi.TransactionContextInterface,
+	powerPlantName string) (*EmissionFactor, error) {
+	// 1.获取排放因子记录
+	emissionFactorAsBytes, err := getState(ctx, EmissionFactorObjectType, powerPlantName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query EmissionFactor Info from world state. %s ", err.Error())
+	}
+
+	// 2.链上没有记录，返回默认值
+	if emissionFactorAsBytes == nil {
+		var v VarChangeContract
+		variable, err := v.QueryVariable(ctx, DefaultEmissionFactor)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &EmissionFactor{
+			PowerPlantName: powerPlantName,
+			Factor: variable.Value,
+			Version: 0,
+		}, nil
+	}
+
+	// 3.赋值
+	emissionFactor := new(EmissionFactor)
+	_ = json.Unmarshal(emissionFactorAsBytes, emissionFactor)
+
+	return emissionFactor, nil
+}
+
+// QueryCarbonFootprint 获取用户在startFrom 与startTo 之间(包括两端)开始交割的compact的碳排放
+// powerUser可以据此报告范围二排放
+func (c *CarbonContract) QueryCarbonFootprint(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	startFrom string,
+	startTo string) (*CarbonFootprint, error) {
+	// 1.判断参数
+	var t TimeContract
+	if t.CompareTime(startTo, startFrom) {
+		return nil, fmt.Errorf("End time earlier than Start time ! ")
+	}
+
+	// 2.按用户查询碳排放记录，记录按开始时间排序
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(CarbonEntryObjectType, []string{userName})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query CarbonEntry Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 3.汇总时间段内的记录
+	footprint := &CarbonFootprint{
+		UserName: userName,
+		StartFrom: startFrom,
+		StartTo: startTo,
+		Entries: []*CarbonEntry{},
+	}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := new(CarbonEntry)
+		_ = json.Unmarshal(kv.Value, entry)
+
+		if t.CompareTime(entry.StartTime, startFrom) {
+			continue
+		}
+
+		if t.CompareTime(startTo, entry.StartTime) {
+			break
+		}
+
+		footprint.Energy += entry.Energy
+		footprint.Emission += entry.Emission
+		footprint.Entries = append(footprint.Entries, entry)
+	}
+
+	return footprint, nil
+}
+
+// recordEmissions compact结算后按各分段实际供电量与powerPlant排放因子计算碳排放，
+// 分别记入powerPlant和powerUser
+func (c *CarbonContract) recordEmissions(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact) error {
+	// 1.逐个分段计算碳排放
+	txId := ctx.GetStub().GetTxID()
+	userEntry := CarbonEntry{
+		UserName: compact.PowerUserName,
+		CompactId: compact.CompactId,
+		StartTime: compact.StartTime,
+		EndTime: compact.EndTime,
+		TxId: txId,
+	}
+
+	entries := []CarbonEntry{}
+	for _, leg := range compact.compactLegs() {
+		emissionFactor, err := c.QueryEmissionFactor(ctx, leg.PowerPlantName)
+
+		if err != nil {
+			return err
+		}
+
+		emission := int64(leg.Delivered) * int64(emissionFactor.Factor)
+		entries = append(entries, CarbonEntry{
+			UserName: leg.PowerPlantName,
+			CompactId: compact.CompactId,
+			Energy: leg.Delivered,
+			Emission: emission,
+			StartTime: compact.StartTime,
+			EndTime: compact.EndTime,
+			TxId: txId,
+		})
+
+		userEntry.Energy += leg.Delivered
+		userEntry.Emission += emission
+	}
+
+	// 2.上链
+	for _, entry := range append(entries, userEntry) {
+		entryAsBytes, _ := json.Marshal(entry)
+		err := putState(ctx, entryAsBytes, CarbonEntryObjectType, entry.UserName, entry.StartTime, entry.CompactId)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
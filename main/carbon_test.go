@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// carbonFootprint 获取用户在2026年开始交割的compact的碳排放
+func carbonFootprint(t *testing.T, s *testStub, userName string, startFrom string) *CarbonFootprint {
+	t.Helper()
+
+	var c CarbonContract
+	footprint, err := c.QueryCarbonFootprint(s.ctx(userName), userName, startFrom, "2026-12-31 00:00:00")
+	noError(t, err)
+
+	return footprint
+}
+
+// passEmissionFactor 全体用户投票通过powerPlant的排放因子
+func passEmissionFactor(t *testing.T, s *testStub, proposalName string, powerPlantName string, factor int, voters ...string) {
+	t.Helper()
+
+	call(t, s, "admin", "CarbonContract:CreateEmissionFactorProposal", proposalName, "admin", "Public",
+		s.timeNow(), s.now.Add(time.Hour).Format(TimeLayout), powerPlantName, strconv.Itoa(factor))
+
+	s.advance(time.Minute)
+	for _, voter := range voters {
+		call(t, s, voter, "BallotContract:VoteBallotProposal", proposalName, voter, "true")
+	}
+
+	s.advance(2 * time.Hour)
+	call(t, s, "admin", "CarbonContract:CheckEmissionFactorProposal", proposalName)
+}
+
+func TestEmissionFactorProposal(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+
+	if _, err := s.invoke("admin", "CarbonContract:CreateEmissionFactorProposal", "ef0", "admin", "Public",
+		s.timeNow(), s.now.Add(time.Hour).Format(TimeLayout), "alice", "900"); err == nil {
+		t.Fatal("emission factor proposed for a power user")
+	}
+
+	if _, err := s.invoke("admin", "CarbonContract:CreateEmissionFactorProposal", "ef0", "admin", "Public",
+		s.timeNow(), s.now.Add(time.Hour).Format(TimeLayout), "p1", "-1"); err == nil {
+		t.Fatal("negative emission factor proposed")
+	}
+
+	passEmissionFactor(t, s, "ef1", "p1", 900, "admin", "alice", "p1")
+
+	var c CarbonContract
+	factor, err := c.QueryEmissionFactor(s.ctx("admin"), "p1")
+	noError(t, err)
+
+	if factor.Factor != 900 || factor.Version != 1 || factor.ProposalName != "ef1" {
+		t.Fatalf("p1 factor = %+v", factor)
+	}
+
+	// 新提案生效后，旧提案不能再次结算把排放因子改回去
+	passEmissionFactor(t, s, "ef2", "p1", 700, "admin", "alice", "p1")
+
+	if _, err := s.invoke("admin", "CarbonContract:CheckEmissionFactorProposal", "ef1"); err == nil {
+		t.Fatal("old emission factor proposal checked again")
+	}
+
+	if factor, _ := c.QueryEmissionFactor(s.ctx("admin"), "p1"); factor.Factor != 700 || factor.Version != 2 {
+		t.Fatalf("p1 factor = %+v", factor)
+	}
+}
+
+func TestCarbonFootprint(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1", "p2")
+	deposit(t, s, "alice", 1000000)
+	passEmissionFactor(t, s, "ef1", "p2", 900, "admin", "alice", "p1", "p2")
+
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "p1", "PowerTXContract:PartialBid", "c1", "p1", "60", "0.5")
+	call(t, s, "p2", "PowerTXContract:PartialBid", "c1", "p2", "40", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+	meterReading(t, s, "alice", "c1", 100)
+	meterReading(t, s, "p1", "c1", 60)
+	meterReading(t, s, "p2", "c1", 40)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	// p1未设置排放因子，按默认值计算
+	emission := int64(60*defaultVariables[DefaultEmissionFactor] + 40*900)
+
+	if footprint := carbonFootprint(t, s, "alice", "2026-01-01 00:00:00"); footprint.Energy != 100 || footprint.Emission != emission || len(footprint.Entries) != 1 {
+		t.Fatalf("alice footprint = %+v", footprint)
+	}
+
+	if footprint := carbonFootprint(t, s, "p2", "2026-01-01 00:00:00"); footprint.Energy != 40 || footprint.Emission != 40*900 {
+		t.Fatalf("p2 footprint = %+v", footprint)
+	}
+
+	// 按compact开始交割的时间筛选
+	if footprint := carbonFootprint(t, s, "alice", "2026-01-02 00:00:00"); len(footprint.Entries) != 0 || footprint.Emission != 0 {
+		t.Fatalf("footprint after the start = %+v", footprint)
+	}
+}
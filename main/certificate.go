@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+type CertificateContract struct {
+	contractapi.Contract
+}
+
+// Certificate 绿色电力证书，每个证书对应一个可再生能源分段的交割电量，不可分割
+// Quantity为证书电量，单位为kWh，1000kWh为1MWh
+type Certificate struct {
+	CertificateId 	string		`json:"certificate_id"`
+	CompactId 		string		`json:"compact_id"`
+	PowerPlantName  string 		`json:"power_plant_name"`
+	SourceType 		string		`json:"source_type"`
+	Quantity 		int			`json:"quantity"`
+	StartTime 		string		`json:"start_time"`
+	EndTime 		string		`json:"end_time"`
+	Owner 			string		`json:"owner"`
+	State 			string		`json:"state"`
+	IssueTime 		string		`json:"issue_time"`
+	RetireTime 		string		`json:"retire_time"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// Solar 光伏 Wind 风电 Hydro 水电 Coal 煤电 Gas 气电
+const Solar string = "solar"
+const Wind string = "wind"
+const Hydro string = "hydro"
+const Coal string = "coal"
+const Gas string = "gas"
+
+// sourceTypes 发电类型，值为是否为可再生能源
+var sourceTypes = map[string]bool{
+	Solar: true,
+	Wind:  true,
+	Hydro: true,
+	Coal:  false,
+	Gas:   false,
+}
+
+// CertificateByOwnerIndex 证书按持有人的索引，索引键的最后一个属性为certificateId，值为一个0x00字节
+const CertificateByOwnerIndex string = "CertificateByOwner"
+
+// DeclareSourceType powerPlant声明发电类型，声明后不能更改
+func (c *CertificateContract) DeclareSourceType(
+	ctx contractapi.TransactionContextInterface,
+	powerPlantName string,
+	sourceType string) (*User, error) {
+	// 1.判断发电类型
+	if _, ok := sourceTypes[sourceType]; !ok {
+		return nil, fmt.Errorf("Source type %s is not right ! ", sourceType)
+	}
+
+	// 2.判断powerPlant是否为调用者本人
+	var r RoleContract
+	powerPlant, err := r.checkCaller(ctx, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerPlant.UserRole != PowerPlant {
+		return nil, fmt.Errorf("%s is not a powerPlant ! ", powerPlantName)
+	}
+
+	// 3.发电类型只能声明一次
+	if powerPlant.SourceType != "" {
+		return nil, fmt.Errorf("Source type of %s has been declared ! ", powerPlantName)
+	}
+
+	// 4.上链
+	powerPlant.SourceType = sourceType
+	powerPlantAsBytes, _ := json.Marshal(powerPlant)
+	err = putState(ctx, powerPlantAsBytes, UserObjectType, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return powerPlant, nil
+}
+
+// TransferCertificate 持有人转让证书，已注销的证书不能转让
+func (c *CertificateContract) TransferCertificate(
+	ctx contractapi.TransactionContextInterface,
+	certificateId string,
+	newOwner string) (*Certificate, error) {
+	// 1.获取证书
+	certificate, err := c.QueryCertificate(ctx, certificateId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为持有人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, certificate.Owner); err != nil {
+		return nil, err
+	}
+
+	// 3.判断证书状态与受让人
+	if certificate.State != "Active" {
+		return nil, fmt.Errorf("Certificate %s is %s ! ", certificateId, certificate.State)
+	}
+
+	if !r.UserExist(ctx, newOwner) {
+		return nil, fmt.Errorf("%s does not exist", newOwner)
+	}
+
+	if newOwner == certificate.Owner {
+		return nil, fmt.Errorf("%s already owns %s ! ", newOwner, certificateId)
+	}
+
+	// 4.上链
+	oldOwner := certificate.Owner
+	certificate.Owner = newOwner
+	certificate.TxId = ctx.GetStub().GetTxID()
+
+	err = c.putCertificate(ctx, certificate, oldOwner)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 5.发出事件
+	err = emitEvent(ctx, events.CertificateTransferred, events.CertificateEntity, certificateId,
+		oldOwner, newOwner, []string{oldOwner, newOwner}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
+
+// RetireCertificate 持有人注销证书，声明已消费证书对应的绿色电力，每个证书只能注销一次
+func (c *CertificateContract) RetireCertificate(
+	ctx contractapi.TransactionContextInterface,
+	certificateId string) (*Certificate, error) {
+	// 1.获取证书
+	certificate, err := c.QueryCertificate(ctx, certificateId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为持有人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, certificate.Owner); err != nil {
+		return nil, err
+	}
+
+	// 3.已注销的证书不能再次注销
+	if certificate.State != "Active" {
+		return nil, fmt.Errorf("Certificate %s is %s ! ", certificateId, certificate.State)
+	}
+
+	// 4.结构体赋值
+	var t TimeContract
+	retireTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	certificate.State = "Retired"
+	certificate.RetireTime = retireTime
+	certificate.TxId = ctx.GetStub().GetTxID()
+
+	// 5.上链
+	err = c.putCertificate(ctx, certificate, certificate.Owner)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 6.发出事件
+	err = emitEvent(ctx, events.CertificateStateChanged, events.CertificateEntity, certificateId,
+		"Active", certificate.State, []string{certificate.Owner}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return certificate, nil
+}
+
+// QueryCertificate 获取证书
+func (c *CertificateContract) QueryCertificate(
+	ctx contractapi.TransactionContextInterface,
+	certificateId string) (*Certificate, error) {
+	// 1.获取证书信息
+	certificateAsBytes, err := getState(ctx, CertificateObjectType, certificateId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Certificate Info from world state. %s ", err.Error())
+	}
+
+	if certificateAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", certificateId)
+	}
+
+	// 2.赋值
+	certificate := new(Certificate)
+	_ = json.Unmarshal(certificateAsBytes, certificate)
+
+	return certificate, nil
+}
+
+// QueryCertificatesByOwner 获取持有人的全部证书，包括已注销的证书
+func (c *CertificateContract) QueryCertificatesByOwner(
+	ctx contractapi.TransactionContextInterface,
+	owner string) ([]*Certificate, error) {
+	// 1.按持有人查询索引
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(CertificateByOwnerIndex, []string{owner})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Certificate index from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.获取索引指向的证书
+	certificates := []*Certificate{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+
+		if err != nil || len(attributes) != 2 {
+			continue
+		}
+
+		certificate, err := c.QueryCertificate(ctx, attributes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	return certificates, nil
+}
+
+// issueCertificates compact结算后为可再生能源分段发放证书，持有人为powerUser
+// 证书电量为分段实际供电量，不超过分段合同电量
+func (c *CertificateContract) issueCertificates(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact) ([]*Certificate, error) {
+	// 1.获取发放时间
+	var t TimeContract
+	issueTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.逐个分段发放
+	var r RoleContract
+	certificates := []*Certificate{}
+	for _, leg := range compact.compactLegs() {
+		quantity := leg.Delivered
+		if quantity > leg.Quantity {
+			quantity = leg.Quantity
+		}
+
+		if quantity <= 0 {
+			continue
+		}
+
+		// 2.1只有可再生能源powerPlant发放证书
+		powerPlant, err := r.QueryUser(ctx, leg.PowerPlantName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !sourceTypes[powerPlant.SourceType] {
+			continue
+		}
+
+		// 2.2每个compact的每个分段只发放一次
+		certificateId, err := c.certificateId(ctx, compact.CompactId, leg.PowerPlantName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := c.QueryCertificate(ctx, certificateId); err == nil {
+			return nil, fmt.Errorf("Certificate %s existed ! ", certificateId)
+		}
+
+		certificate := &Certificate{
+			CertificateId: certificateId,
+			CompactId: compact.CompactId,
+			PowerPlantName: leg.PowerPlantName,
+			SourceType: powerPlant.SourceType,
+			Quantity: quantity,
+			StartTime: compact.StartTime,
+			EndTime: compact.EndTime,
+			Owner: compact.PowerUserName,
+			State: "Active",
+			IssueTime: issueTime,
+			TxId: ctx.GetStub().GetTxID(),
+		}
+
+		// 2.3上链并发出事件
+		if err := c.putCertificate(ctx, certificate, ""); err != nil {
+			return nil, err
+		}
+
+		err = emitEvent(ctx, events.CertificateStateChanged, events.CertificateEntity, certificateId,
+			"", certificate.State, []string{leg.PowerPlantName, compact.PowerUserName}, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	return certificates, nil
+}
+
+// certificateId compact分段的证书ID，为compactId与powerPlantName组合键的SHA-256摘要
+// 组合键的属性之间以0x00分隔，属性中不能包含0x00，不同的分段不会得到相同的证书ID
+func (c *CertificateContract) certificateId(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string) (string, error) {
+	key, err := createKey(ctx, CertificateObjectType, compactId, powerPlantName)
+
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// putCertificate 证书上链，并更新持有人索引，oldOwner为证书原持有人，新发放的证书为空
+func (c *CertificateContract) putCertificate(
+	ctx contractapi.TransactionContextInterface,
+	certificate *Certificate,
+	oldOwner string) error {
+	// 1.证书上链
+	certificateAsBytes, _ := json.Marshal(certificate)
+	err := putState(ctx, certificateAsBytes, CertificateObjectType, certificate.CertificateId)
+
+	if err != nil {
+		return err
+	}
+
+	// 2.删除原持有人的索引
+	if oldOwner != "" && oldOwner != certificate.Owner {
+		indexKey, err := createKey(ctx, CertificateByOwnerIndex, oldOwner, certificate.CertificateId)
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().DelState(indexKey); err != nil {
+			return err
+		}
+	}
+
+	// 3.写入持有人索引，值为0x00字节
+	return putState(ctx, []byte{0x00}, CertificateByOwnerIndex, certificate.Owner, certificate.CertificateId)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// ownedCertificates 获取持有人的全部证书
+func ownedCertificates(t *testing.T, s *testStub, owner string) []*Certificate {
+	t.Helper()
+
+	var c CertificateContract
+	certificates, err := c.QueryCertificatesByOwner(s.ctx(owner), owner)
+	noError(t, err)
+
+	return certificates
+}
+
+func TestDeclareSourceType(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "wind")
+
+	if _, err := s.invoke("alice", "CertificateContract:DeclareSourceType", "alice", Solar); err == nil {
+		t.Fatal("power user declared a source type")
+	}
+
+	if _, err := s.invoke("wind", "CertificateContract:DeclareSourceType", "wind", "nuclear"); err == nil {
+		t.Fatal("unknown source type declared")
+	}
+
+	call(t, s, "wind", "CertificateContract:DeclareSourceType", "wind", Wind)
+
+	if _, err := s.invoke("wind", "CertificateContract:DeclareSourceType", "wind", Coal); err == nil {
+		t.Fatal("source type declared twice")
+	}
+
+	if user := queryUser(t, s, "wind"); user.SourceType != Wind {
+		t.Fatalf("wind source type = %s", user.SourceType)
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice", "bob")
+	register(t, s, PowerPlant, "wind", "coal")
+	deposit(t, s, "alice", 1000000)
+	call(t, s, "wind", "CertificateContract:DeclareSourceType", "wind", Wind)
+	call(t, s, "coal", "CertificateContract:DeclareSourceType", "coal", Coal)
+
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "wind", "PowerTXContract:PartialBid", "c1", "wind", "60", "0.5")
+	call(t, s, "coal", "PowerTXContract:PartialBid", "c1", "coal", "40", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+	meterReading(t, s, "alice", "c1", 100)
+	meterReading(t, s, "wind", "c1", 70)
+	meterReading(t, s, "coal", "c1", 40)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	// 只有可再生能源分段发放证书，超额交割的电量不计入证书
+	certificates := ownedCertificates(t, s, "alice")
+
+	if len(certificates) != 1 {
+		t.Fatalf("certificates = %+v", certificates)
+	}
+
+	certificate := certificates[0]
+	certificateId := certificate.CertificateId
+
+	if certificate.CompactId != "c1" || certificate.PowerPlantName != "wind" || certificate.SourceType != Wind || certificate.Quantity != 60 || certificate.State != "Active" {
+		t.Fatalf("certificate = %+v", certificate)
+	}
+
+	// 只有持有人可以转让
+	if _, err := s.invoke("bob", "CertificateContract:TransferCertificate", certificateId, "bob"); err == nil {
+		t.Fatal("certificate transferred by a non-owner")
+	}
+
+	call(t, s, "alice", "CertificateContract:TransferCertificate", certificateId, "bob")
+
+	if certificates := ownedCertificates(t, s, "alice"); len(certificates) != 0 {
+		t.Fatalf("alice certificates = %+v", certificates)
+	}
+
+	call(t, s, "bob", "CertificateContract:RetireCertificate", certificateId)
+
+	var c CertificateContract
+	certificate, err := c.QueryCertificate(s.ctx("bob"), certificateId)
+	noError(t, err)
+
+	if certificate.Owner != "bob" || certificate.State != "Retired" || certificate.RetireTime == "" {
+		t.Fatalf("retired certificate = %+v", certificate)
+	}
+
+	// 已注销的证书不能再次注销或转让
+	if _, err := s.invoke("bob", "CertificateContract:RetireCertificate", certificateId); err == nil {
+		t.Fatal("certificate retired twice")
+	}
+
+	if _, err := s.invoke("bob", "CertificateContract:TransferCertificate", certificateId, "alice"); err == nil {
+		t.Fatal("retired certificate transferred")
+	}
+
+	if certificates := ownedCertificates(t, s, "bob"); len(certificates) != 1 {
+		t.Fatalf("bob certificates = %+v", certificates)
+	}
+}
+
+func TestCertificateIdsDoNotCollide(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "z", "y-z")
+	deposit(t, s, "alice", 1000000)
+
+	// 以"-"拼接时w-y与z、w与y-z的证书ID相同
+	for compactId, powerPlantName := range map[string]string{"w-y": "z", "w": "y-z"} {
+		call(t, s, powerPlantName, "CertificateContract:DeclareSourceType", powerPlantName, Solar)
+		dealCompact(t, s, compactId, "alice", powerPlantName, 100)
+	}
+
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+	for compactId, powerPlantName := range map[string]string{"w-y": "z", "w": "y-z"} {
+		meterReading(t, s, "alice", compactId, 100)
+		meterReading(t, s, powerPlantName, compactId, 100)
+		call(t, s, "admin", "PowerTXContract:CheckCompact", compactId)
+	}
+
+	certificates := ownedCertificates(t, s, "alice")
+
+	if len(certificates) != 2 || certificates[0].CertificateId == certificates[1].CertificateId {
+		t.Fatalf("certificates = %+v", certificates)
+	}
+}
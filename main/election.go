@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
 	"sort"
+	"strings"
 )
 
 type ElectionContract struct {
@@ -61,17 +63,24 @@ func (e *ElectionContract) CreateElectionProposal(
 		return nil, fmt.Errorf("End time earlier than start time ! ")
 	}
 
-	// 3.查看proposer是否存在
+	// 3.查看proposer是否存在，且为调用者本人
 	var r RoleContract
-	proposer, err := r.QueryUser(ctx, proposerName)
+	proposer, err := r.checkCaller(ctx, proposerName)
 
 	if err != nil {
 		return nil, fmt.Errorf("Query proposer false, %s ", err.Error())
 	}
 
 	// 4.查看powerUser信用值， 若小于某个额度，则拒绝发起提案
-	if proposer.UserCredit - CreditBorder < 0 {
-		return nil, fmt.Errorf("proposer credit less than %d ", CreditBorder)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if proposer.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("proposer credit less than %d ", variables[CreditBorder])
 	}
 
 	// 5.获取候选人与投票人
@@ -113,12 +122,20 @@ func (e *ElectionContract) CreateElectionProposal(
 
 	electionProposalAsBytes, _ := json.Marshal(electionProposal)
 	// 7.上链
-	err = ctx.GetStub().PutState(electionProposalName, electionProposalAsBytes)
+	err = putState(ctx, electionProposalAsBytes, ElectionProposalObjectType, electionProposalName)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
 	}
 
+	// 8.发出事件
+	err = emitEvent(ctx, events.ElectionProposalStateChanged, events.ElectionProposalEntity, electionProposalName,
+		"", electionProposal.State, []string{proposerName}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
 	return &electionProposal, nil
 }
 
@@ -141,14 +158,20 @@ func (e *ElectionContract) VoteElectionProposal(
 	}
 
 	// 3.判断是否到达选举时间
-	//var t TimeContract
-	//if !t.CompareWithNow(electionProposal.StartTime) || t.CompareWithNow(electionProposal.EndTime) {
-	//	return nil, fmt.Errorf("The proposal is not voting ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, electionProposal.StartTime, electionProposal.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
 
-	// 4.判断投票人是否存在
-	if !r.UserExist(ctx, voterName) {
-		return nil, fmt.Errorf("%s is not exist ! ", voterName)
+	if !inPeriod {
+		return nil, fmt.Errorf("The proposal is not voting ! ")
+	}
+
+	// 4.判断投票人是否存在，且为调用者本人
+	if _, err := r.checkCaller(ctx, voterName); err != nil {
+		return nil, err
 	}
 
 	// 5.获取投票人信息
@@ -184,14 +207,28 @@ func (e *ElectionContract) VoteElectionProposal(
 	// 11.选举提案上链
 	electionProposalAsBytes, _ := json.Marshal(electionProposal)
 
-	err = ctx.GetStub().PutState(electionProposalName, electionProposalAsBytes)
+	err = putState(ctx, electionProposalAsBytes, ElectionProposalObjectType, electionProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = emitEvent(ctx, events.ElectionVoteCast, events.ElectionProposalEntity, electionProposalName,
+		electionProposal.State, electionProposal.State, []string{voterName, candidateName}, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
 	// 12.更新信用值
-	_ = r.ChangeCredit(ctx, voterName, BallotAwardCredit)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_ = r.changeCredit(ctx, voterName, variables[BallotAwardCredit])
 
 	return electionProposal, nil
 }
@@ -213,13 +250,35 @@ func (e *ElectionContract) CheckElectionProposal(
 		return nil, fmt.Errorf("Failed to query election proposal Info from world state. %s ", err.Error())
 	}
 
+	// 2.1判断调用者是否为注册用户
+	if _, err := r.QueryCaller(ctx); err != nil {
+		return nil, err
+	}
+
+	// 2.2过期的提案不能再结算
+	if electionProposal.State == "Expired" {
+		return nil, fmt.Errorf("The proposal has expired ! ")
+	}
+
+	// 2.3已结算的提案不能再次结算，避免旧选举覆盖新委员会
+	if electionProposal.State == "Done" {
+		return nil, fmt.Errorf("The proposal has been checked ! ")
+	}
+
 	// 3.判断是否到达选举时间
-	//var t TimeContract
-	//if !t.CompareWithNow(electionProposal.EndTime) {
-	//	return nil, fmt.Errorf("The proposal is voting ! ")
-	//}
+	var t TimeContract
+	ended, err := t.CompareWithNow(ctx, electionProposal.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ended {
+		return nil, fmt.Errorf("The proposal is voting ! ")
+	}
 
 	// 4.更改选举提案的状态
+	oldState := electionProposal.State
 	electionProposal.State = "Done"
 
 	// 5.新建委员会
@@ -237,26 +296,64 @@ func (e *ElectionContract) CheckElectionProposal(
 	})
 
 	// 8.选出委员会成员
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
 	k := 0
-	for _, v := range candidates {
-		if k == CommitteeMemberNumber {
+	for _, candidate := range candidates {
+		if k == variables[CommitteeMemberNumber] {
 			break
 		}
 
-		candidateName := v.CandidateName
+		candidateName := candidate.CandidateName
 		user, _ := r.QueryUser(ctx, candidateName)
 		committee.Users = append(committee.Users, user.UserName)
 		k++
 	}
 
 	// 9.委员会成员上链
+	oldMembers := ""
+	if oldCommittee := e.QueryCommittee(ctx); oldCommittee != nil {
+		oldMembers = strings.Join(oldCommittee.Users, ",")
+	}
+
 	committeeListAsBytes, _ := json.Marshal(committee)
-	err1 := ctx.GetStub().PutState("COMMITTEE", committeeListAsBytes)
+	err1 := putState(ctx, committeeListAsBytes, CommitteeObjectType)
 
 	if err1 != nil {
 		return nil, err1
 	}
 
+	err = emitEvent(ctx, events.CommitteeChanged, events.CommitteeEntity, CommitteeObjectType,
+		oldMembers, strings.Join(committee.Users, ","), committee.Users,
+		map[string]string{"election_proposal_name": electionProposalName})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 10.选举提案上链
+	electionProposalAsBytes, _ := json.Marshal(electionProposal)
+	err = putState(ctx, electionProposalAsBytes, ElectionProposalObjectType, electionProposalName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 11.发出事件
+	if oldState != electionProposal.State {
+		err = emitEvent(ctx, events.ElectionProposalStateChanged, events.ElectionProposalEntity, electionProposalName,
+			oldState, electionProposal.State, []string{electionProposal.ProposerName}, nil)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return committee, nil
 }
 
@@ -265,7 +362,7 @@ func (e *ElectionContract) QueryElectionProposal(
 	ctx contractapi.TransactionContextInterface,
 	electionProposalName string) (*ElectionProposal, error) {
 	// 1.获取选举提案信息
-	electionProposalAsBytes, err := ctx.GetStub().GetState(electionProposalName)
+	electionProposalAsBytes, err := getState(ctx, ElectionProposalObjectType, electionProposalName)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to query User Info from world state. %s ", err.Error())
@@ -286,7 +383,7 @@ func (e *ElectionContract) ElectionProposalExist(
 	ctx contractapi.TransactionContextInterface,
 	electionProposalName string) bool {
 	// 1.获取选举提案
-	electionProposalAsBytes, err := ctx.GetStub().GetState(electionProposalName)
+	electionProposalAsBytes, err := getState(ctx, ElectionProposalObjectType, electionProposalName)
 
 	// 2.如果选举提案不存在，或者获取选举提案失败，返回false
 	if err != nil {
@@ -304,7 +401,7 @@ func (e *ElectionContract) ElectionProposalExist(
 func (e *ElectionContract) QueryCommittee(
 	ctx contractapi.TransactionContextInterface) *Committee {
 	// 1.获取选举提案信息
-	committeeAsBytes, err := ctx.GetStub().GetState("COMMITTEE")
+	committeeAsBytes, err := getState(ctx, CommitteeObjectType)
 
 	if err != nil {
 		return nil
@@ -318,4 +415,26 @@ func (e *ElectionContract) QueryCommittee(
 	_ = json.Unmarshal(committeeAsBytes, committee)
 
 	return committee
-}
\ No newline at end of file
+}
+
+// isCommitteeMember 判断用户是否为委员会成员
+func (e *ElectionContract) isCommitteeMember(
+	ctx contractapi.TransactionContextInterface,
+	userName string) bool {
+	// 1.获取委员会
+	committee := e.QueryCommittee(ctx)
+
+	// 2.委员会不存在，返回false
+	if committee == nil {
+		return false
+	}
+
+	// 3.判断用户是否在委员会中
+	for _, member := range committee.Users {
+		if member == userName {
+			return true
+		}
+	}
+
+	return false
+}
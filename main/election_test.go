@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// elect 全体投票人选举candidateName，admin结算后返回新的委员会
+func elect(t *testing.T, s *testStub, proposalName string, candidateName string, voters ...string) *Committee {
+	t.Helper()
+
+	call(t, s, voters[0], "ElectionContract:CreateElectionProposal", proposalName, voters[0],
+		s.timeNow(), s.now.Add(time.Hour).Format(TimeLayout))
+
+	s.advance(time.Minute)
+	for _, voter := range voters {
+		call(t, s, voter, "ElectionContract:VoteElectionProposal", proposalName, voter, candidateName)
+	}
+
+	s.advance(2 * time.Hour)
+	committee := new(Committee)
+	noError(t, json.Unmarshal(call(t, s, "admin", "ElectionContract:CheckElectionProposal", proposalName), committee))
+
+	return committee
+}
+
+func TestCheckElectionOnce(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice", "bob", "carol")
+
+	if committee := elect(t, s, "e1", "alice", "alice", "bob", "carol"); committee.Users[0] != "alice" {
+		t.Fatalf("e1 committee = %v", committee.Users)
+	}
+
+	if committee := elect(t, s, "e2", "bob", "alice", "bob", "carol"); committee.Users[0] != "bob" {
+		t.Fatalf("e2 committee = %v", committee.Users)
+	}
+
+	// 旧选举不能再次结算覆盖新委员会
+	if _, err := s.invoke("admin", "ElectionContract:CheckElectionProposal", "e1"); err == nil {
+		t.Fatal("old election checked again")
+	}
+
+	committee := new(Committee)
+	noError(t, json.Unmarshal(call(t, s, "alice", "ElectionContract:QueryCommittee"), committee))
+
+	if committee.Users[0] != "bob" {
+		t.Fatalf("committee = %v", committee.Users)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+// TransactionContext 合约交易上下文，收集交易中产生的业务事件
+// Fabric每个交易只保留最后一次SetEvent，因此事件在交易结束后由FlushEvents统一发出
+type TransactionContext struct {
+	contractapi.TransactionContext
+	events []events.Event
+}
+
+// eventRecorder 可以收集业务事件的交易上下文
+type eventRecorder interface {
+	recordEvent(event events.Event)
+	recordedEvents() []events.Event
+}
+
+func (c *TransactionContext) recordEvent(event events.Event) {
+	c.events = append(c.events, event)
+}
+
+func (c *TransactionContext) recordedEvents() []events.Event {
+	return c.events
+}
+
+// FlushEvents 交易成功后发出收集的业务事件，作为各合约的AfterTransaction
+func FlushEvents(ctx contractapi.TransactionContextInterface) error {
+	recorder, ok := ctx.(eventRecorder)
+
+	if !ok || len(recorder.recordedEvents()) == 0 {
+		return nil
+	}
+
+	return setEvent(ctx, &events.Payload{Events: recorder.recordedEvents()})
+}
+
+// emitEvent 记录业务事件，交易上下文不能收集事件时直接发出
+func emitEvent(
+	ctx contractapi.TransactionContextInterface,
+	name string,
+	entityType string,
+	entityId string,
+	oldState string,
+	newState string,
+	actors []string,
+	detail map[string]string) error {
+	// 1.事件结构体赋值
+	var t TimeContract
+	eventTime, err := t.Now(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if actors == nil {
+		actors = []string{}
+	}
+
+	event := events.Event{
+		Name: name,
+		EntityType: entityType,
+		EntityId: entityId,
+		OldState: oldState,
+		NewState: newState,
+		Actors: actors,
+		Detail: detail,
+		TxId: ctx.GetStub().GetTxID(),
+		EventTime: eventTime,
+	}
+
+	// 2.记录事件
+	if recorder, ok := ctx.(eventRecorder); ok {
+		recorder.recordEvent(event)
+		return nil
+	}
+
+	return setEvent(ctx, &events.Payload{Events: []events.Event{event}})
+}
+
+// setEvent 发出链码事件
+func setEvent(
+	ctx contractapi.TransactionContextInterface,
+	payload *events.Payload) error {
+	payloadAsBytes, _ := json.Marshal(payload)
+
+	if err := ctx.GetStub().SetEvent(payload.EventName(), payloadAsBytes); err != nil {
+		return fmt.Errorf("Failed to set event %s. %s ", payload.EventName(), err.Error())
+	}
+
+	return nil
+}
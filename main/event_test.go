@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+
+	"myChaincode/events"
+)
+
+// parseEvent 解析测试账本记录的链码事件
+func parseEvent(t *testing.T, s *testStub, name string) *events.Payload {
+	t.Helper()
+
+	payloadAsBytes, ok := s.events[name]
+
+	if !ok {
+		t.Fatalf("event %s not emitted", name)
+	}
+
+	payload, err := events.Parse(payloadAsBytes)
+	noError(t, err)
+
+	return payload
+}
+
+func TestSingleEventName(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+
+	payload := parseEvent(t, s, events.UserRegistered)
+
+	if len(payload.Events) != 1 {
+		t.Fatalf("payload = %+v", payload)
+	}
+
+	event := payload.Events[0]
+
+	if event.EntityType != events.UserEntity || event.EntityId != "alice" || event.NewState != PowerUser || event.TxId == "" {
+		t.Fatalf("event = %+v", event)
+	}
+}
+
+func TestBatchEvents(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	commitCompact(t, s, "c1", "alice", 100)
+
+	// 一个交易产生多个业务事件时，交易结束后统一以Batch发出
+	s.events = make(map[string][]byte)
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.5")
+
+	if len(s.events) != 1 {
+		t.Fatalf("events = %v", s.events)
+	}
+
+	payload := parseEvent(t, s, events.BatchEventName)
+
+	names := make(map[string]bool)
+	for _, event := range payload.Events {
+		names[event.Name] = true
+	}
+
+	if !names[events.CompactStateChanged] || !names[events.BidStateChanged] {
+		t.Fatalf("payload = %+v", payload)
+	}
+
+	// 失败的交易不发出事件
+	s.events = make(map[string][]byte)
+
+	if _, err := s.invoke("plant", "PowerTXContract:Bid", "c1", "plant", "0.4"); err == nil {
+		t.Fatal("second active bid accepted")
+	}
+
+	if len(s.events) != 0 {
+		t.Fatalf("events = %v", s.events)
+	}
+}
+
+func TestEmitEventWithoutRecorder(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+	s.events = make(map[string][]byte)
+
+	// 不能收集事件的交易上下文直接发出事件
+	var r RoleContract
+	noError(t, r.changeCredit(s.ctx("alice"), "alice", -5))
+
+	payload := parseEvent(t, s, events.UserCreditChanged)
+
+	if len(payload.Events) != 1 || payload.Events[0].OldState != "100/0" || payload.Events[0].NewState != "95/0" {
+		t.Fatalf("payload = %+v", payload)
+	}
+}
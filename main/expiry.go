@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+	"sort"
+)
+
+type ExpiryContract struct {
+	contractapi.Contract
+}
+
+// ExpirySweep 一次过期扫描的结果，Bookmark不为空时从Bookmark继续扫描
+type ExpirySweep struct {
+	Scanned 		int			`json:"scanned"`
+	Expired 		[]string	`json:"expired"`
+	Bookmark 		string		`json:"bookmark"`
+}
+
+// ExpirySource 过期扫描的一个来源，ObjectType与Attributes为扫描的组合键前缀，RecordType为记录的命名空间
+// compact只扫描未成交状态的索引，已结算或已过期的compact不再参与扫描
+type ExpirySource struct {
+	ObjectType 		string
+	Attributes 		[]string
+	RecordType 		string
+}
+
+// expirySources 过期扫描的来源，按顺序扫描
+var expirySources = []ExpirySource{
+	{ObjectType: CompactByStateIndex, Attributes: []string{CompactCommitting}, RecordType: CompactObjectType},
+	{ObjectType: CompactByStateIndex, Attributes: []string{CompactBiding}, RecordType: CompactObjectType},
+	{ObjectType: CompactByStateIndex, Attributes: []string{CompactAccepted}, RecordType: CompactObjectType},
+	{ObjectType: ElectionProposalObjectType, Attributes: []string{}, RecordType: ElectionProposalObjectType},
+	{ObjectType: BallotProposalObjectType, Attributes: []string{}, RecordType: BallotProposalObjectType},
+}
+
+// ExpireDue 扫描超过结束时间且超过宽限期仍未处理的compact与提案，并置为过期
+// 每次最多扫描pageSize条记录，返回的Bookmark不为空时以其为参数继续调用，供链下定时任务周期性调用
+// 扫描时会写入世界状态，不能使用分页查询接口，组合键也不能按范围查询，
+// 因此只在Bookmark所在的来源中按组合键顺序跳过Bookmark之前的记录
+func (e *ExpiryContract) ExpireDue(
+	ctx contractapi.TransactionContextInterface,
+	bookmark string,
+	pageSize int) (*ExpirySweep, error) {
+	// 1.判断参数
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive ! ")
+	}
+
+	start := 0
+	if bookmark != "" {
+		objectType, attributes, err := ctx.GetStub().SplitCompositeKey(bookmark)
+
+		if err != nil {
+			return nil, fmt.Errorf("Bookmark is not right. %s ", err.Error())
+		}
+
+		start = -1
+		for i, source := range expirySources {
+			if source.ObjectType == objectType && source.matches(attributes) {
+				start = i
+			}
+		}
+
+		if start == -1 {
+			return nil, fmt.Errorf("Bookmark is not right ! ")
+		}
+	}
+
+	// 2.读取宽限期
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.按命名空间逐条扫描
+	sweep := &ExpirySweep{
+		Expired: []string{},
+	}
+	refunds := make(map[string][]AccountEntry)
+	penalties := make(map[string]int)
+	for i := start; i < len(expirySources) && sweep.Bookmark == ""; i++ {
+		source := expirySources[i]
+		iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(source.ObjectType, source.Attributes)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to query %s Info from world state. %s ", source.ObjectType, err.Error())
+		}
+
+		for iterator.HasNext() {
+			kv, err := iterator.Next()
+
+			if err != nil {
+				iterator.Close()
+				return nil, err
+			}
+
+			// 3.1跳过Bookmark所在来源中已扫描的记录
+			if i == start && kv.Key < bookmark {
+				continue
+			}
+
+			// 3.2本次已扫描pageSize条，记录下一条的位置
+			if sweep.Scanned == pageSize {
+				sweep.Bookmark = kv.Key
+				break
+			}
+			sweep.Scanned++
+
+			// 3.3索引指向的compact
+			value := kv.Value
+			if source.ObjectType != source.RecordType {
+				_, attributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+
+				if err != nil || len(attributes) == 0 {
+					continue
+				}
+
+				value, err = getState(ctx, source.RecordType, attributes[len(attributes) - 1])
+
+				if err != nil || value == nil {
+					iterator.Close()
+					return nil, fmt.Errorf("Failed to query %s Info from world state ! ", source.RecordType)
+				}
+			}
+
+			// 3.4置为过期
+			name, err := e.expireRecord(ctx, source.RecordType, value, variables, refunds, penalties)
+
+			if err != nil {
+				iterator.Close()
+				return nil, err
+			}
+
+			if name != "" {
+				sweep.Expired = append(sweep.Expired, source.RecordType+":"+name)
+			}
+		}
+
+		iterator.Close()
+	}
+
+	// 4.退回过期compact的托管款
+	var a AccountContract
+	err = a.updateAccounts(ctx, refunds)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 5.扣除powerUser信用值
+	userNames := make([]string, 0, len(penalties))
+	for userName := range penalties {
+		userNames = append(userNames, userName)
+	}
+	sort.Strings(userNames)
+
+	var r RoleContract
+	for _, userName := range userNames {
+		err = r.changeCredit(ctx, userName, -penalties[userName])
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sweep, nil
+}
+
+// expireRecord 判断记录是否过期，过期时更改状态并返回记录名称
+// 同一交易中读不到本交易的写入，托管款退回与信用值扣除记入refunds与penalties，扫描结束后统一处理
+func (e *ExpiryContract) expireRecord(
+	ctx contractapi.TransactionContextInterface,
+	objectType string,
+	value []byte,
+	variables map[string]int,
+	refunds map[string][]AccountEntry,
+	penalties map[string]int) (string, error) {
+	var t TimeContract
+	graceHours := variables[ExpiryGraceHours]
+	switch objectType {
+	case CompactObjectType:
+		compact := new(Compact)
+		_ = json.Unmarshal(value, compact)
+
+		// 1.只有未成交的compact会过期，已成交的compact等待admin结算
+		var p PowerTXContract
+		if p.checkState(compact, "ExpireDue") != nil {
+			return "", nil
+		}
+
+		expired, err := t.CompareWithNow(ctx, t.addHours(compact.EndTime, graceHours))
+
+		if err != nil || !expired {
+			return "", err
+		}
+
+		// 1.1竞价中的报价置为过期，powerUser未处理报价时扣除信用值
+		bids, err := p.queryActiveBids(ctx, compact.CompactId)
+
+		if err != nil {
+			return "", err
+		}
+
+		for _, bid := range bids {
+			bid.State = "Expired"
+
+			if err := p.putBid(ctx, bid); err != nil {
+				return "", err
+			}
+		}
+
+		if compact.State == CompactBiding {
+			penalties[compact.PowerUserName] += variables[ExpiryPenalty]
+		}
+
+		// 1.2托管款退回powerUser
+		if compact.Escrowed > 0 {
+			refunds[compact.PowerUserName] = append(refunds[compact.PowerUserName], AccountEntry{
+				EntryType: Refund,
+				Amount: compact.Escrowed,
+				EscrowChange: -compact.Escrowed,
+				CompactId: compact.CompactId,
+			})
+			compact.Escrowed = 0
+		}
+
+		// 1.3compact置为过期
+		if err := p.transition(ctx, compact, "ExpireDue", CompactExpired); err != nil {
+			return "", err
+		}
+
+		return compact.CompactId, p.putCompact(ctx, compact)
+
+	case ElectionProposalObjectType:
+		electionProposal := new(ElectionProposal)
+		_ = json.Unmarshal(value, electionProposal)
+
+		// 2.投票结束后未结算的选举提案置为过期，不产生新的委员会
+		if electionProposal.State != "Voting" {
+			return "", nil
+		}
+
+		expired, err := t.CompareWithNow(ctx, t.addHours(electionProposal.EndTime, graceHours))
+
+		if err != nil || !expired {
+			return "", err
+		}
+
+		electionProposal.State = "Expired"
+		electionProposalAsBytes, _ := json.Marshal(electionProposal)
+		err = putState(ctx, electionProposalAsBytes, ElectionProposalObjectType, electionProposal.ElectionProposalName)
+
+		if err != nil {
+			return "", err
+		}
+
+		return electionProposal.ElectionProposalName, emitEvent(ctx, events.ElectionProposalStateChanged, events.ElectionProposalEntity,
+			electionProposal.ElectionProposalName, "Voting", electionProposal.State, []string{electionProposal.ProposerName}, nil)
+
+	case BallotProposalObjectType:
+		ballotProposal := new(BallotProposal)
+		_ = json.Unmarshal(value, ballotProposal)
+
+		// 3.投票结束后未结算的投票提案置为过期，提案不通过
+		if ballotProposal.State != "Voting" {
+			return "", nil
+		}
+
+		expired, err := t.CompareWithNow(ctx, t.addHours(ballotProposal.EndTime, graceHours))
+
+		if err != nil || !expired {
+			return "", err
+		}
+
+		ballotProposal.State = "Expired"
+		ballotProposal.Result = false
+		ballotProposalAsBytes, _ := json.Marshal(ballotProposal)
+		err = putState(ctx, ballotProposalAsBytes, BallotProposalObjectType, ballotProposal.BallotProposalName)
+
+		if err != nil {
+			return "", err
+		}
+
+		return ballotProposal.BallotProposalName, emitEvent(ctx, events.BallotProposalStateChanged, events.BallotProposalEntity,
+			ballotProposal.BallotProposalName, "Voting", ballotProposal.State, []string{ballotProposal.ProposerName}, nil)
+	}
+
+	return "", nil
+}
+
+// matches 判断组合键属性是否属于该来源
+func (s ExpirySource) matches(attributes []string) bool {
+	if len(attributes) < len(s.Attributes) {
+		return false
+	}
+
+	for i, attribute := range s.Attributes {
+		if attributes[i] != attribute {
+			return false
+		}
+	}
+
+	return true
+}
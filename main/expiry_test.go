@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// expireAll admin从头扫描到结束，返回全部过期的记录
+func expireAll(t *testing.T, s *testStub, pageSize int) []string {
+	t.Helper()
+
+	expired := []string{}
+	bookmark := ""
+	for {
+		var sweep ExpirySweep
+		noError(t, json.Unmarshal(call(t, s, "admin", "ExpiryContract:ExpireDue", bookmark, fmt.Sprint(pageSize)), &sweep))
+
+		if sweep.Scanned > pageSize {
+			t.Fatalf("scanned %d records with page size %d", sweep.Scanned, pageSize)
+		}
+
+		expired = append(expired, sweep.Expired...)
+		bookmark = sweep.Bookmark
+
+		if bookmark == "" {
+			return expired
+		}
+	}
+}
+
+func TestExpireDueCompacts(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+
+	for _, compactId := range []string{"c1", "c2", "c3"} {
+		commitCompact(t, s, compactId, "alice", 100)
+	}
+
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.5")
+	call(t, s, "plant", "PowerTXContract:PartialBid", "c3", "plant", "40", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c3")
+	dealCompact(t, s, "c4", "alice", "plant", 100)
+
+	if _, err := s.invoke("alice", "ExpiryContract:ExpireDue", "", "10"); err == nil {
+		t.Fatal("power user swept expired records")
+	}
+
+	// 宽限期内不过期
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+
+	if expired := expireAll(t, s, 10); len(expired) != 0 {
+		t.Fatalf("expired within the grace period = %v", expired)
+	}
+
+	s.now = time.Date(2026, 2, 3, 0, 0, 0, 0, TimeLocation)
+
+	if expired := expireAll(t, s, 2); len(expired) != 3 {
+		t.Fatalf("expired = %v", expired)
+	}
+
+	for _, compactId := range []string{"c1", "c2", "c3"} {
+		if compact := queryCompact(t, s, compactId); compact.State != CompactExpired || compact.Escrowed != 0 {
+			t.Errorf("%s = %+v", compactId, compact)
+		}
+	}
+
+	// 已成交的compact等待结算，不会过期
+	if compact := queryCompact(t, s, "c4"); compact.State != CompactDeal {
+		t.Fatalf("c4 state = %s", compact.State)
+	}
+
+	// 部分成交的托管款退回，未处理报价扣除信用值
+	if account := queryAccount(t, s, "alice"); account.Balance != 1000000-50000 || account.Escrowed != 50000 {
+		t.Fatalf("alice account = %+v", account)
+	}
+
+	if user := queryUser(t, s, "alice"); user.UserCredit != 95 {
+		t.Fatalf("alice credit = %d, want 95", user.UserCredit)
+	}
+
+	if bids := bidStates(t, s, "c1"); bids["plant"] != "Expired" {
+		t.Fatalf("c1 bids = %v", bids)
+	}
+
+	if expired := expireAll(t, s, 10); len(expired) != 0 {
+		t.Fatalf("expired twice = %v", expired)
+	}
+}
+
+func TestExpireDueProposals(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	call(t, s, "alice", "BallotContract:CreateBallotProposal", "b1", "alice", "Public", "2026-01-01 08:00:00", "2026-01-01 12:00:00")
+	call(t, s, "alice", "ElectionContract:CreateElectionProposal", "e1", "alice", "2026-01-01 08:00:00", "2026-01-01 12:00:00")
+
+	s.now = time.Date(2026, 1, 2, 13, 0, 0, 0, TimeLocation)
+	expired := expireAll(t, s, 1)
+
+	if len(expired) != 2 || expired[0] != ElectionProposalObjectType+":e1" || expired[1] != BallotProposalObjectType+":b1" {
+		t.Fatalf("expired = %v", expired)
+	}
+
+	// 过期的提案不能再结算
+	if _, err := s.invoke("admin", "ElectionContract:CheckElectionProposal", "e1"); err == nil {
+		t.Fatal("expired election checked")
+	}
+
+	if _, err := s.invoke("alice", "BallotContract:CheckBallotProposal", "b1"); err == nil {
+		t.Fatal("expired ballot checked")
+	}
+}
+
+func TestExpireDueAcrossSources(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+
+	commitCompact(t, s, "c1", "alice", 100)
+	commitCompact(t, s, "c2", "alice", 100)
+	dealCompact(t, s, "c3", "alice", "plant", 100)
+	call(t, s, "alice", "BallotContract:CreateBallotProposal", "b1", "alice", "Public", "2026-01-01 08:00:00", "2026-01-01 12:00:00")
+
+	// Bookmark停在compact索引中时，按键排序在其之前的提案仍然需要扫描
+	s.now = time.Date(2026, 2, 3, 0, 0, 0, 0, TimeLocation)
+	expired := expireAll(t, s, 1)
+
+	if len(expired) != 3 || expired[2] != BallotProposalObjectType+":b1" {
+		t.Fatalf("expired = %v", expired)
+	}
+
+	// 已成交的compact不在扫描范围内
+	var sweep ExpirySweep
+	noError(t, json.Unmarshal(call(t, s, "admin", "ExpiryContract:ExpireDue", "", "10"), &sweep))
+
+	if sweep.Scanned != 1 || len(sweep.Expired) != 0 {
+		t.Fatalf("sweep = %+v", sweep)
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+	"time"
+)
+
+type FrameworkContract struct {
+	contractapi.Contract
+}
+
+// Framework 长期双边框架合同，按交割时段生成子compact，每个子compact单独交割结算
+// 第i个时段的电量与电价为Schedule[i % len(Schedule)]，如按小时交割的24个时段为一天的负荷曲线
+type Framework struct {
+	FrameworkId 	string			`json:"framework_id"`
+	State 			string			`json:"state"`
+	PowerUserName   string			`json:"power_user_name"`
+	PowerPlantName  string 			`json:"power_plant_name"`
+	AdminName	    string			`json:"admin_name"`
+	Interval 		string			`json:"interval"`
+	StartTime 		string			`json:"start_time"`
+	EndTime 		string			`json:"end_time"`
+	Schedule 		[]FrameworkSlot	`json:"schedule"`
+	SlotCount 		int				`json:"slot_count"`
+	NextSlot 		int				`json:"next_slot"`
+}
+
+// FrameworkSlot 框架合同一个交割时段的电量与电价
+type FrameworkSlot struct {
+	Quantity 		int			`json:"quantity"`
+	Price 			Price		`json:"price"`
+}
+
+// FrameworkFulfilment 框架合同的累计履约情况，电量单位为kWh
+type FrameworkFulfilment struct {
+	FrameworkId 		string		`json:"framework_id"`
+	State 				string		`json:"state"`
+	SlotCount 			int			`json:"slot_count"`
+	GeneratedSlots 		int			`json:"generated_slots"`
+	SettledSlots 		int			`json:"settled_slots"`
+	ContractedQuantity 	int			`json:"contracted_quantity"`
+	GeneratedQuantity 	int			`json:"generated_quantity"`
+	SettledQuantity 	int			`json:"settled_quantity"`
+	DeliveredQuantity 	int			`json:"delivered_quantity"`
+	FulfilmentRate 		int			`json:"fulfilment_rate"`
+}
+
+// Hourly 按小时交割 Daily 按天交割
+const Hourly string = "Hourly"
+const Daily string = "Daily"
+
+// frameworkIntervals 交割时段的小时数
+var frameworkIntervals = map[string]int{
+	Hourly: 1,
+	Daily:  24,
+}
+
+// ProposeFramework powerUser提出框架合同，quantities与prices为各时段的电量与电价，循环使用
+func (f *FrameworkContract) ProposeFramework(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string,
+	powerUserName string,
+	powerPlantName string,
+	interval string,
+	startTime string,
+	endTime string,
+	quantities []int,
+	prices []string) (*Framework, error) {
+	// 1.判断框架合同是否存在
+	if _, err := f.QueryFramework(ctx, frameworkId); err == nil {
+		return nil, fmt.Errorf("Framework %s existed ! ", frameworkId)
+	}
+
+	// 2.判断交割时段
+	hours, ok := frameworkIntervals[interval]
+
+	if !ok {
+		return nil, fmt.Errorf("Interval %s is not right ! ", interval)
+	}
+
+	var t TimeContract
+	if !t.CompareTime(startTime, endTime) {
+		return nil, fmt.Errorf("End time earlier than Start time ! ")
+	}
+
+	startObj, _ := time.ParseInLocation(TimeLayout, startTime, TimeLocation)
+	endObj, _ := time.ParseInLocation(TimeLayout, endTime, TimeLocation)
+	duration := endObj.Sub(startObj)
+
+	if duration % (time.Duration(hours) * time.Hour) != 0 {
+		return nil, fmt.Errorf("Framework period must be whole %s slots ! ", interval)
+	}
+
+	// 3.判断交割曲线
+	if len(quantities) == 0 || len(quantities) != len(prices) {
+		return nil, fmt.Errorf("Quantities and prices must have the same positive length ! ")
+	}
+
+	schedule := make([]FrameworkSlot, len(quantities))
+	for i := range quantities {
+		price, err := ParsePrice(prices[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if quantities[i] < 0 {
+			return nil, fmt.Errorf("Quantity can not be negative ! ")
+		}
+
+		schedule[i] = FrameworkSlot{
+			Quantity: quantities[i],
+			Price: price,
+		}
+	}
+
+	// 4.查看powerUser是否为调用者本人，信用值是否足够，powerPlant是否存在
+	var r RoleContract
+	powerUser, err := r.checkCaller(ctx, powerUserName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerUser.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("PowerUser credit less than %d ", variables[CreditBorder])
+	}
+
+	powerPlant, err := r.QueryUser(ctx, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerPlant.UserRole != PowerPlant {
+		return nil, fmt.Errorf("%s is not a powerPlant ! ", powerPlantName)
+	}
+
+	// 5.结构体赋值
+	framework := Framework{
+		FrameworkId: frameworkId,
+		State: "Proposed",
+		PowerUserName: powerUserName,
+		PowerPlantName: powerPlantName,
+		Interval: interval,
+		StartTime: startTime,
+		EndTime: endTime,
+		Schedule: schedule,
+		SlotCount: int(duration / (time.Duration(hours) * time.Hour)),
+		NextSlot: 0,
+	}
+
+	// 6.上链
+	err = f.putFramework(ctx, &framework, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &framework, nil
+}
+
+// SignFramework powerPlant签署框架合同
+func (f *FrameworkContract) SignFramework(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string) (*Framework, error) {
+	// 1.获取框架合同
+	framework, err := f.QueryFramework(ctx, frameworkId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为框架合同的powerPlant，信用值是否足够
+	var r RoleContract
+	powerPlant, err := r.checkCaller(ctx, framework.PowerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerPlant.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("PowerPlant credit less than %d ", variables[CreditBorder])
+	}
+
+	// 3.判断框架合同状态
+	if framework.State != "Proposed" {
+		return nil, fmt.Errorf("Framework state is not proposed ! ")
+	}
+
+	// 4.上链
+	oldState := framework.State
+	framework.State = "Signed"
+
+	err = f.putFramework(ctx, framework, oldState)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return framework, nil
+}
+
+// DealFramework admin参与框架合同，之后由admin生成各时段的子compact
+func (f *FrameworkContract) DealFramework(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string,
+	adminName string) (*Framework, error) {
+	// 1.获取框架合同
+	framework, err := f.QueryFramework(ctx, frameworkId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断admin是否为调用者本人，信用值是否足够
+	var r RoleContract
+	admin, err := r.checkCaller(ctx, adminName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if admin.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("Admin credit less than %d ", variables[CreditBorder])
+	}
+
+	// 3.判断框架合同状态
+	if framework.State != "Signed" {
+		return nil, fmt.Errorf("Framework state is not signed ! ")
+	}
+
+	// 4.上链
+	oldState := framework.State
+	framework.State = "Active"
+	framework.AdminName = adminName
+
+	err = f.putFramework(ctx, framework, oldState)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return framework, nil
+}
+
+// CancelFramework powerUser在admin参与前取消框架合同
+func (f *FrameworkContract) CancelFramework(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string) (*Framework, error) {
+	// 1.获取框架合同
+	framework, err := f.QueryFramework(ctx, frameworkId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为框架合同的powerUser
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, framework.PowerUserName); err != nil {
+		return nil, err
+	}
+
+	// 3.判断框架合同状态
+	if framework.State != "Proposed" && framework.State != "Signed" {
+		return nil, fmt.Errorf("Framework state is %s, can not cancel ! ", framework.State)
+	}
+
+	// 4.上链
+	oldState := framework.State
+	framework.State = "Canceled"
+
+	err = f.putFramework(ctx, framework, oldState)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return framework, nil
+}
+
+// GenerateDeliveries admin按交割曲线生成之后count个时段的子compact，子compact直接进入Deal状态并托管powerUser的付款
+// 电量为0的时段不生成子compact，全部时段生成后框架合同进入Scheduled状态
+func (f *FrameworkContract) GenerateDeliveries(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string,
+	count int) (*Framework, error) {
+	// 1.获取框架合同
+	framework, err := f.QueryFramework(ctx, frameworkId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为框架合同的admin
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, framework.AdminName); err != nil {
+		return nil, err
+	}
+
+	// 3.判断参数与框架合同状态
+	if count <= 0 {
+		return nil, fmt.Errorf("Count must be positive ! ")
+	}
+
+	if framework.State != "Active" {
+		return nil, fmt.Errorf("Framework state is not active ! ")
+	}
+
+	// 4.逐个时段生成子compact
+	var p PowerTXContract
+	var g GridContract
+	var t TimeContract
+	hours := frameworkIntervals[framework.Interval]
+	route, err := g.transferRoute(ctx, framework.PowerPlantName, framework.PowerUserName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reservations := []*TransferReservation{}
+	escrow := int64(0)
+	for ; count > 0 && framework.NextSlot < framework.SlotCount; count-- {
+		slotIndex := framework.NextSlot
+		slot := framework.slot(slotIndex)
+		framework.NextSlot++
+
+		if slot.Quantity == 0 {
+			continue
+		}
+
+		amount, err := slot.Price.Amount(slot.Quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		compact := Compact{
+			CompactId: f.deliveryId(frameworkId, slotIndex),
+			PowerPlantName: framework.PowerPlantName,
+			PowerUserName: framework.PowerUserName,
+			AdminName: framework.AdminName,
+			Transaction: slot.Quantity,
+			Price: slot.Price,
+			StartTime: t.addHours(framework.StartTime, slotIndex * hours),
+			EndTime: t.addHours(framework.StartTime, (slotIndex + 1) * hours),
+			Legs: []CompactLeg{{
+				PowerPlantName: framework.PowerPlantName,
+				Quantity: slot.Quantity,
+				Price: slot.Price,
+			}},
+			Escrowed: amount,
+			FrameworkId: frameworkId,
+		}
+
+		if p.CompactExist(ctx, compact.CompactId) {
+			return nil, fmt.Errorf("Compact %s existed ! ", compact.CompactId)
+		}
+
+		// 4.1跨区交割不能超过剩余输电限额
+		if route != nil {
+			capacity, err := g.transferCapacity(ctx, route, compact.StartTime, compact.EndTime, reservations)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if slot.Quantity > capacity {
+				return nil, fmt.Errorf("Transfer from %s to %s exceeds remaining capacity %d ! ", route.FromZone, route.ToZone, capacity)
+			}
+
+			reservation, err := g.reserveTransfer(ctx, route, compact.CompactId, framework.PowerPlantName, slot.Quantity, compact.StartTime, compact.EndTime)
+
+			if err != nil {
+				return nil, err
+			}
+
+			reservations = append(reservations, reservation)
+		}
+
+		// 4.2子compact上链，大额子compact需要委员会批准后执行Deal
+		if err := p.putGeneratedCompact(ctx, &compact, "GenerateDeliveries", variables); err != nil {
+			return nil, err
+		}
+
+		if escrow, err = addAmount(escrow, compact.Escrowed); err != nil {
+			return nil, err
+		}
+	}
+
+	// 5.托管powerUser为本次生成的子compact支付的金额
+	if escrow > 0 {
+		var a AccountContract
+		_, err = a.updateAccount(ctx, framework.PowerUserName, []AccountEntry{{
+			EntryType: Escrow,
+			Amount: -escrow,
+			EscrowChange: escrow,
+		}})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 6.框架合同上链
+	oldState := framework.State
+	if framework.NextSlot == framework.SlotCount {
+		framework.State = "Scheduled"
+	}
+
+	err = f.putFramework(ctx, framework, oldState)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return framework, nil
+}
+
+// QueryFramework 获取框架合同
+func (f *FrameworkContract) QueryFramework(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string) (*Framework, error) {
+	// 1.获取框架合同信息
+	frameworkAsBytes, err := getState(ctx, FrameworkObjectType, frameworkId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Framework Info from world state. %s ", err.Error())
+	}
+
+	if frameworkAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", frameworkId)
+	}
+
+	// 2.赋值
+	framework := new(Framework)
+	_ = json.Unmarshal(frameworkAsBytes, framework)
+
+	return framework, nil
+}
+
+// QueryFrameworkDeliveries 分页查询框架合同已生成的子compact，按时段排序
+func (f *FrameworkContract) QueryFrameworkDeliveries(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	var p PowerTXContract
+	return p.queryCompactIndex(ctx, CompactByFrameworkIndex, frameworkId, pageSize, bookmark)
+}
+
+// QueryFrameworkFulfilment 汇总框架合同各子compact的交割结算情况
+// FulfilmentRate为已结算时段的实际供电量占合同电量的比例，单位为千分之一
+func (f *FrameworkContract) QueryFrameworkFulfilment(
+	ctx contractapi.TransactionContextInterface,
+	frameworkId string) (*FrameworkFulfilment, error) {
+	// 1.获取框架合同
+	framework, err := f.QueryFramework(ctx, frameworkId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fulfilment := &FrameworkFulfilment{
+		FrameworkId: frameworkId,
+		State: framework.State,
+		SlotCount: framework.SlotCount,
+	}
+
+	for i := 0; i < framework.SlotCount; i++ {
+		fulfilment.ContractedQuantity += framework.slot(i).Quantity
+	}
+
+	// 2.按索引获取已生成的子compact
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(CompactByFrameworkIndex, []string{frameworkId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Compact index from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 3.汇总
+	var p PowerTXContract
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+
+		if err != nil || len(attributes) != 2 {
+			continue
+		}
+
+		compact, err := p.QueryCompact(ctx, attributes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		fulfilment.GeneratedSlots++
+		fulfilment.GeneratedQuantity += compact.Transaction
+
+		if compact.State != CompactDone {
+			continue
+		}
+
+		fulfilment.SettledSlots++
+		fulfilment.SettledQuantity += compact.Transaction
+		for _, leg := range compact.compactLegs() {
+			fulfilment.DeliveredQuantity += leg.Delivered
+		}
+	}
+
+	if fulfilment.SettledQuantity > 0 {
+		fulfilment.FulfilmentRate = int(int64(fulfilment.DeliveredQuantity) * 1000 / int64(fulfilment.SettledQuantity))
+	}
+
+	return fulfilment, nil
+}
+
+// putFramework 框架合同上链，状态变化时发出事件
+func (f *FrameworkContract) putFramework(
+	ctx contractapi.TransactionContextInterface,
+	framework *Framework,
+	oldState string) error {
+	// 1.上链
+	frameworkAsBytes, _ := json.Marshal(framework)
+	err := putState(ctx, frameworkAsBytes, FrameworkObjectType, framework.FrameworkId)
+
+	if err != nil {
+		return err
+	}
+
+	// 2.发出事件
+	if oldState == framework.State {
+		return nil
+	}
+
+	actors := []string{framework.PowerUserName, framework.PowerPlantName}
+	if framework.AdminName != "" {
+		actors = append(actors, framework.AdminName)
+	}
+
+	return emitEvent(ctx, events.FrameworkStateChanged, events.FrameworkEntity, framework.FrameworkId,
+		oldState, framework.State, actors, nil)
+}
+
+// deliveryId 框架合同第slotIndex个时段的子compact编号，编号按时段排序，不会与powerUser提交的compact冲突
+func (f *FrameworkContract) deliveryId(frameworkId string, slotIndex int) string {
+	return generatedCompactId("framework", frameworkId, fmt.Sprintf("%06d", slotIndex + 1))
+}
+
+// slot 获取第i个时段的电量与电价
+func (fw *Framework) slot(i int) FrameworkSlot {
+	return fw.Schedule[i % len(fw.Schedule)]
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// generateDeliveries admin为框架合同生成之后count个时段的子compact
+func generateDeliveries(t *testing.T, s *testStub, frameworkId string, count string) *Framework {
+	t.Helper()
+
+	framework := new(Framework)
+	noError(t, json.Unmarshal(call(t, s, "admin", "FrameworkContract:GenerateDeliveries", frameworkId, count), framework))
+
+	return framework
+}
+
+func TestFrameworkDeliveries(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+
+	if _, err := s.invoke("alice", "FrameworkContract:ProposeFramework", "F1", "alice", "p1", Hourly,
+		"2026-01-02 00:00:00", "2026-01-02 05:30:00", "[10]", `["0.5"]`); err == nil {
+		t.Fatal("framework with a partial slot")
+	}
+
+	if _, err := s.invoke("p1", "FrameworkContract:ProposeFramework", "F1", "alice", "p1", Hourly,
+		"2026-01-02 00:00:00", "2026-01-02 06:00:00", "[10]", `["0.5"]`); err == nil {
+		t.Fatal("power plant proposed a framework")
+	}
+
+	// 6个按小时交割的时段，负荷曲线为10、0、20循环
+	call(t, s, "alice", "FrameworkContract:ProposeFramework", "F1", "alice", "p1", Hourly,
+		"2026-01-02 00:00:00", "2026-01-02 06:00:00", "[10,0,20]", `["0.5","0.5","0.6"]`)
+
+	if _, err := s.invoke("admin", "FrameworkContract:DealFramework", "F1", "admin"); err == nil {
+		t.Fatal("deal before the power plant signed")
+	}
+
+	call(t, s, "p1", "FrameworkContract:SignFramework", "F1")
+	call(t, s, "admin", "FrameworkContract:DealFramework", "F1", "admin")
+
+	if _, err := s.invoke("alice", "FrameworkContract:CancelFramework", "F1"); err == nil {
+		t.Fatal("active framework canceled")
+	}
+
+	// 分批生成子compact，电量为0的时段不生成
+	if framework := generateDeliveries(t, s, "F1", "4"); framework.NextSlot != 4 || framework.State != "Active" {
+		t.Fatalf("first batch = %+v", framework)
+	}
+
+	if framework := generateDeliveries(t, s, "F1", "10"); framework.NextSlot != 6 || framework.State != "Scheduled" {
+		t.Fatalf("second batch = %+v", framework)
+	}
+
+	var f FrameworkContract
+	page, err := f.QueryFrameworkDeliveries(s.ctx("alice"), "F1", 10, "")
+	noError(t, err)
+
+	if len(page.Compacts) != 4 {
+		t.Fatalf("deliveries = %s", compactIds(page))
+	}
+
+	compact := page.Compacts[0]
+
+	if compact.CompactId != generatedCompactId("framework", "F1", "000001") || compact.State != CompactDeal || compact.Transaction != 10 || compact.StartTime != "2026-01-02 00:00:00" || compact.EndTime != "2026-01-02 01:00:00" {
+		t.Fatalf("first delivery = %+v", compact)
+	}
+
+	// 生成的子compact按合同电价托管powerUser的付款
+	if account := queryAccount(t, s, "alice"); account.Escrowed != 2*10*500+2*20*600 {
+		t.Fatalf("alice account = %+v", account)
+	}
+
+	s.now = time.Date(2026, 1, 3, 0, 0, 0, 0, TimeLocation)
+	meterReading(t, s, "alice", compact.CompactId, 10)
+	meterReading(t, s, "p1", compact.CompactId, 8)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", compact.CompactId)
+
+	fulfilment, err := f.QueryFrameworkFulfilment(s.ctx("alice"), "F1")
+	noError(t, err)
+
+	if fulfilment.ContractedQuantity != 60 || fulfilment.GeneratedSlots != 4 || fulfilment.SettledSlots != 1 || fulfilment.FulfilmentRate != 800 {
+		t.Fatalf("fulfilment = %+v", fulfilment)
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"time"
+)
+
+type GridContract struct {
+	contractapi.Contract
+}
+
+// Zone 电网分区
+type Zone struct {
+	ZoneId 			string		`json:"zone_id"`
+	AdminName 		string		`json:"admin_name"`
+}
+
+// TransferLimit 分区之间的输电限额，Capacity为从FromZone 到ToZone 的最大输电功率，单位为kW
+type TransferLimit struct {
+	FromZone 		string		`json:"from_zone"`
+	ToZone 			string		`json:"to_zone"`
+	Capacity 		int			`json:"capacity"`
+	AdminName 		string		`json:"admin_name"`
+}
+
+// TransferReservation compact占用的跨区输电功率，Load为交割时段内的平均功率，单位为kW
+type TransferReservation struct {
+	FromZone 		string		`json:"from_zone"`
+	ToZone 			string		`json:"to_zone"`
+	CompactId 		string		`json:"compact_id"`
+	PowerPlantName  string 		`json:"power_plant_name,omitempty" metadata:"power_plant_name,optional"`
+	Quantity 		int			`json:"quantity"`
+	Load 			int			`json:"load"`
+	StartTime 		string		`json:"start_time"`
+	EndTime 		string		`json:"end_time"`
+}
+
+// RegisterZone admin注册电网分区
+func (g *GridContract) RegisterZone(
+	ctx contractapi.TransactionContextInterface,
+	zoneId string,
+	adminName string) (*Zone, error) {
+	// 1.判断admin是否为调用者本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, adminName); err != nil {
+		return nil, err
+	}
+
+	// 2.判断分区是否存在
+	if _, err := g.QueryZone(ctx, zoneId); err == nil {
+		return nil, fmt.Errorf("Zone %s existed ! ", zoneId)
+	}
+
+	// 3.上链
+	zone := Zone{
+		ZoneId: zoneId,
+		AdminName: adminName,
+	}
+
+	zoneAsBytes, _ := json.Marshal(zone)
+	err := putState(ctx, zoneAsBytes, ZoneObjectType, zoneId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &zone, nil
+}
+
+// SetTransferLimit admin设置从fromZone 到toZone 的输电限额，capacity为0时两区之间不能输电
+func (g *GridContract) SetTransferLimit(
+	ctx contractapi.TransactionContextInterface,
+	fromZone string,
+	toZone string,
+	capacity int,
+	adminName string) (*TransferLimit, error) {
+	// 1.判断admin是否为调用者本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, adminName); err != nil {
+		return nil, err
+	}
+
+	// 2.判断参数
+	if fromZone == toZone {
+		return nil, fmt.Errorf("Transfer limit must be between different zones ! ")
+	}
+
+	if capacity < 0 {
+		return nil, fmt.Errorf("Capacity can not be negative ! ")
+	}
+
+	for _, zoneId := range []string{fromZone, toZone} {
+		if _, err := g.QueryZone(ctx, zoneId); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3.上链
+	limit := TransferLimit{
+		FromZone: fromZone,
+		ToZone: toZone,
+		Capacity: capacity,
+		AdminName: adminName,
+	}
+
+	limitAsBytes, _ := json.Marshal(limit)
+	err := putState(ctx, limitAsBytes, TransferLimitObjectType, fromZone, toZone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &limit, nil
+}
+
+// AssignZone admin把用户分配到电网分区
+func (g *GridContract) AssignZone(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	zoneId string) (*User, error) {
+	// 1.判断分区是否存在
+	if _, err := g.QueryZone(ctx, zoneId); err != nil {
+		return nil, err
+	}
+
+	// 2.获取用户
+	var r RoleContract
+	user, err := r.QueryUser(ctx, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.上链
+	user.Zone = zoneId
+	userAsBytes, _ := json.Marshal(user)
+	err = putState(ctx, userAsBytes, UserObjectType, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// QueryZone 获取电网分区
+func (g *GridContract) QueryZone(
+	ctx contractapi.TransactionContextInterface,
+	zoneId string) (*Zone, error) {
+	// 1.获取分区信息
+	zoneAsBytes, err := getState(ctx, ZoneObjectType, zoneId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Zone Info from world state. %s ", err.Error())
+	}
+
+	if zoneAsBytes == nil {
+		return nil, fmt.Errorf("Zone %s does not exist", zoneId)
+	}
+
+	// 2.赋值
+	zone := new(Zone)
+	_ = json.Unmarshal(zoneAsBytes, zone)
+
+	return zone, nil
+}
+
+// QueryTransferLimit 获取从fromZone 到toZone 的输电限额
+func (g *GridContract) QueryTransferLimit(
+	ctx contractapi.TransactionContextInterface,
+	fromZone string,
+	toZone string) (*TransferLimit, error) {
+	// 1.获取限额信息
+	limitAsBytes, err := getState(ctx, TransferLimitObjectType, fromZone, toZone)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query TransferLimit Info from world state. %s ", err.Error())
+	}
+
+	if limitAsBytes == nil {
+		return nil, fmt.Errorf("Transfer limit from %s to %s does not exist", fromZone, toZone)
+	}
+
+	// 2.赋值
+	limit := new(TransferLimit)
+	_ = json.Unmarshal(limitAsBytes, limit)
+
+	return limit, nil
+}
+
+// QueryTransferReservations 获取从fromZone 到toZone 的全部输电占用
+func (g *GridContract) QueryTransferReservations(
+	ctx contractapi.TransactionContextInterface,
+	fromZone string,
+	toZone string) ([]*TransferReservation, error) {
+	// 1.按分区查询输电占用
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(TransferReservationObjectType, []string{fromZone, toZone})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query TransferReservation Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	reservations := []*TransferReservation{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reservation := new(TransferReservation)
+		_ = json.Unmarshal(kv.Value, reservation)
+		reservations = append(reservations, reservation)
+	}
+
+	return reservations, nil
+}
+
+// PruneTransferReservations admin删除从fromZone 到toZone 交割已结束的输电占用，占用记录的数量不随历史交易增长
+// 返回删除的占用数量
+func (g *GridContract) PruneTransferReservations(
+	ctx contractapi.TransactionContextInterface,
+	fromZone string,
+	toZone string,
+	adminName string) (int, error) {
+	// 1.判断admin是否为调用者本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, adminName); err != nil {
+		return 0, err
+	}
+
+	// 2.获取输电占用
+	reservations, err := g.QueryTransferReservations(ctx, fromZone, toZone)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// 3.删除交割已结束的占用
+	var t TimeContract
+	pruned := 0
+	for _, reservation := range reservations {
+		ended, err := t.CompareWithNow(ctx, reservation.EndTime)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if !ended {
+			continue
+		}
+
+		if err := g.deleteReservation(ctx, reservation); err != nil {
+			return 0, err
+		}
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// QueryRemainingCapacity 获取从fromZone 到toZone 在startTime 与endTime 之间剩余的输电功率，单位为kW
+func (g *GridContract) QueryRemainingCapacity(
+	ctx contractapi.TransactionContextInterface,
+	fromZone string,
+	toZone string,
+	startTime string,
+	endTime string) (int, error) {
+	var t TimeContract
+	if !t.CompareTime(startTime, endTime) {
+		return 0, fmt.Errorf("End time earlier than Start time ! ")
+	}
+
+	return g.remainingLoad(ctx, fromZone, toZone, startTime, endTime, nil)
+}
+
+// transferRoute 获取powerPlant向powerUser输电经过的分区，任一方未分配分区或同区输电时不受限额约束，返回nil
+func (g *GridContract) transferRoute(
+	ctx contractapi.TransactionContextInterface,
+	powerPlantName string,
+	powerUserName string) (*TransferReservation, error) {
+	// 1.获取双方的分区
+	var r RoleContract
+	powerPlant, err := r.QueryUser(ctx, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	powerUser, err := r.QueryUser(ctx, powerUserName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.同区或未分配分区时不受限额约束
+	if powerPlant.Zone == "" || powerUser.Zone == "" || powerPlant.Zone == powerUser.Zone {
+		return nil, nil
+	}
+
+	return &TransferReservation{
+		FromZone: powerPlant.Zone,
+		ToZone: powerUser.Zone,
+	}, nil
+}
+
+// transferCapacity 计算route在startTime 与endTime 之间还能输送的电量
+// pending为本交易中已占用但尚未上链的输电功率
+func (g *GridContract) transferCapacity(
+	ctx contractapi.TransactionContextInterface,
+	route *TransferReservation,
+	startTime string,
+	endTime string,
+	pending []*TransferReservation) (int, error) {
+	load, err := g.remainingLoad(ctx, route.FromZone, route.ToZone, startTime, endTime, pending)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(int64(load) * g.windowSeconds(startTime, endTime) / 3600), nil
+}
+
+// reserveTransfer 按powerPlant分段的交割电量占用route的输电功率，返回占用记录
+func (g *GridContract) reserveTransfer(
+	ctx contractapi.TransactionContextInterface,
+	route *TransferReservation,
+	compactId string,
+	powerPlantName string,
+	quantity int,
+	startTime string,
+	endTime string) (*TransferReservation, error) {
+	// 1.按交割时段计算平均功率
+	reservation := TransferReservation{
+		FromZone: route.FromZone,
+		ToZone: route.ToZone,
+		CompactId: compactId,
+		PowerPlantName: powerPlantName,
+		Quantity: quantity,
+		Load: g.transferLoad(quantity, startTime, endTime),
+		StartTime: startTime,
+		EndTime: endTime,
+	}
+
+	// 2.上链
+	reservationAsBytes, _ := json.Marshal(reservation)
+	err := putState(ctx, reservationAsBytes, TransferReservationObjectType, route.FromZone, route.ToZone, compactId, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &reservation, nil
+}
+
+// remainingLoad 计算从fromZone 到toZone 在startTime 与endTime 之间剩余的输电功率
+// 剩余功率为限额减去时段内各时刻已占用功率的最大值，没有设置限额的分区之间不能输电
+// 交割已结束的占用不参与计算，由admin调用PruneTransferReservations删除
+func (g *GridContract) remainingLoad(
+	ctx contractapi.TransactionContextInterface,
+	fromZone string,
+	toZone string,
+	startTime string,
+	endTime string,
+	pending []*TransferReservation) (int, error) {
+	// 1.获取输电限额
+	limitAsBytes, err := getState(ctx, TransferLimitObjectType, fromZone, toZone)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to query TransferLimit Info from world state. %s ", err.Error())
+	}
+
+	if limitAsBytes == nil {
+		return 0, nil
+	}
+
+	limit := new(TransferLimit)
+	_ = json.Unmarshal(limitAsBytes, limit)
+
+	// 2.获取与时段重叠的输电占用，包括本交易中尚未上链的占用
+	reservations, err := g.QueryTransferReservations(ctx, fromZone, toZone)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var t TimeContract
+	overlapping := []*TransferReservation{}
+	for _, reservation := range reservations {
+		// 2.1交割已结束的占用不再参与计算
+		ended, err := t.CompareWithNow(ctx, reservation.EndTime)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if ended {
+			continue
+		}
+
+		if t.CompareTime(reservation.StartTime, endTime) && t.CompareTime(startTime, reservation.EndTime) {
+			overlapping = append(overlapping, reservation)
+		}
+	}
+
+	for _, reservation := range pending {
+		if reservation.FromZone != fromZone || reservation.ToZone != toZone {
+			continue
+		}
+
+		if t.CompareTime(reservation.StartTime, endTime) && t.CompareTime(startTime, reservation.EndTime) {
+			overlapping = append(overlapping, reservation)
+		}
+	}
+
+	// 3.占用功率只在某个占用开始时增加，逐个检查时段开始与各占用开始时刻的占用功率
+	peak := 0
+	points := []string{startTime}
+	for _, reservation := range overlapping {
+		if t.CompareTime(startTime, reservation.StartTime) {
+			points = append(points, reservation.StartTime)
+		}
+	}
+
+	for _, point := range points {
+		load := 0
+		for _, reservation := range overlapping {
+			if !t.CompareTime(point, reservation.StartTime) && t.CompareTime(point, reservation.EndTime) {
+				load += reservation.Load
+			}
+		}
+
+		if load > peak {
+			peak = load
+		}
+	}
+
+	if peak >= limit.Capacity {
+		return 0, nil
+	}
+
+	return limit.Capacity - peak, nil
+}
+
+// transferLoad 交割电量quantity在startTime 与endTime 之间的平均功率，向上取整
+func (g *GridContract) transferLoad(quantity int, startTime string, endTime string) int {
+	seconds := g.windowSeconds(startTime, endTime)
+
+	return int((int64(quantity)*3600 + seconds - 1) / seconds)
+}
+
+// windowSeconds 交割时段的秒数，至少为1秒
+func (g *GridContract) windowSeconds(startTime string, endTime string) int64 {
+	startObj, _ := time.ParseInLocation(TimeLayout, startTime, TimeLocation)
+	endObj, _ := time.ParseInLocation(TimeLayout, endTime, TimeLocation)
+
+	seconds := int64(endObj.Sub(startObj) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+
+	return seconds
+}
+
+// deleteReservation 删除输电占用记录
+func (g *GridContract) deleteReservation(
+	ctx contractapi.TransactionContextInterface,
+	reservation *TransferReservation) error {
+	attributes := []string{reservation.FromZone, reservation.ToZone, reservation.CompactId}
+	if reservation.PowerPlantName != "" {
+		attributes = append(attributes, reservation.PowerPlantName)
+	}
+
+	reservationKey, err := createKey(ctx, TransferReservationObjectType, attributes...)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(reservationKey)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// gridSetup 注册A、B两个分区，A区到B区最多输送1kW，p1在A区，其余用户在B区
+func gridSetup(t *testing.T) *testStub {
+	t.Helper()
+
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "u1", "u2")
+	register(t, s, PowerPlant, "p1", "p2")
+	deposit(t, s, "u1", 1000000)
+	deposit(t, s, "u2", 1000000)
+
+	call(t, s, "admin", "GridContract:RegisterZone", "A", "admin")
+	call(t, s, "admin", "GridContract:RegisterZone", "B", "admin")
+	call(t, s, "admin", "GridContract:SetTransferLimit", "A", "B", "1", "admin")
+
+	for userName, zoneId := range map[string]string{"p1": "A", "p2": "B", "u1": "B", "u2": "B"} {
+		call(t, s, "admin", "GridContract:AssignZone", userName, zoneId)
+	}
+
+	return s
+}
+
+// remainingCapacity 获取从fromZone 到toZone 在startTime 与endTime 之间剩余的输电功率
+func remainingCapacity(t *testing.T, s *testStub, fromZone string, toZone string, startTime string, endTime string) int {
+	t.Helper()
+
+	capacity, err := strconv.Atoi(string(call(t, s, "admin", "GridContract:QueryRemainingCapacity", fromZone, toZone, startTime, endTime)))
+	noError(t, err)
+
+	return capacity
+}
+
+func TestGridAdministration(t *testing.T) {
+	s := gridSetup(t)
+
+	if _, err := s.invoke("admin", "GridContract:RegisterZone", "A", "admin"); err == nil {
+		t.Fatal("zone registered twice")
+	}
+
+	if _, err := s.invoke("admin", "GridContract:SetTransferLimit", "A", "A", "1", "admin"); err == nil {
+		t.Fatal("transfer limit within a zone")
+	}
+
+	if _, err := s.invoke("admin", "GridContract:SetTransferLimit", "A", "C", "1", "admin"); err == nil {
+		t.Fatal("transfer limit to a missing zone")
+	}
+
+	if _, err := s.invoke("u1", "GridContract:AssignZone", "u1", "A"); err == nil {
+		t.Fatal("power user moved itself to another zone")
+	}
+
+	if user := queryUser(t, s, "p1"); user.Zone != "A" {
+		t.Fatalf("p1 zone = %s", user.Zone)
+	}
+}
+
+func TestTransferLimit(t *testing.T) {
+	s := gridSetup(t)
+	openMarket(t, s)
+
+	call(t, s, "u1", "MarketContract:SubmitBuyOrder", "D1", "b1", "u1", "100", "0.6")
+	call(t, s, "p1", "MarketContract:SubmitSellOrder", "D1", "s1", "p1", "70", "0.3")
+	call(t, s, "p2", "MarketContract:SubmitSellOrder", "D1", "s2", "p2", "70", "0.5")
+
+	call(t, s, "u2", "PowerTXContract:Commit", "c1", "u2", "10", "0.5", "2026-01-01 00:00:00", "2026-01-05 00:00:00")
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "u2", "PowerTXContract:Accept", "c1")
+
+	// 交割时段24小时，p1跨区最多成交24kWh，其余由同区的p2补足
+	s.advance(48 * time.Hour)
+
+	var m MarketContract
+	period, err := m.ClearMarket(s.ctx("admin"), "D1")
+	noError(t, err)
+
+	if period.ClearedQuantity != 94 {
+		t.Fatalf("period = %+v", period)
+	}
+
+	var g GridContract
+	reservations, err := g.QueryTransferReservations(s.ctx("admin"), "A", "B")
+	noError(t, err)
+
+	if len(reservations) != 1 || reservations[0].Quantity != 24 || reservations[0].Load != 1 {
+		t.Fatalf("reservations = %+v", reservations)
+	}
+
+	// c1的交割时段与已出清的占用重叠，超出输电限额
+	if _, err := s.invoke("admin", "PowerTXContract:Deal", "c1", "admin"); err == nil {
+		t.Fatal("deal beyond the transfer limit")
+	}
+
+	if capacity := remainingCapacity(t, s, "A", "B", "2026-01-04 00:00:00", "2026-01-05 00:00:00"); capacity != 1 {
+		t.Fatalf("remaining capacity = %d, want 1", capacity)
+	}
+
+	// 没有设置限额的方向不能输电
+	if capacity := remainingCapacity(t, s, "B", "A", "2026-01-04 00:00:00", "2026-01-05 00:00:00"); capacity != 0 {
+		t.Fatalf("remaining capacity from B to A = %d", capacity)
+	}
+}
+
+func TestClearingPriceWithTransferLimit(t *testing.T) {
+	s := gridSetup(t)
+	call(t, s, "admin", "GridContract:AssignZone", "u2", "A")
+	openMarket(t, s)
+
+	// u1跨区只能从p1买到24kWh，其余从高价的p2买入
+	call(t, s, "u1", "MarketContract:SubmitBuyOrder", "D1", "b1", "u1", "100", "0.7")
+	call(t, s, "u2", "MarketContract:SubmitBuyOrder", "D1", "b2", "u2", "50", "0.45")
+	call(t, s, "p1", "MarketContract:SubmitSellOrder", "D1", "s1", "p1", "70", "0.3")
+	call(t, s, "p2", "MarketContract:SubmitSellOrder", "D1", "s2", "p2", "76", "0.6")
+
+	s.advance(48 * time.Hour)
+
+	// u2的买价低于已成交的最高卖价，不能按统一价格成交
+	var m MarketContract
+	period, err := m.ClearMarket(s.ctx("admin"), "D1")
+	noError(t, err)
+
+	if period.ClearedQuantity != 100 || period.ClearingPrice != 650 {
+		t.Fatalf("period = %+v", period)
+	}
+}
+
+func TestDealReservesTransfer(t *testing.T) {
+	s := gridSetup(t)
+
+	// 4天的交割时段，1kW最多输送96kWh
+	call(t, s, "u1", "PowerTXContract:Commit", "c1", "u1", "96", "0.5", "2026-01-01 00:00:00", "2026-01-05 00:00:00")
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "u1", "PowerTXContract:Accept", "c1")
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	if capacity := remainingCapacity(t, s, "A", "B", "2026-01-03 00:00:00", "2026-01-04 00:00:00"); capacity != 0 {
+		t.Fatalf("remaining capacity = %d, want 0", capacity)
+	}
+
+	// 不重叠的时段不受影响
+	if capacity := remainingCapacity(t, s, "A", "B", "2026-01-05 00:00:00", "2026-01-06 00:00:00"); capacity != 1 {
+		t.Fatalf("remaining capacity after the reservation = %d, want 1", capacity)
+	}
+
+	call(t, s, "u2", "PowerTXContract:Commit", "c2", "u2", "1", "0.5", "2026-01-01 00:00:00", "2026-01-07 00:00:00")
+	call(t, s, "p1", "PowerTXContract:Bid", "c2", "p1", "0.5")
+	call(t, s, "u2", "PowerTXContract:Accept", "c2")
+
+	if _, err := s.invoke("admin", "PowerTXContract:Deal", "c2", "admin"); err == nil {
+		t.Fatal("deal overlapping a full reservation")
+	}
+}
+
+func TestPruneTransferReservations(t *testing.T) {
+	s := gridSetup(t)
+
+	call(t, s, "u1", "PowerTXContract:Commit", "c1", "u1", "24", "0.5", "2026-01-01 00:00:00", "2026-01-02 00:00:00")
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "u1", "PowerTXContract:Accept", "c1")
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	reservations := func() []TransferReservation {
+		var result []TransferReservation
+		noError(t, json.Unmarshal(call(t, s, "u1", "GridContract:QueryTransferReservations", "A", "B"), &result))
+		return result
+	}
+
+	// 查询剩余功率不删除交割已结束的占用，已结束的占用也不再占用输电功率
+	s.advance(24 * time.Hour)
+	if capacity := remainingCapacity(t, s, "A", "B", "2026-01-01 00:00:00", "2026-01-02 00:00:00"); capacity != 1 {
+		t.Fatalf("remaining capacity = %d, want 1", capacity)
+	}
+
+	if len(reservations()) != 1 {
+		t.Fatal("capacity query deleted an ended reservation")
+	}
+
+	if _, err := s.invoke("u1", "GridContract:PruneTransferReservations", "A", "B", "u1"); err == nil {
+		t.Fatal("power user pruned reservations")
+	}
+
+	if pruned := string(call(t, s, "admin", "GridContract:PruneTransferReservations", "A", "B", "admin")); pruned != "1" {
+		t.Fatalf("pruned = %s, want 1", pruned)
+	}
+
+	if len(reservations()) != 0 {
+		t.Fatal("ended reservation not pruned")
+	}
+}
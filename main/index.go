@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// compact索引的命名空间，索引键的最后一个属性为compactId，值为空
+const CompactByPowerUserIndex string = "CompactByPowerUser"
+const CompactByPowerPlantIndex string = "CompactByPowerPlant"
+const CompactByAdminIndex string = "CompactByAdmin"
+const CompactByStateIndex string = "CompactByState"
+const CompactByStartTimeIndex string = "CompactByStartTime"
+const CompactByFrameworkIndex string = "CompactByFramework"
+
+// CompactPage 分页查询compact的结果，Bookmark不为空时以其为参数查询下一页
+type CompactPage struct {
+	Compacts 			[]*Compact		`json:"compacts"`
+	FetchedRecordsCount int				`json:"fetched_records_count"`
+	Bookmark 			string			`json:"bookmark"`
+}
+
+// QueryCompactsByPowerUser 按powerUser分页查询compact
+func (p *PowerTXContract) QueryCompactsByPowerUser(
+	ctx contractapi.TransactionContextInterface,
+	powerUserName string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	return p.queryCompactIndex(ctx, CompactByPowerUserIndex, powerUserName, pageSize, bookmark)
+}
+
+// QueryCompactsByPowerPlant 按powerPlant分页查询compact，包括powerPlant供应分段的compact
+func (p *PowerTXContract) QueryCompactsByPowerPlant(
+	ctx contractapi.TransactionContextInterface,
+	powerPlantName string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	return p.queryCompactIndex(ctx, CompactByPowerPlantIndex, powerPlantName, pageSize, bookmark)
+}
+
+// QueryCompactsByAdmin 按admin分页查询compact
+func (p *PowerTXContract) QueryCompactsByAdmin(
+	ctx contractapi.TransactionContextInterface,
+	adminName string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	return p.queryCompactIndex(ctx, CompactByAdminIndex, adminName, pageSize, bookmark)
+}
+
+// QueryCompactsByState 按状态分页查询compact
+func (p *PowerTXContract) QueryCompactsByState(
+	ctx contractapi.TransactionContextInterface,
+	state string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	return p.queryCompactIndex(ctx, CompactByStateIndex, state, pageSize, bookmark)
+}
+
+// QueryCompactsByStartTime 按开始时间分页查询startFrom 与startTo 之间(包括两端)开始交割的compact，按开始时间排序
+// 分页书签即下一页的起始键，第一页从startFrom 对应的索引键开始
+func (p *PowerTXContract) QueryCompactsByStartTime(
+	ctx contractapi.TransactionContextInterface,
+	startFrom string,
+	startTo string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	// 1.判断参数
+	var t TimeContract
+	if t.CompareTime(startTo, startFrom) {
+		return nil, fmt.Errorf("End time earlier than Start time ! ")
+	}
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive ! ")
+	}
+
+	// 2.第一页从startFrom开始
+	if bookmark == "" {
+		startKey, err := createKey(ctx, CompactByStartTimeIndex, startFrom)
+
+		if err != nil {
+			return nil, err
+		}
+
+		bookmark = startKey
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(CompactByStartTimeIndex, []string{}, int32(pageSize), bookmark)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Compact index from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 3.获取索引指向的compact，超过startTo后不再有下一页
+	page := &CompactPage{
+		Compacts: []*Compact{},
+		Bookmark: metadata.Bookmark,
+	}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+
+		if err != nil || len(attributes) != 2 {
+			continue
+		}
+
+		if t.CompareTime(startTo, attributes[0]) {
+			page.Bookmark = ""
+			break
+		}
+
+		compact, err := p.QueryCompact(ctx, attributes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		page.Compacts = append(page.Compacts, compact)
+	}
+
+	page.FetchedRecordsCount = len(page.Compacts)
+
+	return page, nil
+}
+
+// queryCompactIndex 按索引分页查询compact
+func (p *PowerTXContract) queryCompactIndex(
+	ctx contractapi.TransactionContextInterface,
+	index string,
+	value string,
+	pageSize int,
+	bookmark string) (*CompactPage, error) {
+	// 1.判断参数
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive ! ")
+	}
+
+	// 2.分页查询索引，只读交易才能使用分页查询
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, []string{value}, int32(pageSize), bookmark)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Compact index from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 3.获取索引指向的compact
+	page := &CompactPage{
+		Compacts: []*Compact{},
+		Bookmark: metadata.Bookmark,
+	}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(kv.Key)
+
+		if err != nil || len(attributes) != 2 {
+			continue
+		}
+
+		compact, err := p.QueryCompact(ctx, attributes[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		page.Compacts = append(page.Compacts, compact)
+	}
+
+	page.FetchedRecordsCount = len(page.Compacts)
+
+	return page, nil
+}
+
+// putCompact compact上链，并更新compact的索引
+func (p *PowerTXContract) putCompact(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact) error {
+	// 1.获取旧的compact，用于删除过期的索引
+	var oldCompact *Compact
+	oldCompactAsBytes, err := getState(ctx, CompactObjectType, compact.CompactId)
+
+	if err != nil {
+		return fmt.Errorf("Failed to query Compact Info from world state. %s ", err.Error())
+	}
+
+	if oldCompactAsBytes != nil {
+		oldCompact = new(Compact)
+		_ = json.Unmarshal(oldCompactAsBytes, oldCompact)
+	}
+
+	// 2.compact上链
+	compactAsBytes, _ := json.Marshal(compact)
+	err = putState(ctx, compactAsBytes, CompactObjectType, compact.CompactId)
+
+	if err != nil {
+		return err
+	}
+
+	// 3.更新索引
+	return p.updateCompactIndexes(ctx, oldCompact, compact)
+}
+
+// updateCompactIndexes 删除oldCompact中不再使用的索引，写入compact的索引，oldCompact为nil时只写入
+func (p *PowerTXContract) updateCompactIndexes(
+	ctx contractapi.TransactionContextInterface,
+	oldCompact *Compact,
+	compact *Compact) error {
+	// 1.生成新索引键
+	newKeys := make(map[string]bool)
+	for _, key := range p.compactIndexKeys(compact) {
+		indexKey, err := createKey(ctx, key[0], key[1:]...)
+
+		if err != nil {
+			return err
+		}
+
+		newKeys[indexKey] = true
+	}
+
+	// 2.删除不再使用的旧索引键
+	if oldCompact != nil {
+		for _, key := range p.compactIndexKeys(oldCompact) {
+			indexKey, err := createKey(ctx, key[0], key[1:]...)
+
+			if err != nil {
+				return err
+			}
+
+			if newKeys[indexKey] {
+				continue
+			}
+
+			if err := ctx.GetStub().DelState(indexKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 3.写入新索引键，值为空字节
+	for _, key := range p.compactIndexKeys(compact) {
+		if err := putState(ctx, []byte{0x00}, key[0], key[1:]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compactIndexKeys 获取compact的全部索引，每个索引为命名空间与属性
+func (p *PowerTXContract) compactIndexKeys(compact *Compact) [][]string {
+	keys := [][]string{
+		{CompactByPowerUserIndex, compact.PowerUserName, compact.CompactId},
+		{CompactByStateIndex, compact.State, compact.CompactId},
+		{CompactByStartTimeIndex, compact.StartTime, compact.CompactId},
+	}
+
+	if compact.AdminName != "" {
+		keys = append(keys, []string{CompactByAdminIndex, compact.AdminName, compact.CompactId})
+	}
+
+	if compact.FrameworkId != "" {
+		keys = append(keys, []string{CompactByFrameworkIndex, compact.FrameworkId, compact.CompactId})
+	}
+
+	powerPlants := make(map[string]bool)
+	for _, leg := range compact.compactLegs() {
+		if leg.PowerPlantName == "" || powerPlants[leg.PowerPlantName] {
+			continue
+		}
+
+		powerPlants[leg.PowerPlantName] = true
+		keys = append(keys, []string{CompactByPowerPlantIndex, leg.PowerPlantName, compact.CompactId})
+	}
+
+	return keys
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// compactIds 获取分页结果中的compactId
+func compactIds(page *CompactPage) string {
+	ids := []string{}
+	for _, compact := range page.Compacts {
+		ids = append(ids, compact.CompactId)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func TestCompactIndexes(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+
+	// query 查询出错时终止测试
+	query := func(page *CompactPage, err error) string {
+		t.Helper()
+		noError(t, err)
+
+		return compactIds(page)
+	}
+
+	for i, compactId := range []string{"c1", "c2", "c3", "c4"} {
+		call(t, s, "alice", "PowerTXContract:Commit", compactId, "alice", "100", "0.5",
+			fmt.Sprintf("2025-12-0%d 00:00:00", i+1), "2026-02-01 00:00:00")
+	}
+
+	call(t, s, "plant", "PowerTXContract:Bid", "c2", "plant", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c2")
+	call(t, s, "admin", "PowerTXContract:Deal", "c2", "admin")
+	call(t, s, "plant", "PowerTXContract:Bid", "c3", "plant", "0.5")
+
+	// 按powerUser分页
+	var p PowerTXContract
+	page, err := p.QueryCompactsByPowerUser(s.ctx("alice"), "alice", 3, "")
+
+	if ids := query(page, err); ids != "c1,c2,c3" || page.Bookmark == "" {
+		t.Fatalf("first page = %s, bookmark %q", ids, page.Bookmark)
+	}
+
+	if ids := query(p.QueryCompactsByPowerUser(s.ctx("alice"), "alice", 3, page.Bookmark)); ids != "c4" {
+		t.Fatalf("second page = %s", ids)
+	}
+
+	if ids := query(p.QueryCompactsByPowerPlant(s.ctx("plant"), "plant", 10, "")); ids != "c2" {
+		t.Fatalf("by power plant = %s", ids)
+	}
+
+	if ids := query(p.QueryCompactsByAdmin(s.ctx("admin"), "admin", 10, "")); ids != "c2" {
+		t.Fatalf("by admin = %s", ids)
+	}
+
+	// 状态索引随状态转换更新
+	states := map[string]string{CompactCommitting: "c1,c4", CompactBiding: "c3", CompactAccepted: "", CompactDeal: "c2"}
+	for state, want := range states {
+		if ids := query(p.QueryCompactsByState(s.ctx("admin"), state, 10, "")); ids != want {
+			t.Errorf("%s = %s, want %s", state, ids, want)
+		}
+	}
+
+	// 按开始时间查询，包括两端
+	if ids := query(p.QueryCompactsByStartTime(s.ctx("admin"), "2025-12-02 00:00:00", "2025-12-03 00:00:00", 10, "")); ids != "c2,c3" {
+		t.Fatalf("by start time = %s", ids)
+	}
+
+	page, err = p.QueryCompactsByStartTime(s.ctx("admin"), "2025-12-02 00:00:00", "2025-12-09 00:00:00", 2, "")
+
+	if ids := query(page, err); ids != "c2,c3" || page.Bookmark == "" {
+		t.Fatalf("first page by start time = %s, bookmark %q", ids, page.Bookmark)
+	}
+
+	if ids := query(p.QueryCompactsByStartTime(s.ctx("admin"), "2025-12-02 00:00:00", "2025-12-09 00:00:00", 2, page.Bookmark)); ids != "c4" {
+		t.Fatalf("second page by start time = %s", ids)
+	}
+}
+
+func TestMigrationIndexesCompacts(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	putLegacyState(s, map[string]string{
+		"c1": `{"compact_id":"c1","power_user_name":"alice","state":"Committing","start_time":"2026-01-01 00:00:00"}`,
+	})
+
+	call(t, s, "admin", "MigrationContract:MigrateCompositeKeys", "10")
+
+	var p PowerTXContract
+	page, err := p.QueryCompactsByPowerUser(s.ctx("alice"), "alice", 10, "")
+	noError(t, err)
+
+	if ids := compactIds(page); ids != "c1" {
+		t.Fatalf("migrated compacts = %s", ids)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// 世界状态中各类实体的组合键命名空间，不同实体的键互不冲突
+const UserObjectType string = "User"
+const IdentityObjectType string = "Identity"
+const UserListObjectType string = "UserList"
+const AdminBootstrapObjectType string = "AdminBootstrap"
+const CompactObjectType string = "Compact"
+const CompactHistoryObjectType string = "CompactHistory"
+const NegotiationObjectType string = "Negotiation"
+const ElectionProposalObjectType string = "ElectionProposal"
+const CommitteeObjectType string = "Committee"
+const BallotProposalObjectType string = "BallotProposal"
+const VotingProposalsObjectType string = "VotingProposals"
+const VariableObjectType string = "Variable"
+const BidObjectType string = "Bid"
+const SealedBidObjectType string = "SealedBid"
+const MarketPeriodObjectType string = "MarketPeriod"
+const OrderObjectType string = "Order"
+const AccountObjectType string = "Account"
+const AccountEntryObjectType string = "AccountEntry"
+const MeterObjectType string = "Meter"
+const MeterReadingObjectType string = "MeterReading"
+const ZoneObjectType string = "Zone"
+const TransferLimitObjectType string = "TransferLimit"
+const TransferReservationObjectType string = "TransferReservation"
+const CertificateObjectType string = "Certificate"
+const EmissionFactorObjectType string = "EmissionFactor"
+const CarbonEntryObjectType string = "CarbonEntry"
+const FrameworkObjectType string = "Framework"
+const IntervalSettlementObjectType string = "IntervalSettlement"
+const AdminAssignmentObjectType string = "AdminAssignment"
+const CompactApprovalObjectType string = "CompactApproval"
+
+// createKey 生成objectType命名空间下的组合键
+func createKey(
+	ctx contractapi.TransactionContextInterface,
+	objectType string,
+	attributes ...string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(objectType, attributes)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create %s key. %s ", objectType, err.Error())
+	}
+
+	return key, nil
+}
+
+// getState 读取objectType命名空间下的记录
+func getState(
+	ctx contractapi.TransactionContextInterface,
+	objectType string,
+	attributes ...string) ([]byte, error) {
+	key, err := createKey(ctx, objectType, attributes...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.GetStub().GetState(key)
+}
+
+// putState 写入objectType命名空间下的记录
+func putState(
+	ctx contractapi.TransactionContextInterface,
+	value []byte,
+	objectType string,
+	attributes ...string) error {
+	key, err := createKey(ctx, objectType, attributes...)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, value)
+}
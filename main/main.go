@@ -6,14 +6,84 @@ import (
 )
 
 func main() {
+	// 每个合约交易执行前检查调用者权限，交易成功后发出收集的业务事件
+	roleContract := new(RoleContract)
+	roleContract.BeforeTransaction = CheckPermission
+	roleContract.AfterTransaction = FlushEvents
+	roleContract.TransactionContextHandler = new(TransactionContext)
+	powerTXContract := new(PowerTXContract)
+	powerTXContract.BeforeTransaction = CheckPermission
+	powerTXContract.AfterTransaction = FlushEvents
+	powerTXContract.TransactionContextHandler = new(TransactionContext)
+	electionContract := new(ElectionContract)
+	electionContract.BeforeTransaction = CheckPermission
+	electionContract.AfterTransaction = FlushEvents
+	electionContract.TransactionContextHandler = new(TransactionContext)
+	ballotContract := new(BallotContract)
+	ballotContract.BeforeTransaction = CheckPermission
+	ballotContract.AfterTransaction = FlushEvents
+	ballotContract.TransactionContextHandler = new(TransactionContext)
+	varChangeContract := new(VarChangeContract)
+	varChangeContract.BeforeTransaction = CheckPermission
+	varChangeContract.AfterTransaction = FlushEvents
+	varChangeContract.TransactionContextHandler = new(TransactionContext)
+	timeContract := new(TimeContract)
+	timeContract.BeforeTransaction = CheckPermission
+	timeContract.AfterTransaction = FlushEvents
+	timeContract.TransactionContextHandler = new(TransactionContext)
+	accountContract := new(AccountContract)
+	accountContract.BeforeTransaction = CheckPermission
+	accountContract.AfterTransaction = FlushEvents
+	accountContract.TransactionContextHandler = new(TransactionContext)
+	marketContract := new(MarketContract)
+	marketContract.BeforeTransaction = CheckPermission
+	marketContract.AfterTransaction = FlushEvents
+	marketContract.TransactionContextHandler = new(TransactionContext)
+	meterContract := new(MeterContract)
+	meterContract.BeforeTransaction = CheckPermission
+	meterContract.AfterTransaction = FlushEvents
+	meterContract.TransactionContextHandler = new(TransactionContext)
+	gridContract := new(GridContract)
+	gridContract.BeforeTransaction = CheckPermission
+	gridContract.AfterTransaction = FlushEvents
+	gridContract.TransactionContextHandler = new(TransactionContext)
+	certificateContract := new(CertificateContract)
+	certificateContract.BeforeTransaction = CheckPermission
+	certificateContract.AfterTransaction = FlushEvents
+	certificateContract.TransactionContextHandler = new(TransactionContext)
+	carbonContract := new(CarbonContract)
+	carbonContract.BeforeTransaction = CheckPermission
+	carbonContract.AfterTransaction = FlushEvents
+	carbonContract.TransactionContextHandler = new(TransactionContext)
+	frameworkContract := new(FrameworkContract)
+	frameworkContract.BeforeTransaction = CheckPermission
+	frameworkContract.AfterTransaction = FlushEvents
+	frameworkContract.TransactionContextHandler = new(TransactionContext)
+	expiryContract := new(ExpiryContract)
+	expiryContract.BeforeTransaction = CheckPermission
+	expiryContract.AfterTransaction = FlushEvents
+	expiryContract.TransactionContextHandler = new(TransactionContext)
+	migrationContract := new(MigrationContract)
+	migrationContract.BeforeTransaction = CheckPermission
+	migrationContract.AfterTransaction = FlushEvents
+	migrationContract.TransactionContextHandler = new(TransactionContext)
 
 	chaincode, err := contractapi.NewChaincode(
-		new(RoleContract),
-		new(PowerTXContract),
-		new(ElectionContract),
-		new(BallotContract),
-		new(VarChangeContract),
-		new(TimeContract))
+		roleContract,
+		powerTXContract,
+		electionContract,
+		ballotContract,
+		varChangeContract,
+		timeContract,
+		marketContract,
+		accountContract,
+		meterContract,
+		gridContract,
+		certificateContract,
+		carbonContract,
+		frameworkContract,
+		expiryContract,
+		migrationContract)
 
 	if err != nil {
 		fmt.Printf("Error create Contract chaincode: %s", err.Error())
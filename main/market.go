@@ -0,0 +1,667 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+)
+
+type MarketContract struct {
+	contractapi.Contract
+}
+
+// MarketPeriod 集中竞价的交割时段
+type MarketPeriod struct {
+	PeriodId 			string		`json:"period_id"`
+	AdminName 			string		`json:"admin_name"`
+	State 				string		`json:"state"`
+	GateClosureTime 	string		`json:"gate_closure_time"`
+	StartTime 			string		`json:"start_time"`
+	EndTime 			string		`json:"end_time"`
+	ClearingPrice 		Price		`json:"clearing_price"`
+	ClearedQuantity 	int			`json:"cleared_quantity"`
+	CompactIds 			[]string	`json:"compact_ids"`
+}
+
+// Order 买卖申报，powerUser申报买电，powerPlant申报卖电
+type Order struct {
+	OrderId 			string		`json:"order_id"`
+	PeriodId 			string		`json:"period_id"`
+	Side 				string		`json:"side"`
+	UserName 			string		`json:"user_name"`
+	Quantity 			int			`json:"quantity"`
+	Price 				Price		`json:"price"`
+	MatchedQuantity 	int			`json:"matched_quantity"`
+	Escrowed 			int64		`json:"escrowed"`
+	State 				string		`json:"state"`
+	OrderTime 			string		`json:"order_time"`
+}
+
+// Buy 买电申报 Sell 卖电申报
+const Buy string = "Buy"
+const Sell string = "Sell"
+
+// OpenMarketPeriod admin开启交割时段的集中竞价，gateClosureTime前接受申报
+func (m *MarketContract) OpenMarketPeriod(
+	ctx contractapi.TransactionContextInterface,
+	periodId string,
+	adminName string,
+	gateClosureTime string,
+	startTime string,
+	endTime string) (*MarketPeriod, error) {
+	// 1.判断admin是否为调用者本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, adminName); err != nil {
+		return nil, err
+	}
+
+	// 2.判断时段是否存在
+	period, err := m.QueryMarketPeriod(ctx, periodId)
+
+	if err == nil {
+		return nil, fmt.Errorf("Market period %s existed ! ", period.PeriodId)
+	}
+
+	// 3.判断时间是否符合规范，申报截止不晚于交割开始
+	var t TimeContract
+	if !t.CompareTime(startTime, endTime) {
+		return nil, fmt.Errorf("End time earlier than start time ! ")
+	}
+
+	if t.CompareTime(startTime, gateClosureTime) {
+		return nil, fmt.Errorf("Gate closure time later than start time ! ")
+	}
+
+	// 4.结构体赋值
+	period = &MarketPeriod{
+		PeriodId: periodId,
+		AdminName: adminName,
+		State: "Open",
+		GateClosureTime: gateClosureTime,
+		StartTime: startTime,
+		EndTime: endTime,
+		CompactIds: []string{},
+	}
+
+	// 5.上链
+	err = m.putMarketPeriod(ctx, period)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return period, nil
+}
+
+// SubmitBuyOrder powerUser提交买电申报
+func (m *MarketContract) SubmitBuyOrder(
+	ctx contractapi.TransactionContextInterface,
+	periodId string,
+	orderId string,
+	powerUserName string,
+	quantity int,
+	price string) (*Order, error) {
+	return m.submitOrder(ctx, periodId, orderId, Buy, powerUserName, quantity, price)
+}
+
+// SubmitSellOrder powerPlant提交卖电申报
+func (m *MarketContract) SubmitSellOrder(
+	ctx contractapi.TransactionContextInterface,
+	periodId string,
+	orderId string,
+	powerPlantName string,
+	quantity int,
+	price string) (*Order, error) {
+	return m.submitOrder(ctx, periodId, orderId, Sell, powerPlantName, quantity, price)
+}
+
+// CancelOrder 申报人在申报截止前撤销申报
+func (m *MarketContract) CancelOrder(
+	ctx contractapi.TransactionContextInterface,
+	periodId string,
+	orderId string) (*Order, error) {
+	// 1.获取申报
+	order, err := m.QueryOrder(ctx, periodId, orderId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为申报人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, order.UserName); err != nil {
+		return nil, err
+	}
+
+	// 3.判断时段是否接受申报
+	if _, err := m.checkGateOpen(ctx, periodId); err != nil {
+		return nil, err
+	}
+
+	// 4.判断申报状态
+	if order.State != "Open" {
+		return nil, fmt.Errorf("Order state is not open ! ")
+	}
+
+	// 5.买电申报的托管款退回
+	if order.Escrowed > 0 {
+		var a AccountContract
+		_, err = a.updateAccount(ctx, order.UserName, []AccountEntry{{
+			EntryType: Refund,
+			Amount: order.Escrowed,
+			EscrowChange: -order.Escrowed,
+		}})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 6.撤销申报并上链
+	order.State = "Canceled"
+	order.Escrowed = 0
+
+	err = m.putOrder(ctx, order)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// ClearMarket admin在申报截止后出清，按统一边际价格撮合买卖申报，每笔撮合生成一个Deal状态的compact
+// 跨区撮合受剩余输电限额约束，超出限额的电量被削减，买方继续与价格更高的卖方撮合
+func (m *MarketContract) ClearMarket(
+	ctx contractapi.TransactionContextInterface,
+	periodId string) (*MarketPeriod, error) {
+	// 1.获取时段
+	period, err := m.QueryMarketPeriod(ctx, periodId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为时段的admin
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, period.AdminName); err != nil {
+		return nil, err
+	}
+
+	// 3.判断时段状态，申报截止后才能出清
+	if period.State != "Open" {
+		return nil, fmt.Errorf("Market period state is not open ! ")
+	}
+
+	var t TimeContract
+	closed, err := t.CompareWithNow(ctx, period.GateClosureTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !closed {
+		return nil, fmt.Errorf("Market period gate is not closed ! ")
+	}
+
+	// 4.获取全部有效申报
+	orders, err := m.QueryOrders(ctx, periodId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buyOrders := []*Order{}
+	sellOrders := []*Order{}
+	for _, order := range orders {
+		if order.State != "Open" {
+			continue
+		}
+
+		if order.Side == Buy {
+			buyOrders = append(buyOrders, order)
+		} else {
+			sellOrders = append(sellOrders, order)
+		}
+	}
+
+	// 5.买方按价格从高到低，卖方按价格从低到高，价格相同时按申报时间和申报编号排序
+	sort.SliceStable(buyOrders, func(i, j int) bool {
+		return m.orderBefore(buyOrders[i], buyOrders[j], buyOrders[i].Price > buyOrders[j].Price)
+	})
+	sort.SliceStable(sellOrders, func(i, j int) bool {
+		return m.orderBefore(sellOrders[i], sellOrders[j], sellOrders[i].Price < sellOrders[j].Price)
+	})
+
+	// 6.撮合，买价不低于卖价时成交，跨区撮合的电量不超过剩余输电限额
+	type match struct {
+		buy *Order
+		sell *Order
+		quantity int
+		route *TransferReservation
+	}
+
+	var g GridContract
+	matches := []match{}
+	reservations := []*TransferReservation{}
+	var maxSellPrice, minBuyPrice Price
+	for _, buy := range buyOrders {
+		// 6.1统一出清价格不能低于已成交的最高卖价，买价低于该价格的买方及其后的买方都不能成交
+		if len(matches) > 0 && buy.Price < maxSellPrice {
+			break
+		}
+
+		for _, sell := range sellOrders {
+			if buy.Price < sell.Price || buy.MatchedQuantity == buy.Quantity {
+				break
+			}
+
+			quantity := buy.Quantity - buy.MatchedQuantity
+			if sell.Quantity - sell.MatchedQuantity < quantity {
+				quantity = sell.Quantity - sell.MatchedQuantity
+			}
+
+			if quantity == 0 {
+				continue
+			}
+
+			// 6.2跨区撮合按剩余输电限额削减电量，本交易的占用尚未上链，一并计入
+			route, err := g.transferRoute(ctx, sell.UserName, buy.UserName)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if route != nil {
+				capacity, err := g.transferCapacity(ctx, route, period.StartTime, period.EndTime, reservations)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if capacity < quantity {
+					quantity = capacity
+				}
+
+				if quantity == 0 {
+					continue
+				}
+
+				reservations = append(reservations, &TransferReservation{
+					FromZone: route.FromZone,
+					ToZone: route.ToZone,
+					Quantity: quantity,
+					Load: g.transferLoad(quantity, period.StartTime, period.EndTime),
+					StartTime: period.StartTime,
+					EndTime: period.EndTime,
+				})
+			}
+
+			buy.MatchedQuantity += quantity
+			sell.MatchedQuantity += quantity
+			if sell.Price > maxSellPrice {
+				maxSellPrice = sell.Price
+			}
+			minBuyPrice = buy.Price
+			matches = append(matches, match{buy, sell, quantity, route})
+		}
+	}
+
+	// 7.统一出清价格为已成交的最高卖价与最低买价的中间值，不高于任何成交买价，不低于任何成交卖价
+	if len(matches) > 0 {
+		amount, err := addAmount(int64(maxSellPrice), int64(minBuyPrice))
+
+		if err != nil {
+			return nil, err
+		}
+
+		period.ClearingPrice = averagePrice(amount, 2)
+	}
+
+	// 8.每笔撮合生成compact，按出清价格托管买方的付款
+	var p PowerTXContract
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	compactEscrowed := make(map[string]int64)
+	for k, match := range matches {
+		compactId := generatedCompactId("market", periodId, fmt.Sprintf("%d", k + 1))
+
+		if p.CompactExist(ctx, compactId) {
+			return nil, fmt.Errorf("Compact %s existed ! ", compactId)
+		}
+
+		amount, err := period.ClearingPrice.Amount(match.quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		compact := Compact{
+			CompactId: compactId,
+			PowerPlantName: match.sell.UserName,
+			PowerUserName: match.buy.UserName,
+			AdminName: period.AdminName,
+			Transaction: match.quantity,
+			Price: period.ClearingPrice,
+			StartTime: period.StartTime,
+			EndTime: period.EndTime,
+			Legs: []CompactLeg{{
+				PowerPlantName: match.sell.UserName,
+				Quantity: match.quantity,
+				Price: period.ClearingPrice,
+			}},
+			Escrowed: amount,
+		}
+		compactEscrowed[match.buy.OrderId] += compact.Escrowed
+
+		if match.route != nil {
+			_, err = g.reserveTransfer(ctx, match.route, compactId, match.sell.UserName, match.quantity, period.StartTime, period.EndTime)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// 8.1大额compact需要委员会批准后执行Deal
+		if err := p.putGeneratedCompact(ctx, &compact, "ClearMarket", variables); err != nil {
+			return nil, err
+		}
+
+		period.CompactIds = append(period.CompactIds, compactId)
+		period.ClearedQuantity += match.quantity
+	}
+
+	// 9.买电申报托管款中超出出清金额的部分退回
+	entries := make(map[string][]AccountEntry)
+	for _, order := range buyOrders {
+		refund := order.Escrowed - compactEscrowed[order.OrderId]
+
+		if refund > 0 {
+			entries[order.UserName] = append(entries[order.UserName], AccountEntry{
+				EntryType: Refund,
+				Amount: refund,
+				EscrowChange: -refund,
+			})
+		}
+
+		order.Escrowed = compactEscrowed[order.OrderId]
+	}
+
+	var a AccountContract
+	err = a.updateAccounts(ctx, entries)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 10.更新申报状态
+	for _, order := range append(buyOrders, sellOrders...) {
+		if order.MatchedQuantity == order.Quantity {
+			order.State = "Matched"
+		} else if order.MatchedQuantity > 0 {
+			order.State = "PartiallyMatched"
+		} else {
+			order.State = "Unmatched"
+		}
+
+		if err := m.putOrder(ctx, order); err != nil {
+			return nil, err
+		}
+	}
+
+	// 11.时段出清结果上链
+	period.State = "Cleared"
+
+	err = m.putMarketPeriod(ctx, period)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return period, nil
+}
+
+// QueryMarketPeriod 获取交割时段
+func (m *MarketContract) QueryMarketPeriod(
+	ctx contractapi.TransactionContextInterface,
+	periodId string) (*MarketPeriod, error) {
+	// 1.获取时段信息
+	periodAsBytes, err := getState(ctx, MarketPeriodObjectType, periodId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query MarketPeriod Info from world state. %s ", err.Error())
+	}
+
+	if periodAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", periodId)
+	}
+
+	// 2.赋值
+	period := new(MarketPeriod)
+	_ = json.Unmarshal(periodAsBytes, period)
+
+	return period, nil
+}
+
+// QueryOrder 获取申报
+func (m *MarketContract) QueryOrder(
+	ctx contractapi.TransactionContextInterface,
+	periodId string,
+	orderId string) (*Order, error) {
+	// 1.获取申报信息
+	orderAsBytes, err := getState(ctx, OrderObjectType, periodId, orderId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Order Info from world state. %s ", err.Error())
+	}
+
+	if orderAsBytes == nil {
+		return nil, fmt.Errorf("%s does not exist", orderId)
+	}
+
+	// 2.赋值
+	order := new(Order)
+	_ = json.Unmarshal(orderAsBytes, order)
+
+	return order, nil
+}
+
+// QueryOrders 获取交割时段的全部申报
+func (m *MarketContract) QueryOrders(
+	ctx contractapi.TransactionContextInterface,
+	periodId string) ([]*Order, error) {
+	// 1.按periodId查询申报
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(OrderObjectType, []string{periodId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Order Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	orders := []*Order{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		order := new(Order)
+		_ = json.Unmarshal(kv.Value, order)
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// submitOrder 提交买卖申报
+func (m *MarketContract) submitOrder(
+	ctx contractapi.TransactionContextInterface,
+	periodId string,
+	orderId string,
+	side string,
+	userName string,
+	quantity int,
+	priceText string) (*Order, error) {
+	// 1.判断申报人是否为调用者本人
+	var r RoleContract
+	user, err := r.checkCaller(ctx, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.查看申报人信用值， 若小于某个额度，则拒绝申报
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("%s credit less than %d ", userName, variables[CreditBorder])
+	}
+
+	// 3.判断申报数量与价格
+	price, err := ParsePrice(priceText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if quantity <= 0 || price <= 0 {
+		return nil, fmt.Errorf("Quantity and price must be positive ! ")
+	}
+
+	// 4.判断时段是否接受申报
+	if _, err := m.checkGateOpen(ctx, periodId); err != nil {
+		return nil, err
+	}
+
+	// 5.判断申报是否存在
+	if _, err := m.QueryOrder(ctx, periodId, orderId); err == nil {
+		return nil, fmt.Errorf("Order %s existed ! ", orderId)
+	}
+
+	// 6.买电申报按申报价格托管付款
+	escrow := int64(0)
+	if side == Buy {
+		escrow, err = price.Amount(quantity)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var a AccountContract
+		_, err = a.updateAccount(ctx, userName, []AccountEntry{{
+			EntryType: Escrow,
+			Amount: -escrow,
+			EscrowChange: escrow,
+		}})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 7.结构体赋值
+	var t TimeContract
+	orderTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	order := Order{
+		OrderId: orderId,
+		PeriodId: periodId,
+		Side: side,
+		UserName: userName,
+		Quantity: quantity,
+		Price: price,
+		MatchedQuantity: 0,
+		Escrowed: escrow,
+		State: "Open",
+		OrderTime: orderTime,
+	}
+
+	// 8.上链
+	err = m.putOrder(ctx, &order)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// checkGateOpen 判断交割时段是否仍接受申报
+func (m *MarketContract) checkGateOpen(
+	ctx contractapi.TransactionContextInterface,
+	periodId string) (*MarketPeriod, error) {
+	// 1.获取时段
+	period, err := m.QueryMarketPeriod(ctx, periodId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断时段状态
+	if period.State != "Open" {
+		return nil, fmt.Errorf("Market period state is not open ! ")
+	}
+
+	// 3.判断是否已过申报截止时间
+	var t TimeContract
+	closed, err := t.CompareWithNow(ctx, period.GateClosureTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if closed {
+		return nil, fmt.Errorf("Market period gate is closed ! ")
+	}
+
+	return period, nil
+}
+
+// orderBefore 申报排序，价格优先，其次时间优先，最后按申报编号保证结果确定
+func (m *MarketContract) orderBefore(a *Order, b *Order, betterPrice bool) bool {
+	if a.Price != b.Price {
+		return betterPrice
+	}
+
+	if a.OrderTime != b.OrderTime {
+		return a.OrderTime < b.OrderTime
+	}
+
+	return a.OrderId < b.OrderId
+}
+
+// putMarketPeriod 交割时段上链
+func (m *MarketContract) putMarketPeriod(
+	ctx contractapi.TransactionContextInterface,
+	period *MarketPeriod) error {
+	periodAsBytes, _ := json.Marshal(period)
+
+	return putState(ctx, periodAsBytes, MarketPeriodObjectType, period.PeriodId)
+}
+
+// putOrder 申报上链
+func (m *MarketContract) putOrder(
+	ctx contractapi.TransactionContextInterface,
+	order *Order) error {
+	orderAsBytes, _ := json.Marshal(order)
+
+	return putState(ctx, orderAsBytes, OrderObjectType, order.PeriodId, order.OrderId)
+}
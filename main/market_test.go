@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// openMarket admin开启交割时段D1，申报截止时间为2026-01-02 00:00:00
+func openMarket(t *testing.T, s *testStub) {
+	t.Helper()
+
+	call(t, s, "admin", "MarketContract:OpenMarketPeriod", "D1", "admin",
+		"2026-01-02 00:00:00", "2026-01-03 00:00:00", "2026-01-04 00:00:00")
+}
+
+func TestClearMarket(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "u1", "u2")
+	register(t, s, PowerPlant, "p1", "p2")
+	deposit(t, s, "u1", 60000)
+	deposit(t, s, "u2", 20000)
+	openMarket(t, s)
+
+	var m MarketContract
+	noError(t, errOf(m.SubmitBuyOrder(s.ctx("u1"), "D1", "b1", "u1", 100, "0.6")))
+	noError(t, errOf(m.SubmitBuyOrder(s.ctx("u2"), "D1", "b2", "u2", 50, "0.4")))
+	noError(t, errOf(m.SubmitSellOrder(s.ctx("p1"), "D1", "s1", "p1", 70, "0.3")))
+	noError(t, errOf(m.SubmitSellOrder(s.ctx("p2"), "D1", "s2", "p2", 70, "0.5")))
+
+	// 申报人只能以本人名义申报
+	if _, err := s.invoke("p1", "MarketContract:SubmitSellOrder", "D1", "s3", "p2", "10", "0.3"); err == nil {
+		t.Fatal("p1 submitted an order for p2")
+	}
+
+	if _, err := s.invoke("admin", "MarketContract:ClearMarket", "D1"); err == nil {
+		t.Fatal("cleared before gate closure")
+	}
+
+	s.advance(48 * time.Hour)
+
+	if _, err := s.invoke("u2", "MarketContract:SubmitBuyOrder", "D1", "b3", "u2", "10", "0.7"); err == nil {
+		t.Fatal("order accepted after gate closure")
+	}
+
+	period, err := m.ClearMarket(s.ctx("admin"), "D1")
+	noError(t, err)
+
+	if period.ClearingPrice != 550 || period.ClearedQuantity != 100 || len(period.CompactIds) != 2 {
+		t.Fatalf("period = %+v", period)
+	}
+
+	var p PowerTXContract
+	for _, compactId := range period.CompactIds {
+		compact, err := p.QueryCompact(s.ctx("u1"), compactId)
+		noError(t, err)
+
+		if compact.State != "Deal" || compact.Price != 550 || compact.PowerUserName != "u1" || compact.AdminName != "admin" {
+			t.Errorf("compact = %+v", compact)
+		}
+	}
+
+	// 出清生成的compact编号在保留的命名空间中，powerUser不能抢先提交
+	if period.CompactIds[0] != generatedCompactId("market", "D1", "1") {
+		t.Fatalf("compact ids = %v", period.CompactIds)
+	}
+
+	if _, err := s.invoke("u1", "PowerTXContract:Commit", generatedCompactId("market", "D1", "3"), "u1", "10", "0.5",
+		"2026-01-01 00:00:00", "2026-02-01 00:00:00"); err == nil {
+		t.Fatal("committed a generated compact id")
+	}
+
+	orders, err := m.QueryOrders(s.ctx("admin"), "D1")
+	noError(t, err)
+
+	states := make(map[string]string)
+	for _, order := range orders {
+		states[order.OrderId] = order.State
+	}
+
+	if states["b1"] != "Matched" || states["b2"] != "Unmatched" || states["s1"] != "Matched" || states["s2"] != "PartiallyMatched" {
+		t.Fatalf("order states = %v", states)
+	}
+
+	// 买方按出清价格托管，超出部分与未成交申报的托管款退回
+	if account := queryAccount(t, s, "u1"); account.Balance != 5000 || account.Escrowed != 55000 {
+		t.Fatalf("u1 account = %+v", account)
+	}
+
+	if account := queryAccount(t, s, "u2"); account.Balance != 20000 || account.Escrowed != 0 {
+		t.Fatalf("u2 account = %+v", account)
+	}
+
+	if _, err := s.invoke("admin", "MarketContract:ClearMarket", "D1"); err == nil {
+		t.Fatal("market cleared twice")
+	}
+}
+
+func TestClearMarketWithoutCross(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "u1")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "u1", 40000)
+	openMarket(t, s)
+
+	var m MarketContract
+	noError(t, errOf(m.SubmitBuyOrder(s.ctx("u1"), "D1", "b1", "u1", 100, "0.4")))
+	noError(t, errOf(m.SubmitSellOrder(s.ctx("p1"), "D1", "s1", "p1", 100, "0.5")))
+
+	s.advance(48 * time.Hour)
+	period, err := m.ClearMarket(s.ctx("admin"), "D1")
+	noError(t, err)
+
+	if period.ClearedQuantity != 0 || len(period.CompactIds) != 0 || period.State != "Cleared" {
+		t.Fatalf("period = %+v", period)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "u1", "u2")
+	deposit(t, s, "u1", 40000)
+	openMarket(t, s)
+
+	var m MarketContract
+	noError(t, errOf(m.SubmitBuyOrder(s.ctx("u1"), "D1", "b1", "u1", 100, "0.4")))
+
+	if _, err := s.invoke("u2", "MarketContract:CancelOrder", "D1", "b1"); err == nil {
+		t.Fatal("order canceled by another user")
+	}
+
+	order, err := m.CancelOrder(s.ctx("u1"), "D1", "b1")
+	noError(t, err)
+
+	if order.State != "Canceled" || order.Escrowed != 0 {
+		t.Fatalf("order = %+v", order)
+	}
+
+	if account := queryAccount(t, s, "u1"); account.Balance != 40000 || account.Escrowed != 0 {
+		t.Fatalf("u1 account = %+v", account)
+	}
+}
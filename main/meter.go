@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strconv"
+	"strings"
+)
+
+type MeterContract struct {
+	contractapi.Contract
+}
+
+// Meter 智能电表，PublicKey为电表ed25519公钥的base64编码
+type Meter struct {
+	MeterId 		string		`json:"meter_id"`
+	UserName 		string		`json:"user_name"`
+	PublicKey 		string		`json:"public_key"`
+	Nonce 			int64		`json:"nonce"`
+}
+
+// MeterReading 电表对compact的计量读数，Energy为交割期内的累计电量
+type MeterReading struct {
+	MeterId 		string		`json:"meter_id"`
+	CompactId 		string		`json:"compact_id"`
+	UserName 		string		`json:"user_name"`
+	Energy 			int			`json:"energy"`
+	Intervals 		[]int		`json:"intervals,omitempty" metadata:"intervals,optional"`
+	Nonce 			int64		`json:"nonce"`
+	ReadingTime 	string		`json:"reading_time"`
+	Signature 		string		`json:"signature"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// RegisterMeter admin登记电表及其所属用户
+func (m *MeterContract) RegisterMeter(
+	ctx contractapi.TransactionContextInterface,
+	meterId string,
+	userName string,
+	publicKey string) (*Meter, error) {
+	// 1.判断调用者是否为admin
+	var r RoleContract
+	if _, err := r.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	// 2.判断电表是否已登记
+	meterAsBytes, err := getState(ctx, MeterObjectType, meterId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Meter Info from world state. %s ", err.Error())
+	}
+
+	if meterAsBytes != nil {
+		return nil, fmt.Errorf("Meter %s is exist ! ", meterId)
+	}
+
+	// 2.1判断所属用户是否存在
+	if !r.UserExist(ctx, userName) {
+		return nil, fmt.Errorf("%s does not exist", userName)
+	}
+
+	// 3.判断公钥格式
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Public key must be a base64 encoded ed25519 public key ! ")
+	}
+
+	// 4.电表结构体赋值
+	meter := Meter{
+		MeterId: meterId,
+		UserName: userName,
+		PublicKey: publicKey,
+	}
+
+	meterAsBytes, _ = json.Marshal(meter)
+
+	// 5.上链
+	err = putState(ctx, meterAsBytes, MeterObjectType, meterId)
+
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	return &meter, nil
+}
+
+// SubmitMeterReading 提交电表签名的计量读数
+// 签名内容为 total|meterId|compactId|energy|nonce|readingTime，nonce必须大于该电表已使用的nonce，防止重放
+func (m *MeterContract) SubmitMeterReading(
+	ctx contractapi.TransactionContextInterface,
+	meterId string,
+	compactId string,
+	energy int,
+	nonce int64,
+	readingTime string,
+	signature string) (*MeterReading, error) {
+	return m.submitReading(ctx, meterId, compactId, energy, nil, nonce, readingTime,
+		meterMessage(meterId, compactId, energy, nonce, readingTime), signature)
+}
+
+// SubmitIntervalReadings 提交电表签名的分时读数，intervals为从compact开始的各15分钟时段的电量，只能包括已结束的时段
+// 签名内容为 interval|meterId|compactId|各时段电量以逗号分隔|nonce|readingTime
+func (m *MeterContract) SubmitIntervalReadings(
+	ctx contractapi.TransactionContextInterface,
+	meterId string,
+	compactId string,
+	intervals []int,
+	nonce int64,
+	readingTime string,
+	signature string) (*MeterReading, error) {
+	// 1.判断compact是否有分时曲线
+	var p PowerTXContract
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(intervals) == 0 || len(intervals) > len(compact.Profile) {
+		return nil, fmt.Errorf("Compact %s has %d profile slots ! ", compactId, len(compact.Profile))
+	}
+
+	// 2.判断读数包括的时段已结束
+	var t TimeContract
+	if t.CompareTime(readingTime, t.addMinutes(compact.StartTime, len(intervals) * ProfileSlotMinutes)) {
+		return nil, fmt.Errorf("Meter reading includes unfinished slots ! ")
+	}
+
+	// 3.汇总电量
+	energy := 0
+	for _, interval := range intervals {
+		if interval < 0 {
+			return nil, fmt.Errorf("Meter reading energy must not be negative ! ")
+		}
+
+		energy += interval
+	}
+
+	return m.submitReading(ctx, meterId, compactId, energy, intervals, nonce, readingTime,
+		intervalMessage(meterId, compactId, intervals, nonce, readingTime), signature)
+}
+
+// submitReading 验证电表签名并保存读数，message为电表签名的内容
+func (m *MeterContract) submitReading(
+	ctx contractapi.TransactionContextInterface,
+	meterId string,
+	compactId string,
+	energy int,
+	intervals []int,
+	nonce int64,
+	readingTime string,
+	message []byte,
+	signature string) (*MeterReading, error) {
+	// 1.获取电表信息
+	meter, err := m.QueryMeter(ctx, meterId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.验证签名
+	key, _ := base64.StdEncoding.DecodeString(meter.PublicKey)
+	sig, err := base64.StdEncoding.DecodeString(signature)
+
+	if err != nil || !ed25519.Verify(key, message, sig) {
+		return nil, fmt.Errorf("Meter reading signature is invalid ! ")
+	}
+
+	// 3.判断nonce
+	if nonce <= meter.Nonce {
+		return nil, fmt.Errorf("Meter reading nonce %d has been used ! ", nonce)
+	}
+
+	if energy < 0 {
+		return nil, fmt.Errorf("Meter reading energy must not be negative ! ")
+	}
+
+	// 4.判断compact状态，只有已成交未结算的compact可以提交读数
+	var p PowerTXContract
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.State != CompactDeal {
+		return nil, fmt.Errorf("Compact state is not Deal ! ")
+	}
+
+	// 4.1有分时曲线的compact只接受分时读数
+	if len(compact.Profile) > 0 && intervals == nil {
+		return nil, fmt.Errorf("Compact %s has a profile, submit interval readings ! ", compactId)
+	}
+
+	// 4.2电表所属用户必须是compact的powerUser或powerPlant
+	party := meter.UserName == compact.PowerUserName
+	for _, leg := range compact.compactLegs() {
+		if meter.UserName == leg.PowerPlantName {
+			party = true
+		}
+	}
+
+	if !party {
+		return nil, fmt.Errorf("Meter %s does not belong to compact %s ! ", meterId, compactId)
+	}
+
+	// 5.判断读数时间，必须在交割开始之后且不晚于交易时间
+	var t TimeContract
+	if t.CompareTime(readingTime, compact.StartTime) {
+		return nil, fmt.Errorf("Meter reading is before the compact start ! ")
+	}
+
+	timeNow, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t.CompareTime(timeNow, readingTime) {
+		return nil, fmt.Errorf("Meter reading time is in the future ! ")
+	}
+
+	// 6.读数结构体赋值，同一电表对同一compact的新读数覆盖旧读数
+	reading := MeterReading{
+		MeterId: meterId,
+		CompactId: compactId,
+		UserName: meter.UserName,
+		Energy: energy,
+		Intervals: intervals,
+		Nonce: nonce,
+		ReadingTime: readingTime,
+		Signature: signature,
+		TxId: ctx.GetStub().GetTxID(),
+	}
+
+	readingAsBytes, _ := json.Marshal(reading)
+	meter.Nonce = nonce
+	meterAsBytes, _ := json.Marshal(meter)
+
+	// 7.上链
+	err = putState(ctx, readingAsBytes, MeterReadingObjectType, compactId, meterId)
+
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	err = putState(ctx, meterAsBytes, MeterObjectType, meterId)
+
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
+
+	return &reading, nil
+}
+
+// QueryMeter 获取电表信息
+func (m *MeterContract) QueryMeter(
+	ctx contractapi.TransactionContextInterface,
+	meterId string) (*Meter, error) {
+	meterAsBytes, err := getState(ctx, MeterObjectType, meterId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Meter Info from world state. %s ", err.Error())
+	}
+
+	if meterAsBytes == nil {
+		return nil, fmt.Errorf("Meter %s does not exist ", meterId)
+	}
+
+	meter := new(Meter)
+	_ = json.Unmarshal(meterAsBytes, meter)
+
+	return meter, nil
+}
+
+// QueryMeterReadings 获取compact的全部电表读数，按电表编号排序
+func (m *MeterContract) QueryMeterReadings(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*MeterReading, error) {
+	// 1.按compactId查询读数
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(MeterReadingObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query MeterReading Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	readings := []*MeterReading{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		reading := new(MeterReading)
+		_ = json.Unmarshal(kv.Value, reading)
+		readings = append(readings, reading)
+	}
+
+	return readings, nil
+}
+
+// meteredEnergy 按用户汇总compact的电表读数，没有读数的用户电量为0
+func (m *MeterContract) meteredEnergy(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (map[string]int, error) {
+	readings, err := m.QueryMeterReadings(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	energy := make(map[string]int)
+	for _, reading := range readings {
+		energy[reading.UserName] += reading.Energy
+	}
+
+	return energy, nil
+}
+
+// finalReadingTimes 按用户获取compact最后一条读数的读数时间
+func (m *MeterContract) finalReadingTimes(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (map[string]string, error) {
+	readings, err := m.QueryMeterReadings(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var t TimeContract
+	finals := make(map[string]string)
+	for _, reading := range readings {
+		final, ok := finals[reading.UserName]
+		if !ok || t.CompareTime(final, reading.ReadingTime) {
+			finals[reading.UserName] = reading.ReadingTime
+		}
+	}
+
+	return finals, nil
+}
+
+// meteredIntervals 按用户逐时段汇总compact的分时读数，没有分时读数的用户不包括在内
+func (m *MeterContract) meteredIntervals(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (map[string][]int, error) {
+	readings, err := m.QueryMeterReadings(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	intervals := make(map[string][]int)
+	for _, reading := range readings {
+		if len(reading.Intervals) == 0 {
+			continue
+		}
+
+		sums := intervals[reading.UserName]
+		for i, interval := range reading.Intervals {
+			if i == len(sums) {
+				sums = append(sums, 0)
+			}
+
+			sums[i] += interval
+		}
+
+		intervals[reading.UserName] = sums
+	}
+
+	return intervals, nil
+}
+
+// meterMessage 电表签名的内容，以total开头，与分时读数的签名区分
+func meterMessage(meterId string, compactId string, energy int, nonce int64, readingTime string) []byte {
+	return []byte(fmt.Sprintf("total|%s|%s|%d|%d|%s", meterId, compactId, energy, nonce, readingTime))
+}
+
+// intervalMessage 电表对分时读数签名的内容，以interval开头，单个时段的读数签名不能作为总电量读数重放
+func intervalMessage(meterId string, compactId string, intervals []int, nonce int64, readingTime string) []byte {
+	values := make([]string, len(intervals))
+	for i, interval := range intervals {
+		values[i] = strconv.Itoa(interval)
+	}
+
+	return []byte(fmt.Sprintf("interval|%s|%s|%s|%d|%s", meterId, compactId, strings.Join(values, ","), nonce, readingTime))
+}
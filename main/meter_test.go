@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// meterSetup alice与plant的compact c1已成交，交割时段为2026-01-01至2026-02-01
+func meterSetup(t *testing.T) *testStub {
+	t.Helper()
+
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice", "bob")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+	dealCompact(t, s, "c1", "alice", "plant", 100)
+
+	return s
+}
+
+func TestRegisterMeter(t *testing.T) {
+	s := meterSetup(t)
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	encoded := base64.StdEncoding.EncodeToString(publicKey)
+
+	if _, err := s.invoke("alice", "MeterContract:RegisterMeter", "m1", "alice", encoded); err == nil {
+		t.Fatal("power user registered a meter")
+	}
+
+	// 绕过路由直接调用时合约本身也只允许admin登记
+	var m MeterContract
+	for _, userName := range []string{"alice", "mallory"} {
+		if _, err := m.RegisterMeter(s.ctx(userName), "m1", "alice", encoded); err == nil {
+			t.Fatalf("%s registered a meter without the router", userName)
+		}
+	}
+
+	if _, err := s.invoke("admin", "MeterContract:RegisterMeter", "m1", "mallory", encoded); err == nil {
+		t.Fatal("meter registered to a missing user")
+	}
+
+	if _, err := s.invoke("admin", "MeterContract:RegisterMeter", "m1", "alice", "not-a-key"); err == nil {
+		t.Fatal("meter registered with a bad public key")
+	}
+
+	call(t, s, "admin", "MeterContract:RegisterMeter", "m1", "alice", encoded)
+
+	if _, err := s.invoke("admin", "MeterContract:RegisterMeter", "m1", "bob", encoded); err == nil {
+		t.Fatal("meter registered twice")
+	}
+}
+
+func TestSubmitMeterReading(t *testing.T) {
+	s := meterSetup(t)
+	key := registerMeter(t, s, "m-alice", "alice")
+	s.advance(24 * time.Hour)
+
+	noError(t, submitReading(s, key, "m-alice", "c1", 10, 1))
+
+	// nonce不能重复使用
+	if err := submitReading(s, key, "m-alice", "c1", 20, 1); err == nil {
+		t.Fatal("nonce replayed")
+	}
+
+	// 签名必须来自电表私钥
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	if err := submitReading(s, otherKey, "m-alice", "c1", 20, 2); err == nil {
+		t.Fatal("reading with a forged signature accepted")
+	}
+
+	// 签名内容包含读数，篡改后验证失败
+	readingTime := s.timeNow()
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, meterMessage("m-alice", "c1", 20, 3, readingTime)))
+
+	if _, err := s.invoke("gateway", "MeterContract:SubmitMeterReading", "m-alice", "c1", "200", "3", readingTime, signature); err == nil {
+		t.Fatal("tampered reading accepted")
+	}
+
+	// 单个时段的分时读数签名不能作为总电量读数重放
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, intervalMessage("m-alice", "c1", []int{20}, 3, readingTime)))
+
+	if _, err := s.invoke("gateway", "MeterContract:SubmitMeterReading", "m-alice", "c1", "20", "3", readingTime, signature); err == nil {
+		t.Fatal("interval signature replayed as a total reading")
+	}
+
+	// 读数时间不能晚于交易时间
+	future := s.now.Add(time.Hour).Format(TimeLayout)
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, meterMessage("m-alice", "c1", 20, 4, future)))
+
+	if _, err := s.invoke("gateway", "MeterContract:SubmitMeterReading", "m-alice", "c1", "20", "4", future, signature); err == nil {
+		t.Fatal("future reading accepted")
+	}
+
+	// 新读数覆盖旧读数
+	noError(t, submitReading(s, key, "m-alice", "c1", 30, 5))
+
+	var m MeterContract
+	readings, err := m.QueryMeterReadings(s.ctx("admin"), "c1")
+	noError(t, err)
+
+	if len(readings) != 1 || readings[0].Energy != 30 || readings[0].Nonce != 5 {
+		t.Fatalf("readings = %+v", readings)
+	}
+}
+
+func TestMeterMustBelongToCompact(t *testing.T) {
+	s := meterSetup(t)
+	key := registerMeter(t, s, "m-bob", "bob")
+	s.advance(24 * time.Hour)
+
+	if err := submitReading(s, key, "m-bob", "c1", 10, 1); err == nil {
+		t.Fatal("reading from a meter outside the compact accepted")
+	}
+}
+
+func TestCheckCompactUsesMeterReadings(t *testing.T) {
+	s := meterSetup(t)
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+	meterReading(t, s, "alice", "c1", 100)
+	meterReading(t, s, "plant", "c1", 80)
+
+	var p PowerTXContract
+	compact, err := p.CheckCompact(s.ctx("admin"), "c1")
+	noError(t, err)
+
+	if compact.State != "Done" || compact.Legs[0].Delivered != 80 {
+		t.Fatalf("checked compact = %+v", compact)
+	}
+
+	// plant少供电20%，超出容忍区间两档；alice按约用电获得奖励
+	if user := queryUser(t, s, "plant"); user.Power != 80 || user.UserCredit != 80 {
+		t.Fatalf("plant = %+v", user)
+	}
+
+	if user := queryUser(t, s, "alice"); user.UserCredit != 115 {
+		t.Fatalf("alice = %+v", user)
+	}
+}
+
+func TestCheckCompactRequiresFinalReadings(t *testing.T) {
+	s := meterSetup(t)
+	plantKey := registerMeter(t, s, "m-plant", "plant")
+
+	// plant的读数早于EndTime，alice没有读数
+	s.now = time.Date(2026, 1, 20, 0, 0, 0, 0, TimeLocation)
+	noError(t, submitReading(s, plantKey, "m-plant", "c1", 60, 1))
+
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+
+	if _, err := s.invoke("admin", "PowerTXContract:CheckCompact", "c1"); err == nil {
+		t.Fatal("checked without any alice reading")
+	}
+
+	meterReading(t, s, "alice", "c1", 100)
+
+	if _, err := s.invoke("admin", "PowerTXContract:CheckCompact", "c1"); err == nil {
+		t.Fatal("checked with a plant reading before EndTime")
+	}
+
+	// 超过MeterGraceHours后按已上报的电量结算
+	s.now = time.Date(2026, 2, 2, 1, 0, 0, 0, TimeLocation)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	if compact := queryCompact(t, s, "c1"); compact.Legs[0].Delivered != 60 {
+		t.Fatalf("delivered = %d, want 60", compact.Legs[0].Delivered)
+	}
+}
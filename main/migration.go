@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"math"
+	"strings"
+)
+
+// MigrationObjectType 迁移记录命名空间
+const MigrationObjectType string = "Migration"
+
+// CompositeKeyMigration 原始键迁移到组合键
+const CompositeKeyMigration string = "CompositeKey"
+
+// PriceMigration 浮点电价(元/kWh)迁移到定点电价(厘/kWh)
+const PriceMigration string = "Price"
+
+// priceObjectTypes 包含电价的命名空间，按顺序迁移
+var priceObjectTypes = []string{CompactObjectType, BidObjectType, OrderObjectType, MarketPeriodObjectType}
+
+type MigrationContract struct {
+	contractapi.Contract
+}
+
+// Migration 数据迁移记录
+type Migration struct {
+	MigrationName 	string		`json:"migration_name"`
+	Migrated 		int			`json:"migrated"`
+	Skipped 		[]string	`json:"skipped"`
+	Bookmark 		string		`json:"bookmark"`
+	Done 			bool		`json:"done"`
+}
+
+// MigrateCompositeKeys 把旧版本使用原始键保存的记录改写到组合键命名空间下
+// 每次最多处理pageSize条记录，未完成时重复调用，完成后不能再次执行
+// 只有admin可以执行，迁移前的链可以先由InitAdmin注册admin，旧用户迁移后由admin通过BindIdentity绑定证书身份
+func (m *MigrationContract) MigrateCompositeKeys(
+	ctx contractapi.TransactionContextInterface,
+	pageSize int) (*Migration, error) {
+	// 1.获取迁移记录，判断是否已完成
+	migration, err := m.QueryMigration(ctx, CompositeKeyMigration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if migration.Done {
+		return nil, fmt.Errorf("Migration %s has done ! ", CompositeKeyMigration)
+	}
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive ! ")
+	}
+
+	// 2.遍历原始键，范围查询不会返回组合键
+	iterator, err := ctx.GetStub().GetStateByRange(migration.Bookmark, "")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	k := 0
+	migration.Bookmark = ""
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// 2.1本次已处理pageSize条，记录下一条的位置
+		if k == pageSize {
+			migration.Bookmark = kv.Key
+			break
+		}
+		k++
+
+		// 2.2识别记录类型，无法识别的记录保留原样
+		objectType, attributes := m.classifyRecord(kv.Key, kv.Value)
+
+		if objectType == "" {
+			migration.Skipped = append(migration.Skipped, kv.Key)
+			continue
+		}
+
+		// 2.3用户列表合并迁移前已注册的用户，如InitAdmin注册的admin
+		value := kv.Value
+		if objectType == UserListObjectType {
+			var r RoleContract
+			userList := new(UserList)
+			_ = json.Unmarshal(value, userList)
+
+			legacy := make(map[string]bool)
+			for _, userName := range userList.Users {
+				legacy[userName] = true
+			}
+
+			for _, userName := range r.QueryUserList(ctx).Users {
+				if !legacy[userName] {
+					userList.Users = append(userList.Users, userName)
+				}
+			}
+
+			value, _ = json.Marshal(userList)
+		}
+
+		// 2.4写入组合键，删除原始键
+		err = putState(ctx, value, objectType, attributes...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = ctx.GetStub().DelState(kv.Key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// 2.5compact同时建立索引
+		if objectType == CompactObjectType {
+			var p PowerTXContract
+			compact := new(Compact)
+			_ = json.Unmarshal(kv.Value, compact)
+
+			if err := p.updateCompactIndexes(ctx, nil, compact); err != nil {
+				return nil, err
+			}
+		}
+
+		migration.Migrated++
+	}
+
+	// 3.没有剩余记录，迁移完成
+	migration.Done = migration.Bookmark == ""
+
+	// 4.迁移记录上链
+	migrationAsBytes, _ := json.Marshal(migration)
+	err = putState(ctx, migrationAsBytes, MigrationObjectType, migration.MigrationName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return migration, nil
+}
+
+// MigratePrices 把旧版本以浮点数(元/kWh)保存的电价改写为定点电价(厘/kWh)，四舍五入到厘
+// 每次最多处理pageSize条记录，未完成时重复调用，完成后不能再次执行
+// 旧电价与新电价都可能是整数，无法逐条判断是否已迁移，因此升级后必须在交易恢复前执行且只执行一次
+func (m *MigrationContract) MigratePrices(
+	ctx contractapi.TransactionContextInterface,
+	pageSize int) (*Migration, error) {
+	// 1.获取迁移记录，判断是否已完成
+	migration, err := m.QueryMigration(ctx, PriceMigration)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if migration.Done {
+		return nil, fmt.Errorf("Migration %s has done ! ", PriceMigration)
+	}
+
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("Page size must be positive ! ")
+	}
+
+	// 2.从上次的位置继续
+	bookmark := migration.Bookmark
+	start := 0
+	if bookmark != "" {
+		objectType, _, err := ctx.GetStub().SplitCompositeKey(bookmark)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i, priceObjectType := range priceObjectTypes {
+			if priceObjectType == objectType {
+				start = i
+			}
+		}
+	}
+
+	// 3.按命名空间逐条改写，写入世界状态时不能使用分页查询接口，跳过Bookmark之前的记录
+	k := 0
+	migration.Bookmark = ""
+	for i := start; i < len(priceObjectTypes) && migration.Bookmark == ""; i++ {
+		iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(priceObjectTypes[i], []string{})
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to query %s Info from world state. %s ", priceObjectTypes[i], err.Error())
+		}
+
+		for iterator.HasNext() {
+			kv, err := iterator.Next()
+
+			if err != nil {
+				iterator.Close()
+				return nil, err
+			}
+
+			if kv.Key < bookmark {
+				continue
+			}
+
+			// 3.1本次已处理pageSize条，记录下一条的位置
+			if k == pageSize {
+				migration.Bookmark = kv.Key
+				break
+			}
+			k++
+
+			// 3.2改写电价，无法解析的记录保留原样
+			value, err := m.convertPrices(priceObjectTypes[i], kv.Value)
+
+			if err != nil {
+				migration.Skipped = append(migration.Skipped, kv.Key)
+				continue
+			}
+
+			err = ctx.GetStub().PutState(kv.Key, value)
+
+			if err != nil {
+				iterator.Close()
+				return nil, err
+			}
+
+			migration.Migrated++
+		}
+
+		iterator.Close()
+	}
+
+	// 4.没有剩余记录，迁移完成
+	migration.Done = migration.Bookmark == ""
+
+	// 5.迁移记录上链
+	migrationAsBytes, _ := json.Marshal(migration)
+	err = putState(ctx, migrationAsBytes, MigrationObjectType, migration.MigrationName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return migration, nil
+}
+
+// QueryMigration 获取迁移记录
+func (m *MigrationContract) QueryMigration(
+	ctx contractapi.TransactionContextInterface,
+	migrationName string) (*Migration, error) {
+	// 1.获取迁移记录
+	migrationAsBytes, err := getState(ctx, MigrationObjectType, migrationName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Migration Info from world state. %s ", err.Error())
+	}
+
+	// 2.迁移未开始
+	migration := &Migration{
+		MigrationName: migrationName,
+		Skipped: []string{},
+	}
+
+	if migrationAsBytes == nil {
+		return migration, nil
+	}
+
+	// 3.赋值
+	_ = json.Unmarshal(migrationAsBytes, migration)
+
+	return migration, nil
+}
+
+// classifyRecord 根据原始键和记录内容判断记录所属的命名空间与组合键属性
+func (m *MigrationContract) classifyRecord(key string, value []byte) (string, []string) {
+	fields := make(map[string]json.RawMessage)
+
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return "", nil
+	}
+
+	has := func(field string) bool {
+		_, ok := fields[field]
+		return ok
+	}
+
+	switch {
+	case has("compact_id"):
+		return CompactObjectType, []string{key}
+	case has("user_role"):
+		return UserObjectType, []string{key}
+	case has("election_proposal_name"):
+		return ElectionProposalObjectType, []string{key}
+	case has("ballot_proposal_name"):
+		return BallotProposalObjectType, []string{key}
+	case has("cert_id"):
+		identity := new(Identity)
+		_ = json.Unmarshal(value, identity)
+		return IdentityObjectType, []string{identity.MspId, identity.CertId}
+	case has("version") && strings.HasPrefix(key, "Variable"):
+		return VariableObjectType, []string{strings.TrimPrefix(key, "Variable")}
+	case has("Proposals") && strings.HasPrefix(key, "VotingProposals"):
+		return VotingProposalsObjectType, []string{strings.TrimPrefix(key, "VotingProposals")}
+	case has("Users") && key == "UserList":
+		return UserListObjectType, nil
+	case has("Users") && key == "COMMITTEE":
+		return CommitteeObjectType, nil
+	}
+
+	return "", nil
+}
+
+// convertPrices 把记录中的浮点电价改写为定点电价，并按当前结构体重新编码
+func (m *MigrationContract) convertPrices(objectType string, value []byte) ([]byte, error) {
+	// 1.解析记录
+	fields := make(map[string]interface{})
+
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, err
+	}
+
+	// 2.改写电价字段
+	convert := func(record map[string]interface{}, field string) {
+		if price, ok := record[field].(float64); ok {
+			record[field] = int64(math.Round(price * float64(PriceScale)))
+		}
+	}
+
+	convert(fields, "price")
+	convert(fields, "clearing_price")
+
+	if legs, ok := fields["legs"].([]interface{}); ok {
+		for _, leg := range legs {
+			if legFields, ok := leg.(map[string]interface{}); ok {
+				convert(legFields, "price")
+			}
+		}
+	}
+
+	// 3.按当前结构体重新编码，保持字段顺序
+	fieldsAsBytes, _ := json.Marshal(fields)
+
+	var record interface{}
+	switch objectType {
+	case CompactObjectType:
+		record = new(Compact)
+	case BidObjectType:
+		record = new(CompactBid)
+	case OrderObjectType:
+		record = new(Order)
+	case MarketPeriodObjectType:
+		record = new(MarketPeriod)
+	default:
+		return nil, fmt.Errorf("%s has no price ! ", objectType)
+	}
+
+	if err := json.Unmarshal(fieldsAsBytes, record); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(record)
+}
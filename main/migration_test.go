@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// putLegacyState 以旧版本的原始键写入记录
+func putLegacyState(s *testStub, records map[string]string) {
+	s.ctx("admin")
+	for key, value := range records {
+		_ = s.PutState(key, []byte(value))
+	}
+}
+
+func TestMigrateCompositeKeys(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	putLegacyState(s, map[string]string{
+		"alice":                `{"user_name":"alice","user_role":"powerUser","user_credit":100}`,
+		"c1":                   `{"compact_id":"c1","power_user_name":"alice","state":"Committing"}`,
+		"UserList":             `{"Users":["alice"]}`,
+		"VotingProposalsalice": `{"Proposals":[]}`,
+		"junk":                 `not json`,
+	})
+
+	if _, err := s.invoke("mallory", "MigrationContract:MigrateCompositeKeys", "2"); err == nil {
+		t.Fatal("migration run by an unregistered user")
+	}
+
+	var migration Migration
+	noError(t, json.Unmarshal(call(t, s, "admin", "MigrationContract:MigrateCompositeKeys", "2"), &migration))
+
+	if migration.Done || migration.Bookmark == "" {
+		t.Fatalf("first page = %+v", migration)
+	}
+
+	for !migration.Done {
+		noError(t, json.Unmarshal(call(t, s, "admin", "MigrationContract:MigrateCompositeKeys", "2"), &migration))
+	}
+
+	if migration.Migrated != 4 || len(migration.Skipped) != 1 || migration.Skipped[0] != "junk" {
+		t.Fatalf("migration = %+v", migration)
+	}
+
+	// 原始键已删除，记录可以按组合键读取
+	for _, key := range []string{"alice", "c1", "UserList", "VotingProposalsalice"} {
+		if value, _ := s.GetState(key); value != nil {
+			t.Errorf("legacy key %s kept", key)
+		}
+	}
+
+	if user := queryUser(t, s, "alice"); user.UserCredit != 100 {
+		t.Fatalf("alice = %+v", user)
+	}
+
+	var p PowerTXContract
+	compact, err := p.QueryCompact(s.ctx("alice"), "c1")
+	noError(t, err)
+
+	if compact.PowerUserName != "alice" {
+		t.Fatalf("c1 = %+v", compact)
+	}
+
+	var r RoleContract
+	// 迁移前由InitAdmin注册的admin保留在用户列表中
+	if users := r.QueryUserList(s.ctx("alice")).Users; len(users) != 2 || users[0] != "alice" || users[1] != "admin" {
+		t.Fatalf("user list = %v", users)
+	}
+
+	if _, err := s.invoke("admin", "MigrationContract:MigrateCompositeKeys", "2"); err == nil {
+		t.Fatal("migration ran twice")
+	}
+
+	// 迁移的用户没有证书身份，由admin绑定后才能以本人身份交易
+	if _, err := s.invoke("alice", "RoleContract:QueryCaller"); err == nil {
+		t.Fatal("unbound legacy user identified")
+	}
+
+	if _, err := s.invoke("alice", "RoleContract:BindIdentity", "alice", "Org1MSP", certId(s, "alice")); err == nil {
+		t.Fatal("legacy user bound its own identity")
+	}
+
+	call(t, s, "admin", "RoleContract:BindIdentity", "alice", "Org1MSP", certId(s, "alice"))
+
+	if _, err := s.invoke("admin", "RoleContract:BindIdentity", "alice", "Org1MSP", certId(s, "mallory")); err == nil {
+		t.Fatal("identity bound twice")
+	}
+
+	caller := new(User)
+	noError(t, json.Unmarshal(call(t, s, "alice", "RoleContract:QueryCaller"), caller))
+
+	if caller.UserName != "alice" || caller.UserCredit != 100 {
+		t.Fatalf("caller = %+v", caller)
+	}
+}
+
+func TestCompositeKeysDoNotCollide(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "c1")
+
+	// 用户名与compactId相同时记录互不覆盖
+	var p PowerTXContract
+	noError(t, errOf(p.Commit(s.ctx("c1"), "c1", "c1", 100, "0.5", "2026-01-01 00:00:00", "2026-02-01 00:00:00")))
+
+	if user := queryUser(t, s, "c1"); user.UserRole != PowerUser {
+		t.Fatalf("user c1 = %+v", user)
+	}
+
+	compact, err := p.QueryCompact(s.ctx("c1"), "c1")
+	noError(t, err)
+
+	if compact.PowerUserName != "c1" {
+		t.Fatalf("compact c1 = %+v", compact)
+	}
+}
+
+func TestMigratePrices(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+
+	ctx := s.ctx("admin")
+	noError(t, putState(ctx, []byte(`{"compact_id":"c1","state":"Deal","price":0.43333334,"legs":[{"power_plant_name":"p1","quantity":10,"price":0.45,"delivered":0}],"escrowed":4500}`), CompactObjectType, "c1"))
+	noError(t, putState(ctx, []byte(`{"compact_id":"c1","power_plant_name":"p1","price":0.45}`), BidObjectType, "c1", "p1"))
+
+	if _, err := s.invoke("alice", "MigrationContract:MigratePrices", "1"); err == nil {
+		t.Fatal("prices migrated by an unregistered user")
+	}
+
+	var migration Migration
+	for !migration.Done {
+		noError(t, json.Unmarshal(call(t, s, "admin", "MigrationContract:MigratePrices", "1"), &migration))
+	}
+
+	if compact := queryCompact(t, s, "c1"); compact.Price != 433 || compact.Legs[0].Price != 450 || compact.Escrowed != 4500 {
+		t.Fatalf("migrated compact = %+v", compact)
+	}
+
+	var p PowerTXContract
+	bid, err := p.QueryBid(s.ctx("admin"), "c1", "p1")
+	noError(t, err)
+
+	if bid.Price != 450 {
+		t.Fatalf("migrated bid price = %d", bid.Price)
+	}
+
+	// 迁移只能执行一次，避免重复换算
+	if _, err := s.invoke("admin", "MigrationContract:MigratePrices", "1"); err == nil {
+		t.Fatal("prices migrated twice")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// NegotiationEntry compact协商记录，只追加不修改
+// Action为 Commit 提交 Bid 报价 CancelBid 取消报价 Accept 接受报价 Reject 拒绝报价 Counter 拒绝后重新报价 CancelCommit 取消compact
+// PowerPlantName为报价的powerPlant，Quantity与Price为该记录涉及的电量和电价
+type NegotiationEntry struct {
+	CompactId 		string		`json:"compact_id"`
+	Round 			int			`json:"round"`
+	Action 			string		`json:"action"`
+	Actor 			string		`json:"actor"`
+	PowerPlantName  string 		`json:"power_plant_name,omitempty" metadata:"power_plant_name,optional"`
+	Quantity 		int			`json:"quantity"`
+	Price 			Price		`json:"price"`
+	TxId 			string		`json:"tx_id"`
+	EntryTime 		string		`json:"entry_time"`
+}
+
+// QueryNegotiation 获取compact的协商记录，按时间排序
+func (p *PowerTXContract) QueryNegotiation(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*NegotiationEntry, error) {
+	// 1.按compactId查询协商记录
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(NegotiationObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Negotiation Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	entries := []*NegotiationEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := new(NegotiationEntry)
+		_ = json.Unmarshal(kv.Value, entry)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// appendNegotiation 追加compact的协商记录，未指定轮次的记录为compact当前的协商轮次
+// 同一交易的记录以序号区分，每个交易只追加一次
+func (p *PowerTXContract) appendNegotiation(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact,
+	entries ...NegotiationEntry) error {
+	// 1.获取交易时间
+	var t TimeContract
+	entryTime, err := t.Now(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// 2.逐条上链
+	txId := ctx.GetStub().GetTxID()
+	for i, entry := range entries {
+		entry.CompactId = compact.CompactId
+		if entry.Round == 0 {
+			entry.Round = compact.negotiationRound()
+		}
+		entry.TxId = txId
+		entry.EntryTime = entryTime
+
+		entryAsBytes, _ := json.Marshal(entry)
+		err = putState(ctx, entryAsBytes, NegotiationObjectType, compact.CompactId, entryTime, txId, fmt.Sprintf("%03d", i))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// negotiationRound 获取compact当前的协商轮次，没有轮次的旧compact视为第1轮
+func (c *Compact) negotiationRound() int {
+	if c.Round < 1 {
+		return 1
+	}
+
+	return c.Round
+}
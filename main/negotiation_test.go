@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+)
+
+// negotiationActions 获取compact协商记录的动作序列
+func negotiationActions(t *testing.T, s *testStub, compactId string) ([]*NegotiationEntry, []string) {
+	t.Helper()
+
+	var p PowerTXContract
+	entries, err := p.QueryNegotiation(s.ctx("alice"), compactId)
+	noError(t, err)
+
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+
+	return entries, actions
+}
+
+func TestNegotiationRounds(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+	commitCompact(t, s, "c1", "alice", 100)
+
+	// 默认最多5轮协商，powerUser每次拒绝报价进入下一轮
+	for i := 0; i < 4; i++ {
+		call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.6")
+		call(t, s, "alice", "PowerTXContract:Reject", "c1", "0.52")
+	}
+
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.6")
+
+	if _, err := s.invoke("alice", "PowerTXContract:Reject", "c1", "0.52"); err == nil {
+		t.Fatal("rejected beyond the max negotiation rounds")
+	}
+
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+
+	entries, actions := negotiationActions(t, s, "c1")
+
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		counts[entry.Action]++
+
+		if entry.Action == "Counter" && (entry.Actor != "alice" || entry.Price != 520 || entry.Quantity != 100) {
+			t.Errorf("counter offer = %+v", entry)
+		}
+	}
+
+	if counts["Commit"] != 1 || counts["Bid"] != 5 || counts["Reject"] != 4 || counts["Counter"] != 4 || counts["Accept"] != 1 {
+		t.Fatalf("actions = %v", actions)
+	}
+
+	first, last := entries[0], entries[len(entries)-1]
+
+	if first.Action != "Commit" || first.Round != 1 || last.Action != "Accept" || last.Round != 5 {
+		t.Fatalf("first = %+v, last = %+v", first, last)
+	}
+}
+
+func TestNegotiationRecordsCancellations(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "plant", "PowerTXContract:PartialBid", "c1", "plant", "40", "0.45")
+	call(t, s, "plant", "PowerTXContract:CancelBid", "c1")
+	call(t, s, "alice", "PowerTXContract:CancelCommit", "c1")
+
+	entries, actions := negotiationActions(t, s, "c1")
+	want := []string{"Commit", "Bid", "CancelBid", "CancelCommit"}
+
+	if len(actions) != len(want) {
+		t.Fatalf("actions = %v, want %v", actions, want)
+	}
+
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("actions = %v, want %v", actions, want)
+		}
+	}
+
+	if bid := entries[1]; bid.PowerPlantName != "plant" || bid.Quantity != 40 || bid.Price != 450 {
+		t.Fatalf("bid entry = %+v", bid)
+	}
+}
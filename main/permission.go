@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"strings"
+	"unicode"
+)
+
+// Anyone 任何调用者，包括未注册的证书
+const Anyone string = "anyone"
+
+// CommitteeMember 委员会成员
+const CommitteeMember string = "committeeMember"
+
+// DefaultContract 调用时未指定合约名时使用的合约，与main中第一个合约一致
+const DefaultContract string = "RoleContract"
+
+// permissions 交易权限表，合约名:交易名 -> 允许调用的角色
+var permissions = map[string][]string{
+	// RoleContract
+	"RoleContract:Register":      {Anyone},
+	"RoleContract:InitAdmin":     {Anyone},
+	"RoleContract:RegisterAdmin": {ADMIN},
+	"RoleContract:BindIdentity":  {ADMIN},
+	"RoleContract:QueryUser":     {Anyone},
+	"RoleContract:QueryUserList": {Anyone},
+	"RoleContract:UserExist":     {Anyone},
+	"RoleContract:QueryIdentity": {Anyone},
+	"RoleContract:QueryCaller":   {Anyone},
+
+	// PowerTXContract
+	"PowerTXContract:Commit":                    {PowerUser},
+	"PowerTXContract:CommitProfile":             {PowerUser},
+	"PowerTXContract:Bid":                       {PowerPlant},
+	"PowerTXContract:PartialBid":                {PowerPlant},
+	"PowerTXContract:StartSealedAuction":        {PowerUser},
+	"PowerTXContract:SealBid":                   {PowerPlant},
+	"PowerTXContract:RevealBid":                 {PowerPlant},
+	"PowerTXContract:SettleSealedAuction":       {PowerUser},
+	"PowerTXContract:QuerySealedBid":            {Anyone},
+	"PowerTXContract:QuerySealedBids":           {Anyone},
+	"PowerTXContract:Deal":                      {ADMIN, CommitteeMember},
+	"PowerTXContract:CheckCompact":              {ADMIN, CommitteeMember},
+	"PowerTXContract:Reject":                    {PowerUser},
+	"PowerTXContract:Accept":                    {PowerUser},
+	"PowerTXContract:AcceptBid":                 {PowerUser},
+	"PowerTXContract:CancelCommit":              {PowerUser},
+	"PowerTXContract:CancelBid":                 {PowerPlant},
+	"PowerTXContract:QueryCompact":              {Anyone},
+	"PowerTXContract:CompactExist":              {Anyone},
+	"PowerTXContract:QueryBid":                  {Anyone},
+	"PowerTXContract:QueryBids":                 {Anyone},
+	"PowerTXContract:QueryCompactHistory":       {Anyone},
+	"PowerTXContract:QueryNegotiation":          {Anyone},
+	"PowerTXContract:QueryIntervalSettlements":  {Anyone},
+	"PowerTXContract:ReassignDealAdmin":         {ADMIN, CommitteeMember, PowerUser, PowerPlant},
+	"PowerTXContract:QueryAdminAssignments":     {Anyone},
+	"PowerTXContract:ApproveCompact":            {CommitteeMember},
+	"PowerTXContract:RevokeApproval":            {CommitteeMember},
+	"PowerTXContract:QueryCompactApprovals":     {Anyone},
+	"PowerTXContract:QueryPendingApprovals":     {Anyone},
+	"PowerTXContract:QueryCompactsByPowerUser":  {Anyone},
+	"PowerTXContract:QueryCompactsByPowerPlant": {Anyone},
+	"PowerTXContract:QueryCompactsByAdmin":      {Anyone},
+	"PowerTXContract:QueryCompactsByState":      {Anyone},
+	"PowerTXContract:QueryCompactsByStartTime":  {Anyone},
+
+	// ElectionContract
+	"ElectionContract:CreateElectionProposal": {ADMIN, PowerPlant, PowerUser},
+	"ElectionContract:VoteElectionProposal":   {ADMIN, PowerPlant, PowerUser},
+	"ElectionContract:CheckElectionProposal":  {ADMIN},
+	"ElectionContract:QueryElectionProposal":  {Anyone},
+	"ElectionContract:ElectionProposalExist":  {Anyone},
+	"ElectionContract:QueryCommittee":         {Anyone},
+
+	// BallotContract
+	"BallotContract:CreateBallotProposal": {ADMIN, PowerPlant, PowerUser},
+	"BallotContract:VoteBallotProposal":   {ADMIN, PowerPlant, PowerUser},
+	"BallotContract:CheckBallotProposal":  {ADMIN, CommitteeMember},
+	"BallotContract:QueryBallotProposal":  {Anyone},
+	"BallotContract:QueryVoterProposals":  {Anyone},
+	"BallotContract:BallotProposalExist":  {Anyone},
+
+	// VarChangeContract
+	"VarChangeContract:CreateChangeVariableProposal": {ADMIN, CommitteeMember},
+	"VarChangeContract:CheckChangeVariableProposal":  {ADMIN, CommitteeMember},
+	"VarChangeContract:AwardCredit":                  {Anyone},
+	"VarChangeContract:PerformanceCredit":            {Anyone},
+	"VarChangeContract:QueryVariable":                {Anyone},
+	"VarChangeContract:QueryVariables":               {Anyone},
+
+	// TimeContract
+	"TimeContract:CompareTime":    {Anyone},
+	"TimeContract:CompareWithNow": {Anyone},
+	"TimeContract:Now":            {Anyone},
+	"TimeContract:InPeriod":       {Anyone},
+
+	// MarketContract
+	"MarketContract:OpenMarketPeriod":  {ADMIN},
+	"MarketContract:SubmitBuyOrder":    {PowerUser},
+	"MarketContract:SubmitSellOrder":   {PowerPlant},
+	"MarketContract:CancelOrder":       {PowerUser, PowerPlant},
+	"MarketContract:ClearMarket":       {ADMIN},
+	"MarketContract:QueryMarketPeriod": {Anyone},
+	"MarketContract:QueryOrder":        {Anyone},
+	"MarketContract:QueryOrders":       {Anyone},
+
+	// AccountContract
+	"AccountContract:Deposit":        {ADMIN},
+	"AccountContract:Withdraw":       {ADMIN, PowerPlant, PowerUser},
+	"AccountContract:QueryAccount":   {Anyone},
+	"AccountContract:QueryStatement": {Anyone},
+
+	// MeterContract
+	"MeterContract:RegisterMeter":          {ADMIN},
+	"MeterContract:SubmitMeterReading":     {Anyone},
+	"MeterContract:SubmitIntervalReadings": {Anyone},
+	"MeterContract:QueryMeter":             {Anyone},
+	"MeterContract:QueryMeterReadings":     {Anyone},
+
+	// GridContract
+	"GridContract:RegisterZone":              {ADMIN},
+	"GridContract:SetTransferLimit":          {ADMIN},
+	"GridContract:AssignZone":                {ADMIN},
+	"GridContract:PruneTransferReservations": {ADMIN},
+	"GridContract:QueryZone":                 {Anyone},
+	"GridContract:QueryTransferLimit":        {Anyone},
+	"GridContract:QueryTransferReservations": {Anyone},
+	"GridContract:QueryRemainingCapacity":    {Anyone},
+
+	// CertificateContract
+	"CertificateContract:DeclareSourceType":        {PowerPlant},
+	"CertificateContract:TransferCertificate":      {Anyone},
+	"CertificateContract:RetireCertificate":        {Anyone},
+	"CertificateContract:QueryCertificate":         {Anyone},
+	"CertificateContract:QueryCertificatesByOwner": {Anyone},
+
+	// CarbonContract
+	"CarbonContract:CreateEmissionFactorProposal": {ADMIN, CommitteeMember},
+	"CarbonContract:CheckEmissionFactorProposal":  {ADMIN, CommitteeMember},
+	"CarbonContract:QueryEmissionFactor":          {Anyone},
+	"CarbonContract:QueryCarbonFootprint":         {Anyone},
+
+	// FrameworkContract
+	"FrameworkContract:ProposeFramework":         {PowerUser},
+	"FrameworkContract:SignFramework":            {PowerPlant},
+	"FrameworkContract:DealFramework":            {ADMIN},
+	"FrameworkContract:CancelFramework":          {PowerUser},
+	"FrameworkContract:GenerateDeliveries":       {ADMIN},
+	"FrameworkContract:QueryFramework":           {Anyone},
+	"FrameworkContract:QueryFrameworkDeliveries": {Anyone},
+	"FrameworkContract:QueryFrameworkFulfilment": {Anyone},
+
+	// ExpiryContract
+	"ExpiryContract:ExpireDue": {ADMIN},
+
+	// MigrationContract
+	"MigrationContract:MigrateCompositeKeys": {ADMIN},
+	"MigrationContract:MigratePrices":        {ADMIN},
+	"MigrationContract:QueryMigration":       {Anyone},
+}
+
+// CheckPermission 交易执行前根据权限表检查调用者角色，作为各合约的BeforeTransaction
+func CheckPermission(ctx contractapi.TransactionContextInterface) error {
+	// 1.解析合约名与交易名，与contractapi的解析规则一致
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	contractName := DefaultContract
+	transactionName := function
+
+	if i := strings.LastIndex(function, ":"); i != -1 {
+		contractName = function[:i]
+		transactionName = function[i+1:]
+	}
+
+	if transactionName == "" {
+		return fmt.Errorf("Blank function name passed ! ")
+	}
+
+	transactionRune := []rune(transactionName)
+	transactionRune[0] = unicode.ToUpper(transactionRune[0])
+	transactionName = string(transactionRune)
+
+	// 2.获取允许调用的角色，权限表中没有的交易一律拒绝
+	roles, ok := permissions[contractName+":"+transactionName]
+
+	if !ok {
+		return fmt.Errorf("Transaction %s:%s is not permitted ! ", contractName, transactionName)
+	}
+
+	// 3.任何人都可调用
+	if hasRole(roles, Anyone) {
+		return nil
+	}
+
+	// 4.获取调用者
+	var r RoleContract
+	caller, err := r.QueryCaller(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// 5.判断调用者角色
+	if hasRole(roles, caller.UserRole) {
+		return nil
+	}
+
+	// 6.判断调用者是否为委员会成员
+	if hasRole(roles, CommitteeMember) {
+		var e ElectionContract
+		if e.isCommitteeMember(ctx, caller.UserName) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s %s has no permission to call %s:%s ! ", caller.UserRole, caller.UserName, contractName, transactionName)
+}
+
+// hasRole 判断角色列表中是否包含role
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// checkPermission 以userName的证书身份检查调用function的权限
+func checkPermission(s *testStub, userName string, function string) error {
+	ctx := s.ctx(userName)
+	ctx.SetStub(&invokeStub{testStub: s, function: function})
+
+	return CheckPermission(ctx)
+}
+
+// setCommittee 直接设置委员会成员
+func setCommittee(t *testing.T, s *testStub, members ...string) {
+	t.Helper()
+
+	committeeAsBytes, _ := json.Marshal(Committee{Users: members})
+	noError(t, putState(s.ctx("admin"), committeeAsBytes, CommitteeObjectType))
+}
+
+// contracts main中注册的全部合约
+func contracts() []contractapi.ContractInterface {
+	return []contractapi.ContractInterface{
+		new(RoleContract),
+		new(PowerTXContract),
+		new(ElectionContract),
+		new(BallotContract),
+		new(VarChangeContract),
+		new(TimeContract),
+		new(MarketContract),
+		new(MigrationContract),
+		new(AccountContract),
+		new(MeterContract),
+		new(ExpiryContract),
+		new(GridContract),
+		new(CertificateContract),
+		new(CarbonContract),
+		new(FrameworkContract),
+	}
+}
+
+func TestPermissionsCoverTransactions(t *testing.T) {
+	base := reflect.TypeOf(new(contractapi.Contract))
+	transactions := make(map[string]bool)
+
+	for _, contract := range contracts() {
+		contractType := reflect.TypeOf(contract)
+		for i := 0; i < contractType.NumMethod(); i++ {
+			method := contractType.Method(i)
+			if _, ok := base.MethodByName(method.Name); ok {
+				continue
+			}
+
+			name := contractType.Elem().Name() + ":" + method.Name
+			transactions[name] = true
+
+			if _, ok := permissions[name]; !ok {
+				t.Errorf("%s has no permission entry", name)
+			}
+		}
+	}
+
+	for name := range permissions {
+		if !transactions[name] {
+			t.Errorf("permission entry %s has no transaction", name)
+		}
+	}
+}
+
+func TestCheckPermissionRoles(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+
+	cases := []struct {
+		userName string
+		function string
+		allowed  bool
+	}{
+		{"mallory", "RoleContract:Register", true},
+		{"mallory", "Register", true},
+		{"mallory", "PowerTXContract:QueryCompact", true},
+		{"mallory", "PowerTXContract:Commit", false},
+		{"alice", "PowerTXContract:Commit", true},
+		{"plant", "PowerTXContract:Commit", false},
+		{"plant", "PowerTXContract:bid", true},
+		{"alice", "PowerTXContract:Deal", false},
+		{"admin", "PowerTXContract:Deal", true},
+		{"admin", "PowerTXContract:Unknown", false},
+		{"admin", "", false},
+	}
+
+	for _, c := range cases {
+		err := checkPermission(s, c.userName, c.function)
+
+		if c.allowed && err != nil {
+			t.Errorf("%s calling %s: %v", c.userName, c.function, err)
+		}
+
+		if !c.allowed && err == nil {
+			t.Errorf("%s calling %s allowed", c.userName, c.function)
+		}
+	}
+}
+
+func TestCheckPermissionCommitteeMember(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice", "bob")
+	setCommittee(t, s, "alice")
+
+	noError(t, checkPermission(s, "alice", "BallotContract:CheckBallotProposal"))
+
+	if err := checkPermission(s, "bob", "BallotContract:CheckBallotProposal"); err == nil {
+		t.Fatal("non member checked a ballot proposal")
+	}
+}
+
+func TestRouterChecksPermission(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 50000)
+
+	// 未注册的证书与角色不符的用户在交易执行前被拒绝
+	for _, userName := range []string{"mallory", "plant"} {
+		if _, err := s.invoke(userName, "PowerTXContract:Commit", "c1", "alice", "100", "0.5", "2026-01-01 00:00:00", "2026-02-01 00:00:00"); err == nil {
+			t.Fatalf("%s committed through the router", userName)
+		}
+	}
+
+	call(t, s, "alice", "PowerTXContract:Commit", "c1", "alice", "100", "0.5", "2026-01-01 00:00:00", "2026-02-01 00:00:00")
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+
+	if _, err := s.invoke("alice", "PowerTXContract:Deal", "c1", "alice"); err == nil {
+		t.Fatal("power user dealt a compact")
+	}
+
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	var compact Compact
+	noError(t, json.Unmarshal(call(t, s, "mallory", "PowerTXContract:QueryCompact", "c1"), &compact))
+
+	if compact.State != "Deal" || compact.AdminName != "admin" {
+		t.Fatalf("compact = %+v", compact)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+	"strings"
 )
 
 type PowerTXContract struct {
@@ -18,9 +20,31 @@ type Compact struct {
 	PowerUserName   string		`json:"power_user_name"`
 	AdminName	    string		`json:"admin_name"`
 	Transaction 	int     	`json:"transaction"`
-	Price           float32 	`json:"price"`
+	Price           Price 		`json:"price"`
 	StartTime 		string		`json:"start_time"`
 	EndTime 		string		`json:"end_time"`
+	Legs 			[]CompactLeg	`json:"legs,omitempty" metadata:"legs,optional"`
+	Escrowed 		int64		`json:"escrowed"`
+	Round 			int			`json:"round"`
+	FrameworkId 	string		`json:"framework_id,omitempty" metadata:"framework_id,optional"`
+	Profile 		[]int		`json:"profile,omitempty" metadata:"profile,optional"`
+	Auction 		*SealedAuction	`json:"auction,omitempty" metadata:"auction,optional"`
+}
+
+// CompactLeg compact的分段，一个compact可以由多个powerPlant分别供电
+type CompactLeg struct {
+	PowerPlantName  string 		`json:"power_plant_name"`
+	Quantity 		int     	`json:"quantity"`
+	Price           Price 		`json:"price"`
+	Delivered 		int     	`json:"delivered"`
+}
+
+// GeneratedCompactPrefix 合约生成的compact编号前缀，powerUser提交的compact编号不能使用该前缀
+const GeneratedCompactPrefix string = "~"
+
+// generatedCompactId 合约生成的compact编号，source为生成compact的来源，编号不会与powerUser提交的compact冲突
+func generatedCompactId(source string, parentId string, seq string) string {
+	return fmt.Sprintf("%s%s/%s/%s", GeneratedCompactPrefix, source, parentId, seq)
 }
 
 // Commit powerUser提交compact
@@ -29,7 +53,20 @@ func (p *PowerTXContract) Commit(
 	compactId string,
 	powerUserName string,
 	transaction int,
-	price float32,
+	priceText string,
+	startTime string,
+	endTime string) (*Compact, error) {
+	return p.commit(ctx, compactId, powerUserName, transaction, nil, priceText, startTime, endTime)
+}
+
+// commit powerUser提交compact，profile为各15分钟时段的电量，为空时不按时段交割
+func (p *PowerTXContract) commit(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerUserName string,
+	transaction int,
+	profile []int,
+	priceText string,
 	startTime string,
 	endTime string) (*Compact, error) {
 	// 1.判断时间是否符合规范
@@ -38,28 +75,49 @@ func (p *PowerTXContract) Commit(
 		return nil, fmt.Errorf("End time earlier than Start time ! ")
 	}
 
-	// 2.判断compact是否存在
+	// 1.1判断交易电量与电价格式
+	if transaction <= 0 {
+		return nil, fmt.Errorf("Transaction must be positive ! ")
+	}
+
+	price, err := ParsePrice(priceText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断compact是否存在，合约生成的compact编号不能使用
+	if strings.HasPrefix(compactId, GeneratedCompactPrefix) {
+		return nil, fmt.Errorf("Compact id can not start with %s ! ", GeneratedCompactPrefix)
+	}
+
 	if p.CompactExist(ctx, compactId) {
 		return nil, fmt.Errorf("Compact existed ! ")
 	}
 
-	// 3.查看powerUser是否存在
+	// 3.查看powerUser是否存在，且为调用者本人
 	var r RoleContract
-	powerUser, err := r.QueryUser(ctx, powerUserName)
+	powerUser, err := r.checkCaller(ctx, powerUserName)
 
 	if err != nil {
 		return nil, fmt.Errorf("Query poweruser false, %s ", err.Error())
 	}
 
 	// 4.查看powerUser信用值， 若小于某个额度，则拒绝交易
-	if powerUser.UserCredit - CreditBorder < 0 {
-		return nil, fmt.Errorf("PowerUser credit less than %d ", CreditBorder)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerUser.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("PowerUser credit less than %d ", variables[CreditBorder])
 	}
 
 	// 5.结构体赋值
 	compact := Compact{
 		CompactId: compactId,
-		State: "Committing",
 		PowerPlantName: "",
 		PowerUserName: powerUserName,
 		AdminName: "",
@@ -67,12 +125,30 @@ func (p *PowerTXContract) Commit(
 		Price: price,
 		StartTime: startTime,
 		EndTime: endTime,
+		Round: 1,
+		Profile: profile,
+	}
+
+	err = p.transition(ctx, &compact, "Commit", CompactCommitting)
+
+	if err != nil {
+		return nil, err
 	}
 
-	compactAsBytes, _ := json.Marshal(compact)
+	// 5.1记录powerUser的初始报价
+	err = p.appendNegotiation(ctx, &compact, NegotiationEntry{
+		Action: "Commit",
+		Actor: powerUserName,
+		Quantity: transaction,
+		Price: price,
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
 	// 6.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	err = p.putCompact(ctx, &compact)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
@@ -81,28 +157,66 @@ func (p *PowerTXContract) Commit(
 	return &compact, nil
 }
 
-// Bid powerPlant 竞价
+// Bid powerPlant 竞价，多个powerPlant可以同时对一个compact报价，报价电量为compact剩余的全部电量
 func (p *PowerTXContract) Bid(
 	ctx contractapi.TransactionContextInterface,
 	compactId string,
 	powerPlantName string,
-	price float32) (*Compact, error) {
+	price string) (*CompactBid, error) {
+	return p.placeBid(ctx, compactId, powerPlantName, 0, price)
+}
+
+// PartialBid powerPlant 对compact的部分电量竞价
+func (p *PowerTXContract) PartialBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string,
+	quantity int,
+	price string) (*CompactBid, error) {
+	if quantity <= 0 {
+		return nil, fmt.Errorf("Quantity must be positive ! ")
+	}
+
+	return p.placeBid(ctx, compactId, powerPlantName, quantity, price)
+}
+
+// placeBid powerPlant报价，quantity为0时报价电量为compact剩余的全部电量
+func (p *PowerTXContract) placeBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string,
+	quantity int,
+	priceText string) (*CompactBid, error) {
 	// 1.判断compact是否存在
 	if !p.CompactExist(ctx, compactId) {
 		return nil, fmt.Errorf("Compact not existed ! ")
 	}
 
-	// 2.判断powerPlant是否存在
+	// 1.1判断电价格式
+	price, err := ParsePrice(priceText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断powerPlant是否存在，且为调用者本人
 	var r RoleContract
-	powerPlant, errOfPowerPlant := r.QueryUser(ctx, powerPlantName)
+	powerPlant, errOfPowerPlant := r.checkCaller(ctx, powerPlantName)
 
 	if errOfPowerPlant != nil {
 		return nil, errOfPowerPlant
 	}
 
 	// 3.查看powerPlant信用值， 若小于某个额度，则拒绝交易
-	if powerPlant.UserCredit - CreditBorder < 0 {
-		return nil, fmt.Errorf("PowerPlant credit less than %d ", CreditBorder)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerPlant.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("PowerPlant credit less than %d ", variables[CreditBorder])
 	}
 
 	// 4.获取compact交易信息
@@ -113,33 +227,96 @@ func (p *PowerTXContract) Bid(
 		return nil, fmt.Errorf(err.Error())
 	}
 
-	// 6.判断compact的状态
-	if compact.State != "Committing" {
-		return nil, fmt.Errorf("Compact state is not committing ! ")
+	// 6.判断compact的状态，竞价中的compact可以继续接受其他powerPlant的报价，拍卖中的compact只接受密封报价
+	if err := p.checkState(compact, "Bid"); err != nil {
+		return nil, err
+	}
+
+	if err := compact.checkAuction(); err != nil {
+		return nil, err
 	}
 
 	// 7.判断是否在交易时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.StartTime) || t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("It is not time to transaction ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.EndTime)
 
-	// 8.compact交易结构体赋值
-	compact.PowerPlantName = powerPlantName
-	compact.Price = price
-	compact.State = "Biding"
+	if err != nil {
+		return nil, err
+	}
 
-	compactAsBytes, _ := json.Marshal(compact)
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to transaction ! ")
+	}
 
-	// 9.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	// 8.每个powerPlant同时只能有一个竞价中的报价，已成交的powerPlant不能再次报价
+	oldBid, err := p.QueryBid(ctx, compactId, powerPlantName)
+
+	if err == nil && (oldBid.State == "Biding" || oldBid.State == "Accepted") {
+		return nil, fmt.Errorf("%s has a %s bid for %s ! ", powerPlantName, oldBid.State, compactId)
+	}
+
+	// 8.1报价电量不能超过剩余电量
+	remaining := compact.remainingTransaction()
+
+	if quantity == 0 {
+		quantity = remaining
+	}
+
+	if quantity > remaining {
+		return nil, fmt.Errorf("Quantity more than remaining transaction %d ! ", remaining)
+	}
+
+	// 9.报价结构体赋值
+	bidTime, err := t.Now(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return compact, nil
- }
+	bid := CompactBid{
+		CompactId: compactId,
+		PowerPlantName: powerPlantName,
+		Quantity: quantity,
+		Price: price,
+		State: "Biding",
+		BidTime: bidTime,
+		TxId: ctx.GetStub().GetTxID(),
+	}
+
+	// 10.报价上链，并记录协商过程
+	err = p.putBid(ctx, &bid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.appendNegotiation(ctx, compact, NegotiationEntry{
+		Action: "Bid",
+		Actor: powerPlantName,
+		PowerPlantName: powerPlantName,
+		Quantity: quantity,
+		Price: price,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 11.compact进入竞价状态
+	if compact.State != CompactBiding {
+		if err := p.transition(ctx, compact, "Bid", CompactBiding); err != nil {
+			return nil, err
+		}
+
+		err = p.putCompact(ctx, compact)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &bid, nil
+}
 
 // Deal admin参与交易，达成三方交易
 func (p *PowerTXContract) Deal(
@@ -151,17 +328,24 @@ func (p *PowerTXContract) Deal(
 		return nil, fmt.Errorf("Compact not existed ! ")
 	}
 
-	// 2.判断admin是否存在
+	// 2.判断admin是否存在，且为调用者本人
 	var r RoleContract
-	admin, errOfAdmin := r.QueryUser(ctx, adminName)
+	admin, errOfAdmin := r.checkCaller(ctx, adminName)
 
 	if errOfAdmin != nil {
 		return nil, fmt.Errorf(errOfAdmin.Error())
 	}
 
 	// 3.查看admin信用值， 若小于某个额度，则拒绝交易
-	if admin.UserCredit - CreditBorder < 0 {
-		return nil, fmt.Errorf("Admin credit less than %d ", CreditBorder)
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if admin.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("Admin credit less than %d ", variables[CreditBorder])
 	}
 
 	// 4.获取compact交易信息
@@ -173,23 +357,119 @@ func (p *PowerTXContract) Deal(
 	}
 
 	// 6.判断是否在交易时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.StartTime) || t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("It is not time to transaction ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to transaction ! ")
+	}
 
 	// 7.判断compact的状态
-	if compact.State != "Accepted" {
-		return nil, fmt.Errorf("Compact state is not Accepted ! ")
+	if err := p.checkState(compact, "Deal"); err != nil {
+		return nil, err
+	}
+
+	// 7.1有指派时只有被指派的admin可以在期限内执行Deal，没有指派时只有ADMIN可以执行Deal
+	assignment, err := p.currentAssignment(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if assignment != nil {
+		if assignment.AdminName != adminName {
+			return nil, fmt.Errorf("%s is assigned to %s, not %s ! ", assignment.AdminName, compactId, adminName)
+		}
+
+		var e ElectionContract
+		if !e.isCommitteeMember(ctx, adminName) {
+			return nil, fmt.Errorf("%s is no longer a committee member, reassign admin of %s ! ", adminName, compactId)
+		}
+
+		expired, err := t.CompareWithNow(ctx, assignment.Deadline)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if expired {
+			return nil, fmt.Errorf("Admin assignment expired at %s ! ", assignment.Deadline)
+		}
+	} else if admin.UserRole != ADMIN {
+		return nil, fmt.Errorf("Compact %s has no admin assignment ! ", compactId)
+	}
+
+	// 7.2大额compact需要委员会成员批准
+	if err := p.checkApprovals(ctx, compactId); err != nil {
+		return nil, err
+	}
+
+	// 7.3判断各分段的跨区输电是否超过剩余输电限额
+	var g GridContract
+	reservations := []*TransferReservation{}
+	for _, leg := range compact.compactLegs() {
+		route, err := g.transferRoute(ctx, leg.PowerPlantName, compact.PowerUserName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if route == nil {
+			continue
+		}
+
+		// 生成compact时已占用输电功率的分段不再占用
+		reserved, err := getState(ctx, TransferReservationObjectType, route.FromZone, route.ToZone, compactId, leg.PowerPlantName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if reserved != nil {
+			continue
+		}
+
+		capacity, err := g.transferCapacity(ctx, route, compact.StartTime, compact.EndTime, reservations)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if leg.Quantity > capacity {
+			return nil, fmt.Errorf("Transfer from %s to %s exceeds remaining capacity %d ! ", route.FromZone, route.ToZone, capacity)
+		}
+
+		reservation, err := g.reserveTransfer(ctx, route, compactId, leg.PowerPlantName, leg.Quantity, compact.StartTime, compact.EndTime)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reservations = append(reservations, reservation)
 	}
 
 	// 8.compact交易结构体赋值
 	compact.AdminName = adminName
-	compact.State = "Deal"
 
-	compactAsBytes, _ := json.Marshal(compact)
+	if err := p.transition(ctx, compact, "Deal", CompactDeal); err != nil {
+		return nil, err
+	}
+
+	// 8.1完成指派
+	if assignment != nil {
+		assignment.State = "Completed"
+
+		if err := p.putAdminAssignment(ctx, assignment); err != nil {
+			return nil, err
+		}
+	}
+
 	// 9.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	err = p.putCompact(ctx, compact)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
@@ -198,11 +478,10 @@ func (p *PowerTXContract) Deal(
 	return compact, nil
 }
 
+// CheckCompact admin在交割结束后结算compact，实际用电量与供电量取自已验证的电表读数
 func (p *PowerTXContract) CheckCompact(
 	ctx contractapi.TransactionContextInterface,
-	compactId string,
-	powerUsed int,
-	powerPlant int) (*Compact, error) {
+	compactId string) (*Compact, error) {
 	// 1.判断compact是否存在
 	if !p.CompactExist(ctx, compactId) {
 		return nil, fmt.Errorf("Compact not existed ! ")
@@ -216,42 +495,148 @@ func (p *PowerTXContract) CheckCompact(
 		return nil, fmt.Errorf(err.Error())
 	}
 
+	// 3.1判断调用者是否为compact的admin
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, compact.AdminName); err != nil {
+		return nil, err
+	}
+
 	// 4.判断compact的状态
-	if compact.State != "Deal" {
-		return nil, fmt.Errorf("Compact state is not Accepted ! ")
+	if err := p.checkState(compact, "CheckCompact"); err != nil {
+		return nil, err
 	}
 
 	// 5.判断交易是否到达预期时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("The compact is not end! ")
-	//}
+	var t TimeContract
+	ended, err := t.CompareWithNow(ctx, compact.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ended {
+		return nil, fmt.Errorf("The compact is not end! ")
+	}
+
+	// 5.1各方的最终读数必须覆盖到EndTime，有分时曲线时分时读数必须覆盖全部时段
+	// 超过MeterGraceHours后缺少读数的一方按已上报电量结算
+	legs := compact.compactLegs()
+
+	var m MeterContract
+	finals, err := m.finalReadingTimes(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	intervals, err := m.meteredIntervals(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
 
-	// 6.检查交易情况
-	// 6.1更新信用值和交易额度
-	var r RoleContract
 	var v VarChangeContract
-	if compact.Transaction - powerUsed < 0 {
-		_ = r.ChangeCredit(ctx, compact.PowerUserName, v.AwardCredit(compact.Transaction))
-	} else {
-		_ = r.ChangeCredit(ctx, compact.PowerUserName, v.AwardCredit(powerUsed - compact.Transaction))
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	parties := []string{compact.PowerUserName}
+	for _, leg := range legs {
+		parties = append(parties, leg.PowerPlantName)
+	}
+
+	for _, party := range parties {
+		if len(compact.Profile) > 0 {
+			if len(intervals[party]) == len(compact.Profile) {
+				continue
+			}
+		} else if final, ok := finals[party]; ok && !t.CompareTime(final, compact.EndTime) {
+			continue
+		}
+
+		graced, err := t.CompareWithNow(ctx, t.addHours(compact.EndTime, variables[MeterGraceHours]))
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !graced {
+			return nil, fmt.Errorf("%s has no final meter reading for %s ! ", party, compactId)
+		}
+
+		break
+	}
+
+	// 6.检查交易情况，按电表读数汇总各方电量
+	energy, err := m.meteredEnergy(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	powerUsed := energy[compact.PowerUserName]
+
+	// 6.1按用电偏差更新powerUser信用值和交易额度，有分时曲线时逐时段比较
+	userAward, err := p.performanceCredit(ctx, compact, compact.PowerUserName, compact.Transaction, powerUsed, intervals, false, variables)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if compact.Transaction - powerPlant < 0 {
-		_ = r.ChangeCredit(ctx, compact.PowerPlantName, v.AwardCredit(compact.Transaction))
-	} else {
-		_ = r.ChangeCredit(ctx, compact.PowerPlantName, v.AwardCredit(powerPlant - compact.Transaction))
+	_ = r.changeCreditAndPower(ctx, compact.PowerUserName, userAward, powerUsed)
+
+	// 6.2逐个分段按供电偏差更新powerPlant信用值和交易额度
+	powerPlantTotal := 0
+	for i, leg := range legs {
+		powerPlant := energy[leg.PowerPlantName]
+
+		plantAward, err := p.performanceCredit(ctx, compact, leg.PowerPlantName, leg.Quantity, powerPlant, intervals, true, variables)
+
+		if err != nil {
+			return nil, err
+		}
+
+		_ = r.changeCreditAndPower(ctx, leg.PowerPlantName, plantAward, powerPlant)
+
+		legs[i].Delivered = powerPlant
+		powerPlantTotal += powerPlant
 	}
 
-	_ = r.ChangePower(ctx, compact.PowerUserName,powerUsed)
-	_ = r.ChangePower(ctx, compact.PowerPlantName,powerPlant)
-	_ = r.ChangePower(ctx, compact.AdminName,powerPlant + powerUsed)
+	// 6.3更新admin交易额度
+	_ = r.changePower(ctx, compact.AdminName, powerPlantTotal + powerUsed)
+
+	compact.Legs = legs
+
+	// 6.4按实际供电量把托管款支付给各分段powerPlant，扣除admin手续费，其余退回powerUser
+	var a AccountContract
+	err = a.settleCompact(ctx, compact)
 
-	compact.State = "Done"
-	compactAsBytes, _ := json.Marshal(compact)
+	if err != nil {
+		return nil, err
+	}
+
+	compact.Escrowed = 0
+
+	// 6.5为可再生能源分段发放绿色电力证书
+	var c CertificateContract
+	if _, err := c.issueCertificates(ctx, compact); err != nil {
+		return nil, err
+	}
+
+	// 6.6按各分段实际供电量记录碳排放
+	var carbon CarbonContract
+	if err := carbon.recordEmissions(ctx, compact); err != nil {
+		return nil, err
+	}
+
+	if err := p.transition(ctx, compact, "CheckCompact", CompactDone); err != nil {
+		return nil, err
+	}
 
 	// 7.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	err = p.putCompact(ctx, compact)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
@@ -260,17 +645,23 @@ func (p *PowerTXContract) CheckCompact(
 	return compact, nil
 }
 
-// Reject powerUser 拒绝报价，并重新提出报价
+// Reject powerUser 拒绝全部报价，并重新提出报价
 func (p *PowerTXContract) Reject(
 	ctx contractapi.TransactionContextInterface,
 	compactId string,
-	newPrice float32) (*Compact, error) {
-	// 0.判断用户
+	newPriceText string) (*Compact, error) {
 	// 1.判断compact是否存在
 	if !p.CompactExist(ctx, compactId) {
 		return nil, fmt.Errorf("Compact not existed ! ")
 	}
 
+	// 1.1判断电价格式
+	newPrice, err := ParsePrice(newPriceText)
+
+	if err != nil {
+		return nil, err
+	}
+
 	// 2.获取compact交易信息
 	compact, err := p.QueryCompact(ctx, compactId)
 
@@ -279,25 +670,97 @@ func (p *PowerTXContract) Reject(
 		return nil, fmt.Errorf(err.Error())
 	}
 
+	// 3.1判断调用者是否为compact的powerUser
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, compact.PowerUserName); err != nil {
+		return nil, err
+	}
+
+	// 3.2拍卖中的compact出清前不能拒绝报价
+	if err := compact.checkAuction(); err != nil {
+		return nil, err
+	}
+
 	// 4.判断是否在交易时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.StartTime) || t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("It is not time to transaction ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to transaction ! ")
+	}
 
 	// 5.判断compact的状态
-	if compact.State != "Biding" {
-		return nil, fmt.Errorf("Compact state is not biding ! ")
+	if err := p.checkState(compact, "Reject"); err != nil {
+		return nil, err
 	}
 
-	// 6.compact交易结构体赋值
+	// 5.1判断是否超过最多协商轮次
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.negotiationRound() >= variables[MaxNegotiationRounds] {
+		return nil, fmt.Errorf("Compact reached the max negotiation rounds %d ! ", variables[MaxNegotiationRounds])
+	}
+
+	// 6.拒绝全部竞价中的报价
+	bids, err := p.queryActiveBids(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bid := range bids {
+		bid.State = "Rejected"
+
+		if err := p.putBid(ctx, bid); err != nil {
+			return nil, err
+		}
+	}
+
+	// 6.1记录被拒绝的报价，属于当前轮次
+	entries := make([]NegotiationEntry, 0, len(bids)+1)
+	for _, bid := range bids {
+		entries = append(entries, NegotiationEntry{
+			Round: compact.negotiationRound(),
+			Action: "Reject",
+			Actor: compact.PowerUserName,
+			PowerPlantName: bid.PowerPlantName,
+			Quantity: bid.Quantity,
+			Price: bid.Price,
+		})
+	}
+
+	// 7.compact交易结构体赋值，进入下一轮协商
 	compact.PowerPlantName = ""
 	compact.Price = newPrice
-	compact.State = "Committing"
+	compact.Round = compact.negotiationRound() + 1
 
-	compactAsBytes, _ := json.Marshal(compact)
-	// 7.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	if err := p.transition(ctx, compact, "Reject", CompactCommitting); err != nil {
+		return nil, err
+	}
+
+	// 7.1记录powerUser的新报价，属于下一轮
+	entries = append(entries, NegotiationEntry{
+		Action: "Counter",
+		Actor: compact.PowerUserName,
+		Quantity: compact.remainingTransaction(),
+		Price: newPrice,
+	})
+
+	if err := p.appendNegotiation(ctx, compact, entries...); err != nil {
+		return nil, err
+	}
+
+	// 8.上链
+	err = p.putCompact(ctx, compact)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
@@ -306,8 +769,74 @@ func (p *PowerTXContract) Reject(
 	return compact, nil
 }
 
-// Accept powerUser接受报价
+// Accept powerUser按价格从低到高接受报价直到覆盖全部电量，其余报价自动拒绝
 func (p *PowerTXContract) Accept(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (*Compact, error) {
+	// 1.判断compact是否可以接受报价
+	compact, err := p.checkAccept(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.获取竞价中的报价
+	bids, err := p.queryActiveBids(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bids) == 0 {
+		return nil, fmt.Errorf("Compact has no biding bid ! ")
+	}
+
+	// 3.按价格从低到高接受报价，价格相同时先接受最早的报价
+	selectedBids := make([]*CompactBid, len(bids))
+	copy(selectedBids, bids)
+
+	sort.SliceStable(selectedBids, func(i, j int) bool {
+		if selectedBids[i].Price != selectedBids[j].Price {
+			return selectedBids[i].Price < selectedBids[j].Price
+		}
+
+		return selectedBids[i].BidTime < selectedBids[j].BidTime
+	})
+
+	return p.acceptBids(ctx, compact, bids, selectedBids)
+}
+
+// AcceptBid powerUser接受指定powerPlant的报价，覆盖全部电量后其余报价自动拒绝
+func (p *PowerTXContract) AcceptBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string) (*Compact, error) {
+	// 1.判断compact是否可以接受报价
+	compact, err := p.checkAccept(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.获取竞价中的报价
+	bids, err := p.queryActiveBids(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.接受指定powerPlant的报价
+	for _, bid := range bids {
+		if bid.PowerPlantName == powerPlantName {
+			return p.acceptBids(ctx, compact, bids, []*CompactBid{bid})
+		}
+	}
+
+	return nil, fmt.Errorf("%s has no biding bid for %s ! ", powerPlantName, compactId)
+}
+
+// checkAccept 判断调用者能否接受compact的报价
+func (p *PowerTXContract) checkAccept(
 	ctx contractapi.TransactionContextInterface,
 	compactId string) (*Compact, error) {
 	// 1.判断compact是否存在
@@ -323,26 +852,32 @@ func (p *PowerTXContract) Accept(
 		return nil, fmt.Errorf(err.Error())
 	}
 
-	// 4.判断是否在交易时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.StartTime) || t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("It is not time to transaction ! ")
-	//}
-
-	// 5.判断compact的状态
-	if compact.State != "Biding" {
-		return nil, fmt.Errorf("Compact state is not biding ! ")
+	// 3.1判断调用者是否为compact的powerUser
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, compact.PowerUserName); err != nil {
+		return nil, err
 	}
 
-	// 6.compact交易结构体赋值
-	compact.State = "Accepted"
+	// 3.2拍卖中的compact出清前不能接受报价
+	if err := compact.checkAuction(); err != nil {
+		return nil, err
+	}
 
-	compactAsBytes, _ := json.Marshal(compact)
-	// 7.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	// 4.判断是否在交易时间
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.EndTime)
 
 	if err != nil {
-		return nil, fmt.Errorf(err.Error())
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to transaction ! ")
+	}
+
+	// 5.判断compact的状态
+	if err := p.checkState(compact, "Accept"); err != nil {
+		return nil, err
 	}
 
 	return compact, nil
@@ -365,23 +900,58 @@ func (p *PowerTXContract) CancelCommit(
 		return nil, fmt.Errorf(err.Error())
 	}
 
+	// 3.1判断调用者是否为compact的powerUser
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, compact.PowerUserName); err != nil {
+		return nil, err
+	}
+
 	// 4.判断是否在交易时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.StartTime) || t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("It is not time to transaction ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.EndTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to transaction ! ")
+	}
 
 	// 5.判断compact的状态
-	if compact.State != "Committing" {
-		return nil, fmt.Errorf("Compact state is not committing ! ")
+	if err := p.checkState(compact, "CancelCommit"); err != nil {
+		return nil, err
+	}
+
+	// 5.1已有分段成交的compact，托管款退回powerUser
+	var a AccountContract
+	err = a.refundCompact(ctx, compact)
+
+	if err != nil {
+		return nil, err
 	}
 
 	// 6.compact交易结构体赋值
-	compact.State = "CancelCommit"
+	compact.Escrowed = 0
+
+	if err := p.transition(ctx, compact, "CancelCommit", CompactCancelCommit); err != nil {
+		return nil, err
+	}
+
+	// 6.1记录协商过程
+	err = p.appendNegotiation(ctx, compact, NegotiationEntry{
+		Action: "CancelCommit",
+		Actor: compact.PowerUserName,
+		Quantity: compact.remainingTransaction(),
+		Price: compact.Price,
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
-	compactAsBytes, _ := json.Marshal(compact)
 	// 7.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	err = p.putCompact(ctx, compact)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
@@ -407,25 +977,80 @@ func (p *PowerTXContract) CancelBid(
 		return nil, fmt.Errorf(err.Error())
 	}
 
+	// 3.1获取调用者，调用者只能取消自己的报价
+	var r RoleContract
+	caller, err := r.QueryCaller(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
 	// 4.判断是否在交易时间
-	//var t TimeContract
-	//if !t.CompareWithNow(compact.StartTime) || t.CompareWithNow(compact.EndTime) {
-	//	return nil, fmt.Errorf("It is not time to transaction ! ")
-	//}
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.EndTime)
 
-	// 5.判断compact的状态
-	if compact.State != "Biding" {
-		return nil, fmt.Errorf("Compact state is not biding ! ")
+	if err != nil {
+		return nil, err
 	}
 
-	// 6.compact交易结构体赋值
-	compact.PowerPlantName = ""
-	compact.State = "Committing"
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to transaction ! ")
+	}
+
+	// 5.判断compact的状态，拍卖中的compact出清前不能取消报价
+	if err := p.checkState(compact, "CancelBid"); err != nil {
+		return nil, err
+	}
+
+	if err := compact.checkAuction(); err != nil {
+		return nil, err
+	}
 
-	compactAsBytes, _ := json.Marshal(compact)
+	// 6.取消调用者的报价
+	bids, err := p.queryActiveBids(ctx, compactId)
 
-	// 7.上链
-	err = ctx.GetStub().PutState(compactId, compactAsBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	canceled := []NegotiationEntry{}
+	for _, bid := range bids {
+		if bid.PowerPlantName == caller.UserName {
+			bid.State = "Canceled"
+			canceled = append(canceled, NegotiationEntry{
+				Action: "CancelBid",
+				Actor: caller.UserName,
+				PowerPlantName: bid.PowerPlantName,
+				Quantity: bid.Quantity,
+				Price: bid.Price,
+			})
+
+			if err := p.putBid(ctx, bid); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(canceled) == 0 {
+		return nil, fmt.Errorf("%s has no biding bid for %s ! ", caller.UserName, compactId)
+	}
+
+	// 6.1记录协商过程
+	if err := p.appendNegotiation(ctx, compact, canceled...); err != nil {
+		return nil, err
+	}
+
+	// 7.没有其他竞价中的报价，compact回到提交状态
+	if len(bids) > 1 {
+		return compact, nil
+	}
+
+	if err := p.transition(ctx, compact, "CancelBid", CompactCommitting); err != nil {
+		return nil, err
+	}
+
+	// 8.上链
+	err = p.putCompact(ctx, compact)
 
 	if err != nil {
 		return nil, fmt.Errorf(err.Error())
@@ -439,7 +1064,7 @@ func (p *PowerTXContract) QueryCompact(
 	ctx contractapi.TransactionContextInterface,
 	compactId string) (*Compact, error) {
 	// 1.获取compact交易信息
-	compactAsBytes, errOfCompact := ctx.GetStub().GetState(compactId)
+	compactAsBytes, errOfCompact := getState(ctx, CompactObjectType, compactId)
 
 	// 1.1判断获取交易是否错误
 	if errOfCompact != nil {
@@ -463,7 +1088,7 @@ func (p *PowerTXContract) CompactExist(
 	ctx contractapi.TransactionContextInterface,
 	compactId string) bool {
 	// 1.获取compact
-	compactAsBytes, _ := ctx.GetStub().GetState(compactId)
+	compactAsBytes, _ := getState(ctx, CompactObjectType, compactId)
 
 	// 2.如果compact不存在， 返回false
 	if compactAsBytes == nil {
@@ -472,4 +1097,27 @@ func (p *PowerTXContract) CompactExist(
 
 	// 3. 如果compact存在，返回true
 	return true
-}
\ No newline at end of file
+}
+
+// remainingTransaction 获取compact尚未被分段覆盖的电量
+func (c *Compact) remainingTransaction() int {
+	remaining := c.Transaction
+	for _, leg := range c.Legs {
+		remaining -= leg.Quantity
+	}
+
+	return remaining
+}
+
+// compactLegs 获取compact的分段，没有分段的旧compact视为由PowerPlantName供应全部电量
+func (c *Compact) compactLegs() []CompactLeg {
+	if len(c.Legs) > 0 {
+		return c.Legs
+	}
+
+	return []CompactLeg{{
+		PowerPlantName: c.PowerPlantName,
+		Quantity: c.Transaction,
+		Price: c.Price,
+	}}
+}
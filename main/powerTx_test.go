@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPartialFills(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1", "p2", "p3")
+	deposit(t, s, "alice", 100000)
+	commitCompact(t, s, "c1", "alice", 100)
+
+	var p PowerTXContract
+	noError(t, errOf(p.PartialBid(s.ctx("p1"), "c1", "p1", 60, "0.4")))
+	noError(t, errOf(p.PartialBid(s.ctx("p2"), "c1", "p2", 30, "0.45")))
+	noError(t, errOf(p.PartialBid(s.ctx("p3"), "c1", "p3", 50, "0.6")))
+
+	// 部分成交后compact继续竞价
+	compact, err := p.AcceptBid(s.ctx("alice"), "c1", "p1")
+	noError(t, err)
+
+	if compact.State != "Biding" || compact.remainingTransaction() != 40 {
+		t.Fatalf("partially filled compact = %+v", compact)
+	}
+
+	// 剩余电量按价格从低到高成交，最后一段只成交剩余部分
+	compact, err = p.Accept(s.ctx("alice"), "c1")
+	noError(t, err)
+
+	want := []CompactLeg{
+		{PowerPlantName: "p1", Quantity: 60, Price: 400},
+		{PowerPlantName: "p2", Quantity: 30, Price: 450},
+		{PowerPlantName: "p3", Quantity: 10, Price: 600},
+	}
+
+	if compact.State != "Accepted" || len(compact.Legs) != len(want) {
+		t.Fatalf("filled compact = %+v", compact)
+	}
+
+	for i, leg := range want {
+		if compact.Legs[i].PowerPlantName != leg.PowerPlantName || compact.Legs[i].Quantity != leg.Quantity || compact.Legs[i].Price != leg.Price {
+			t.Errorf("leg %d = %+v, want %+v", i, compact.Legs[i], leg)
+		}
+	}
+
+	// 成交价为各分段的加权平均价
+	if compact.Price != 435 {
+		t.Fatalf("price = %s, want 0.435", compact.Price)
+	}
+}
+
+func TestPartialBidRejectsBadQuantities(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+	commitCompact(t, s, "c1", "alice", 100)
+
+	if _, err := s.invoke("p1", "PowerTXContract:PartialBid", "c1", "p1", "0", "0.4"); err == nil {
+		t.Fatal("bid for zero quantity")
+	}
+
+	if _, err := s.invoke("p1", "PowerTXContract:PartialBid", "c1", "p1", "120", "0.4"); err == nil {
+		t.Fatal("bid beyond the compact transaction")
+	}
+}
+
+func TestCommitRejectsNonPositiveTransaction(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+
+	for _, transaction := range []string{"0", "-100"} {
+		if _, err := s.invoke("alice", "PowerTXContract:Commit", "c1", "alice", transaction, "0.5",
+			"2026-01-01 00:00:00", "2026-02-01 00:00:00"); err == nil {
+			t.Errorf("committed transaction %s", transaction)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Price 电价，定点数，单位为厘/kWh(0.001元/kWh)，JSON中为整数
+type Price int64
+
+// PriceScale 1元/kWh 对应的Price
+const PriceScale Price = 1000
+
+// ParsePrice 解析以元/kWh为单位的十进制电价字符串，如 "0.435"，最多3位小数，不能为负数
+func ParsePrice(s string) (Price, error) {
+	s = strings.TrimSpace(s)
+	intPart, fracPart := s, ""
+
+	if i := strings.Index(s, "."); i != -1 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+
+	if intPart == "" || len(fracPart) > 3 || strings.HasPrefix(intPart, "-") || strings.HasPrefix(intPart, "+") {
+		return 0, fmt.Errorf("Price %s is not right, it must be a non-negative decimal with at most 3 decimal places ! ", s)
+	}
+
+	yuan, err := strconv.ParseInt(intPart, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("Price %s is not right. %s ", s, err.Error())
+	}
+
+	// 换算为厘后不能超出int64范围
+	if yuan > (math.MaxInt64-999)/int64(PriceScale) {
+		return 0, fmt.Errorf("Price %s is too large ! ", s)
+	}
+
+	milli := int64(0)
+	if fracPart != "" {
+		milli, err = strconv.ParseInt(fracPart+strings.Repeat("0", 3-len(fracPart)), 10, 64)
+
+		if err != nil || strings.HasPrefix(fracPart, "-") || strings.HasPrefix(fracPart, "+") {
+			return 0, fmt.Errorf("Price %s is not right ! ", s)
+		}
+	}
+
+	return Price(yuan)*PriceScale + Price(milli), nil
+}
+
+// String 以元/kWh为单位格式化电价，保留3位小数
+func (p Price) String() string {
+	sign := ""
+	if p < 0 {
+		sign, p = "-", -p
+	}
+
+	return fmt.Sprintf("%s%d.%03d", sign, p/PriceScale, p%PriceScale)
+}
+
+// Amount 计算电量quantity按电价p的金额，单位为厘，金额超出int64范围时返回错误
+func (p Price) Amount(quantity int) (int64, error) {
+	if p < 0 || quantity < 0 {
+		return 0, fmt.Errorf("Price and quantity can not be negative ! ")
+	}
+
+	if quantity > 0 && int64(p) > math.MaxInt64/int64(quantity) {
+		return 0, fmt.Errorf("Amount of %d kWh at %s is too large ! ", quantity, p.String())
+	}
+
+	return int64(p) * int64(quantity), nil
+}
+
+// addAmount 金额相加，结果超出int64范围时返回错误
+func addAmount(amount int64, change int64) (int64, error) {
+	if (change > 0 && amount > math.MaxInt64-change) || (change < 0 && amount < math.MinInt64-change) {
+		return 0, fmt.Errorf("Amount is too large ! ")
+	}
+
+	return amount + change, nil
+}
+
+// averagePrice 计算总金额amount对应电量quantity的平均电价，四舍五入到厘
+func averagePrice(amount int64, quantity int) Price {
+	if quantity <= 0 {
+		return 0
+	}
+
+	// 先整除再按余数进位，避免amount*2超出int64范围
+	average, remainder := amount/int64(quantity), amount%int64(quantity)
+	if remainder*2 >= int64(quantity) {
+		average++
+	}
+
+	return Price(average)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParsePrice(t *testing.T) {
+	valid := map[string]Price{
+		"0.435": 435,
+		"1":     1000,
+		"1.5":   1500,
+		"0.001": 1,
+		"12.30": 12300,
+		"0":     0,
+		" 0.5 ": 500,
+
+		"9223372036854774.999": 9223372036854774999,
+	}
+
+	for text, want := range valid {
+		price, err := ParsePrice(text)
+		noError(t, err)
+
+		if price != want {
+			t.Errorf("ParsePrice(%q) = %d, want %d", text, price, want)
+		}
+	}
+
+	for _, text := range []string{"-1", "+1", "0.0001", "abc", "", "1.-2", "1.+2", ".5", "1e3", "9223372036854775", "9223372036854775807"} {
+		if _, err := ParsePrice(text); err == nil {
+			t.Errorf("ParsePrice(%q) accepted", text)
+		}
+	}
+}
+
+func TestPriceString(t *testing.T) {
+	cases := map[Price]string{
+		435:  "0.435",
+		1000: "1.000",
+		1:    "0.001",
+		-500: "-0.500",
+	}
+
+	for price, want := range cases {
+		if got := price.String(); got != want {
+			t.Errorf("Price(%d).String() = %s, want %s", int64(price), got, want)
+		}
+	}
+}
+
+func TestAveragePrice(t *testing.T) {
+	if price := averagePrice(43500, 100); price != 435 {
+		t.Fatalf("averagePrice(43500, 100) = %d", price)
+	}
+
+	// 四舍五入到厘
+	if price := averagePrice(3, 2); price != 2 {
+		t.Fatalf("averagePrice(3, 2) = %d", price)
+	}
+
+	if price := averagePrice(100, 0); price != 0 {
+		t.Fatalf("averagePrice(100, 0) = %d", price)
+	}
+
+	if price := averagePrice(math.MaxInt64, 2); price != math.MaxInt64/2+1 {
+		t.Fatalf("averagePrice(MaxInt64, 2) = %d", price)
+	}
+}
+
+func TestPriceAmount(t *testing.T) {
+	amount, err := Price(435).Amount(100)
+	noError(t, err)
+
+	if amount != 43500 {
+		t.Fatalf("amount = %d", amount)
+	}
+
+	if _, err := Price(math.MaxInt64 / 2).Amount(3); err == nil {
+		t.Fatal("overflowing amount accepted")
+	}
+
+	if _, err := addAmount(math.MaxInt64, 1); err == nil {
+		t.Fatal("overflowing sum accepted")
+	}
+
+	if _, err := addAmount(math.MinInt64, -1); err == nil {
+		t.Fatal("underflowing sum accepted")
+	}
+}
+
+func TestAcceptRejectsOverflowingEscrow(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+
+	// 最高电价乘以电量超出int64范围，托管金额不能回绕为负数
+	call(t, s, "alice", "PowerTXContract:Commit", "c1", "alice", "100", "9223372036854774",
+		"2026-01-01 00:00:00", "2026-02-01 00:00:00")
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "9223372036854774")
+
+	if _, err := s.invoke("alice", "PowerTXContract:Accept", "c1"); err == nil {
+		t.Fatal("accepted an overflowing escrow")
+	}
+
+	if account := queryAccount(t, s, "alice"); account.Balance != 1000000 || account.Escrowed != 0 {
+		t.Fatalf("alice account = %+v", account)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"time"
+)
+
+// ProfileSlotMinutes compact分时曲线每个时段的分钟数
+const ProfileSlotMinutes int = 15
+
+// IntervalSettlement compact结算时一方按分时曲线逐时段比较的结果，电量单位为kWh
+// Deviations为各时段实际电量减去合同电量，DeviationRate为偏差电量合计占合同电量的比例，单位为千分之一
+type IntervalSettlement struct {
+	CompactId 		string		`json:"compact_id"`
+	UserName 		string		`json:"user_name"`
+	Contracted 		[]int		`json:"contracted"`
+	Metered 		[]int		`json:"metered"`
+	Deviations 		[]int		`json:"deviations"`
+	Under 			int			`json:"under"`
+	Over 			int			`json:"over"`
+	DeviationRate 	int			`json:"deviation_rate"`
+	CreditChange 	int			`json:"credit_change"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// CommitProfile powerUser按15分钟分时曲线提交compact，交易电量为各时段电量之和
+func (p *PowerTXContract) CommitProfile(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerUserName string,
+	profile []int,
+	priceText string,
+	startTime string,
+	endTime string) (*Compact, error) {
+	// 1.判断时段数量与交割时间一致
+	slots, err := profileSlots(startTime, endTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profile) != slots {
+		return nil, fmt.Errorf("Profile must have %d slots of %d minutes ! ", slots, ProfileSlotMinutes)
+	}
+
+	// 2.计算交易电量
+	transaction := 0
+	for _, quantity := range profile {
+		if quantity < 0 {
+			return nil, fmt.Errorf("Quantity can not be negative ! ")
+		}
+
+		transaction += quantity
+	}
+
+	if transaction <= 0 {
+		return nil, fmt.Errorf("Profile quantity must be positive ! ")
+	}
+
+	return p.commit(ctx, compactId, powerUserName, transaction, profile, priceText, startTime, endTime)
+}
+
+// QueryIntervalSettlements 获取compact按分时曲线结算的结果
+func (p *PowerTXContract) QueryIntervalSettlements(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*IntervalSettlement, error) {
+	// 1.按compactId查询结算结果
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(IntervalSettlementObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query IntervalSettlement Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	settlements := []*IntervalSettlement{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		settlement := new(IntervalSettlement)
+		_ = json.Unmarshal(kv.Value, settlement)
+		settlements = append(settlements, settlement)
+	}
+
+	return settlements, nil
+}
+
+// performanceCredit 按履约偏差计算一方的信用值变化
+// compact有分时曲线时逐时段比较并记录结果，没有分时读数的时段电量为0，否则按总电量比较
+// variables为交易开始时读取的治理参数
+func (p *PowerTXContract) performanceCredit(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact,
+	userName string,
+	contracted int,
+	actual int,
+	metered map[string][]int,
+	supplier bool,
+	variables map[string]int) (int, error) {
+	// 1.没有分时曲线时按总电量计算
+	var v VarChangeContract
+	intervals := metered[userName]
+
+	if len(compact.Profile) == 0 {
+		return v.totalCredit(variables, contracted, actual, supplier)
+	}
+
+	// 2.按合同电量占比分摊各时段电量，逐时段计算偏差
+	settlement := IntervalSettlement{
+		CompactId: compact.CompactId,
+		UserName: userName,
+		Contracted: compact.profileShare(contracted),
+		Metered: make([]int, len(compact.Profile)),
+		Deviations: make([]int, len(compact.Profile)),
+		TxId: ctx.GetStub().GetTxID(),
+	}
+
+	for i := range settlement.Contracted {
+		if i < len(intervals) {
+			settlement.Metered[i] = intervals[i]
+		}
+
+		deviation := settlement.Metered[i] - settlement.Contracted[i]
+		settlement.Deviations[i] = deviation
+
+		if deviation < 0 {
+			settlement.Under -= deviation
+		} else {
+			settlement.Over += deviation
+		}
+	}
+
+	if contracted > 0 {
+		settlement.DeviationRate = (settlement.Under + settlement.Over) * 1000 / contracted
+	}
+
+	// 3.按偏差电量合计计算信用值变化
+	credit, err := v.deviationCredit(variables, contracted, settlement.Under, settlement.Over, supplier)
+
+	if err != nil {
+		return 0, err
+	}
+
+	settlement.CreditChange = credit
+
+	// 4.结算结果上链
+	settlementAsBytes, _ := json.Marshal(settlement)
+	err = putState(ctx, settlementAsBytes, IntervalSettlementObjectType, compact.CompactId, userName)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return credit, nil
+}
+
+// profileShare 按分时曲线把quantity分摊到各时段，按累计电量取整，各时段之和等于quantity
+func (c *Compact) profileShare(quantity int) []int {
+	shares := make([]int, len(c.Profile))
+	if c.Transaction <= 0 {
+		return shares
+	}
+
+	cumulative, allocated := 0, 0
+	for i, slot := range c.Profile {
+		cumulative += slot
+		target := int(int64(cumulative) * int64(quantity) / int64(c.Transaction))
+		shares[i] = target - allocated
+		allocated = target
+	}
+
+	return shares
+}
+
+// profileSlots 计算startTime 与endTime 之间的分时时段数量，交割时间必须是整数个时段
+func profileSlots(startTime string, endTime string) (int, error) {
+	startObj, _ := time.ParseInLocation(TimeLayout, startTime, TimeLocation)
+	endObj, _ := time.ParseInLocation(TimeLayout, endTime, TimeLocation)
+	slot := time.Duration(ProfileSlotMinutes) * time.Minute
+
+	if !endObj.After(startObj) || endObj.Sub(startObj) % slot != 0 {
+		return 0, fmt.Errorf("Compact period must be whole %d minute slots ! ", ProfileSlotMinutes)
+	}
+
+	return int(endObj.Sub(startObj) / slot), nil
+}
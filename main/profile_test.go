@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// submitIntervals 以电表私钥签名，由网关提交分时读数
+func submitIntervals(s *testStub, key ed25519.PrivateKey, meterId string, compactId string, intervals []int, nonce int64) error {
+	readingTime := s.timeNow()
+	signature := ed25519.Sign(key, intervalMessage(meterId, compactId, intervals, nonce, readingTime))
+	intervalsAsBytes, _ := json.Marshal(intervals)
+
+	_, err := s.invoke("gateway", "MeterContract:SubmitIntervalReadings", meterId, compactId, string(intervalsAsBytes),
+		fmt.Sprint(nonce), readingTime, base64.StdEncoding.EncodeToString(signature))
+
+	return err
+}
+
+func TestProfileSettlement(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+
+	// 一小时的交割时段分为4个15分钟时段
+	if _, err := s.invoke("alice", "PowerTXContract:CommitProfile", "c1", "alice", "[10,20,30]", "0.5",
+		"2026-01-01 07:45:00", "2026-01-01 08:45:00"); err == nil {
+		t.Fatal("profile with missing slots")
+	}
+
+	compact := new(Compact)
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:CommitProfile", "c1", "alice", "[10,20,30,40]", "0.5",
+		"2026-01-01 07:45:00", "2026-01-01 08:45:00"), compact))
+
+	if compact.Transaction != 100 {
+		t.Fatalf("transaction = %d, want 100", compact.Transaction)
+	}
+
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	aliceKey := registerMeter(t, s, "meter-alice", "alice")
+	plantKey := registerMeter(t, s, "meter-p1", "p1")
+
+	// 只能提交已结束时段的读数
+	s.advance(20 * time.Minute)
+
+	if err := submitIntervals(s, aliceKey, "meter-alice", "c1", []int{10, 20, 30}, 1); err == nil {
+		t.Fatal("reading for a slot not yet ended")
+	}
+
+	noError(t, submitIntervals(s, aliceKey, "meter-alice", "c1", []int{10, 20}, 1))
+
+	s.advance(time.Hour)
+	noError(t, submitIntervals(s, aliceKey, "meter-alice", "c1", []int{10, 20, 30, 40}, 2))
+	noError(t, submitIntervals(s, plantKey, "meter-p1", "c1", []int{40, 30, 20, 10}, 1))
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	var settlements []*IntervalSettlement
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:QueryIntervalSettlements", "c1"), &settlements))
+
+	if len(settlements) != 2 {
+		t.Fatalf("settlements = %+v", settlements)
+	}
+
+	for _, settlement := range settlements {
+		switch settlement.UserName {
+		case "alice":
+			if settlement.DeviationRate != 0 || settlement.CreditChange <= 0 {
+				t.Errorf("alice settlement = %+v", settlement)
+			}
+		case "p1":
+			// 总电量相同，但逐时段的偏差合计为80kWh
+			if settlement.Under != 40 || settlement.Over != 40 || settlement.DeviationRate != 800 {
+				t.Errorf("p1 settlement = %+v", settlement)
+			}
+		}
+	}
+
+	if user := queryUser(t, s, "p1"); user.UserCredit != 20 {
+		t.Fatalf("p1 credit = %d, want 20", user.UserCredit)
+	}
+}
+
+func TestProfileRequiresIntervalCoverage(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+
+	call(t, s, "alice", "PowerTXContract:CommitProfile", "c1", "alice", "[10,20,30,40]", "0.5",
+		"2026-01-01 07:45:00", "2026-01-01 08:45:00")
+	call(t, s, "p1", "PowerTXContract:Bid", "c1", "p1", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	call(t, s, "admin", "PowerTXContract:Deal", "c1", "admin")
+
+	aliceKey := registerMeter(t, s, "meter-alice", "alice")
+	plantKey := registerMeter(t, s, "meter-p1", "p1")
+
+	// 总电量读数的签名不能作为单个时段的分时读数重放
+	s.advance(20 * time.Minute)
+	readingTime := s.timeNow()
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(aliceKey, meterMessage("meter-alice", "c1", 10, 1, readingTime)))
+
+	if _, err := s.invoke("gateway", "MeterContract:SubmitIntervalReadings", "meter-alice", "c1", "[10]", "1", readingTime, signature); err == nil {
+		t.Fatal("total signature replayed as an interval reading")
+	}
+
+	// 有分时曲线的compact不接受总电量读数
+	s.advance(40 * time.Minute)
+
+	if err := submitReading(s, aliceKey, "meter-alice", "c1", 100, 1); err == nil {
+		t.Fatal("total reading accepted for a profiled compact")
+	}
+
+	// p1的读数时间晚于EndTime，但只覆盖前两个时段
+	noError(t, submitIntervals(s, aliceKey, "meter-alice", "c1", []int{10, 20, 30, 40}, 1))
+	noError(t, submitIntervals(s, plantKey, "meter-p1", "c1", []int{10, 20}, 1))
+
+	if _, err := s.invoke("admin", "PowerTXContract:CheckCompact", "c1"); err == nil {
+		t.Fatal("checked without interval readings for every slot")
+	}
+
+	// 超过MeterGraceHours后缺少读数的时段按0结算，仍然逐时段比较
+	s.advance(25 * time.Hour)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	var settlements []*IntervalSettlement
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:QueryIntervalSettlements", "c1"), &settlements))
+
+	if len(settlements) != 2 || settlements[1].UserName != "p1" || settlements[1].Under != 70 || settlements[1].Over != 0 {
+		t.Fatalf("settlements = %+v", settlements)
+	}
+}
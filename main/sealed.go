@@ -0,0 +1,504 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"sort"
+)
+
+// SealedAuction compact的密封报价拍卖，CommitDeadline前提交报价承诺，RevealDeadline前公开报价
+type SealedAuction struct {
+	CommitDeadline 	string		`json:"commit_deadline"`
+	RevealDeadline 	string		`json:"reveal_deadline"`
+	Settled 		bool		`json:"settled"`
+}
+
+// SealedBid powerPlant的密封报价，Commitment为报价承诺，公开前Quantity与Price为0
+// State为 Sealed 已提交承诺 Revealed 已公开 Unrevealed 未按时公开 Invalid 公开的报价无效 Settled 已参与出清
+type SealedBid struct {
+	CompactId		string  	`json:"compact_id"`
+	PowerPlantName  string 		`json:"power_plant_name"`
+	Commitment 		string		`json:"commitment"`
+	Quantity 		int     	`json:"quantity"`
+	Price           Price 		`json:"price"`
+	State	    	string  	`json:"state"`
+	SealTime 		string		`json:"seal_time"`
+	RevealTime 		string		`json:"reveal_time"`
+	TxId 			string		`json:"tx_id"`
+}
+
+// StartSealedAuction powerUser把尚未收到报价的compact改为密封报价拍卖
+func (p *PowerTXContract) StartSealedAuction(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	commitDeadline string,
+	revealDeadline string) (*Compact, error) {
+	// 1.获取compact交易信息
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为compact的powerUser
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, compact.PowerUserName); err != nil {
+		return nil, err
+	}
+
+	// 3.判断compact状态，只有提交状态且不是拍卖的compact可以开始拍卖
+	if compact.State != CompactCommitting || compact.Auction != nil {
+		return nil, fmt.Errorf("Compact can not start a sealed auction ! ")
+	}
+
+	// 4.判断时间，交易时间 < 承诺截止 < 公开截止 <= 交割结束
+	var t TimeContract
+	started, err := t.CompareWithNow(ctx, commitDeadline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if started || !t.CompareTime(commitDeadline, revealDeadline) || t.CompareTime(compact.EndTime, revealDeadline) {
+		return nil, fmt.Errorf("Sealed auction deadlines are not right ! ")
+	}
+
+	// 5.上链
+	compact.Auction = &SealedAuction{
+		CommitDeadline: commitDeadline,
+		RevealDeadline: revealDeadline,
+	}
+
+	err = p.putCompact(ctx, compact)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return compact, nil
+}
+
+// SealBid powerPlant在承诺截止前提交密封报价，commitment为 sealMessage 的SHA-256十六进制编码
+// 承诺截止前可以用新承诺覆盖旧承诺
+func (p *PowerTXContract) SealBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string,
+	commitment string) (*SealedBid, error) {
+	// 1.判断承诺格式
+	if decoded, err := hex.DecodeString(commitment); err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("Commitment must be a hex encoded SHA-256 hash ! ")
+	}
+
+	// 2.判断powerPlant是否为调用者本人，信用值是否足够
+	var r RoleContract
+	powerPlant, err := r.checkCaller(ctx, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if powerPlant.UserCredit - variables[CreditBorder] < 0 {
+		return nil, fmt.Errorf("PowerPlant credit less than %d ", variables[CreditBorder])
+	}
+
+	// 3.获取compact交易信息，判断是否为拍卖中的compact
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.Auction == nil || compact.Auction.Settled {
+		return nil, fmt.Errorf("Compact is not a sealed auction ! ")
+	}
+
+	if err := p.checkState(compact, "SealBid"); err != nil {
+		return nil, err
+	}
+
+	// 3.1已成交的powerPlant不能再次报价
+	if compact.hasLeg(powerPlantName) {
+		return nil, fmt.Errorf("%s has an accepted leg for %s ! ", powerPlantName, compactId)
+	}
+
+	// 4.判断是否在交易时间且未到承诺截止
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.StartTime, compact.Auction.CommitDeadline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to seal bid ! ")
+	}
+
+	// 5.报价结构体赋值
+	sealTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bid := SealedBid{
+		CompactId: compactId,
+		PowerPlantName: powerPlantName,
+		Commitment: commitment,
+		State: "Sealed",
+		SealTime: sealTime,
+		TxId: ctx.GetStub().GetTxID(),
+	}
+
+	// 6.报价上链，并记录协商过程
+	err = p.putSealedBid(ctx, &bid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.appendNegotiation(ctx, compact, NegotiationEntry{
+		Action: "SealBid",
+		Actor: powerPlantName,
+		PowerPlantName: powerPlantName,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 7.compact进入竞价状态
+	if compact.State != CompactBiding {
+		if err := p.transition(ctx, compact, "SealBid", CompactBiding); err != nil {
+			return nil, err
+		}
+
+		err = p.putCompact(ctx, compact)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &bid, nil
+}
+
+// RevealBid powerPlant在承诺截止后、公开截止前公开报价，quantity为0时报价电量为compact的全部电量
+// 公开的电量、电价与salt必须与承诺一致
+func (p *PowerTXContract) RevealBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string,
+	quantity int,
+	priceText string,
+	salt string) (*SealedBid, error) {
+	// 1.判断电价格式
+	price, err := ParsePrice(priceText)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断powerPlant是否为调用者本人
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, powerPlantName); err != nil {
+		return nil, err
+	}
+
+	// 3.获取compact与密封报价
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if compact.Auction == nil || compact.Auction.Settled {
+		return nil, fmt.Errorf("Compact is not a sealed auction ! ")
+	}
+
+	bid, err := p.QuerySealedBid(ctx, compactId, powerPlantName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bid.State != "Sealed" {
+		return nil, fmt.Errorf("Sealed bid state is %s ! ", bid.State)
+	}
+
+	if compact.hasLeg(powerPlantName) {
+		return nil, fmt.Errorf("%s has an accepted leg for %s ! ", powerPlantName, compactId)
+	}
+
+	// 4.判断是否在公开时间
+	var t TimeContract
+	inPeriod, err := t.InPeriod(ctx, compact.Auction.CommitDeadline, compact.Auction.RevealDeadline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPeriod {
+		return nil, fmt.Errorf("It is not time to reveal bid ! ")
+	}
+
+	// 5.验证承诺
+	if quantity < 0 {
+		return nil, fmt.Errorf("Quantity can not be negative ! ")
+	}
+
+	digest := sha256.Sum256(sealMessage(compactId, powerPlantName, quantity, price, salt))
+
+	if hex.EncodeToString(digest[:]) != bid.Commitment {
+		return nil, fmt.Errorf("Reveal does not match the commitment ! ")
+	}
+
+	// 6.报价结构体赋值
+	revealTime, err := t.Now(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if quantity == 0 {
+		quantity = compact.remainingTransaction()
+	}
+
+	bid.Quantity = quantity
+	bid.Price = price
+	bid.State = "Revealed"
+	bid.RevealTime = revealTime
+	bid.TxId = ctx.GetStub().GetTxID()
+
+	// 7.报价上链，并记录协商过程
+	err = p.putSealedBid(ctx, bid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.appendNegotiation(ctx, compact, NegotiationEntry{
+		Action: "RevealBid",
+		Actor: powerPlantName,
+		PowerPlantName: powerPlantName,
+		Quantity: quantity,
+		Price: price,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bid, nil
+}
+
+// SettleSealedAuction powerUser在公开截止后出清拍卖，未公开、电量超过compact剩余电量或已成交的powerPlant的报价作废
+// 有效报价按电价从低到高、承诺时间从早到晚、powerPlant名称排序依次接受，直到覆盖全部电量
+func (p *PowerTXContract) SettleSealedAuction(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) (*Compact, error) {
+	// 1.获取compact交易信息
+	compact, err := p.QueryCompact(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.判断调用者是否为compact的powerUser
+	var r RoleContract
+	if _, err := r.checkCaller(ctx, compact.PowerUserName); err != nil {
+		return nil, err
+	}
+
+	// 3.判断compact状态与公开截止
+	if compact.Auction == nil || compact.Auction.Settled {
+		return nil, fmt.Errorf("Compact is not a sealed auction ! ")
+	}
+
+	if compact.State != CompactCommitting && compact.State != CompactBiding {
+		return nil, fmt.Errorf("Compact %s is %s, can not settle auction ! ", compact.CompactId, compact.State)
+	}
+
+	var t TimeContract
+	revealed, err := t.CompareWithNow(ctx, compact.Auction.RevealDeadline)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !revealed {
+		return nil, fmt.Errorf("Sealed auction is revealing ! ")
+	}
+
+	// 4.作废无效报价，有效报价转为竞价中的报价
+	sealedBids, err := p.QuerySealedBids(ctx, compactId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bids := []*CompactBid{}
+	for _, sealedBid := range sealedBids {
+		switch {
+		case sealedBid.State == "Sealed":
+			sealedBid.State = "Unrevealed"
+		case sealedBid.State == "Revealed" && (sealedBid.Quantity > compact.remainingTransaction() || compact.hasLeg(sealedBid.PowerPlantName)):
+			sealedBid.State = "Invalid"
+		case sealedBid.State == "Revealed":
+			sealedBid.State = "Settled"
+			bids = append(bids, &CompactBid{
+				CompactId: compactId,
+				PowerPlantName: sealedBid.PowerPlantName,
+				Quantity: sealedBid.Quantity,
+				Price: sealedBid.Price,
+				State: "Biding",
+				BidTime: sealedBid.SealTime,
+				TxId: ctx.GetStub().GetTxID(),
+			})
+		default:
+			continue
+		}
+
+		if err := p.putSealedBid(ctx, sealedBid); err != nil {
+			return nil, err
+		}
+	}
+
+	compact.Auction.Settled = true
+
+	// 5.没有有效报价，compact回到提交状态
+	if len(bids) == 0 {
+		if compact.State == CompactBiding {
+			if err := p.transition(ctx, compact, "SettleAuction", CompactCommitting); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := p.putCompact(ctx, compact); err != nil {
+			return nil, err
+		}
+
+		return compact, nil
+	}
+
+	// 6.有效报价上链，按确定的顺序接受
+	for _, bid := range bids {
+		if err := p.putBid(ctx, bid); err != nil {
+			return nil, err
+		}
+	}
+
+	selectedBids := make([]*CompactBid, len(bids))
+	copy(selectedBids, bids)
+
+	sort.SliceStable(selectedBids, func(i, j int) bool {
+		if selectedBids[i].Price != selectedBids[j].Price {
+			return selectedBids[i].Price < selectedBids[j].Price
+		}
+
+		if selectedBids[i].BidTime != selectedBids[j].BidTime {
+			return selectedBids[i].BidTime < selectedBids[j].BidTime
+		}
+
+		return selectedBids[i].PowerPlantName < selectedBids[j].PowerPlantName
+	})
+
+	return p.acceptBids(ctx, compact, bids, selectedBids)
+}
+
+// QuerySealedBid 获取powerPlant对compact的密封报价
+func (p *PowerTXContract) QuerySealedBid(
+	ctx contractapi.TransactionContextInterface,
+	compactId string,
+	powerPlantName string) (*SealedBid, error) {
+	// 1.获取密封报价信息
+	bidAsBytes, err := getState(ctx, SealedBidObjectType, compactId, powerPlantName)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query SealedBid Info from world state. %s ", err.Error())
+	}
+
+	if bidAsBytes == nil {
+		return nil, fmt.Errorf("%s has not sealed a bid for %s ", powerPlantName, compactId)
+	}
+
+	// 2.赋值
+	bid := new(SealedBid)
+	_ = json.Unmarshal(bidAsBytes, bid)
+
+	return bid, nil
+}
+
+// QuerySealedBids 获取compact的全部密封报价，按powerPlant名称排序
+func (p *PowerTXContract) QuerySealedBids(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*SealedBid, error) {
+	// 1.按compactId查询密封报价
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(SealedBidObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query SealedBid Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	bids := []*SealedBid{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		bid := new(SealedBid)
+		_ = json.Unmarshal(kv.Value, bid)
+		bids = append(bids, bid)
+	}
+
+	return bids, nil
+}
+
+// putSealedBid 密封报价上链
+func (p *PowerTXContract) putSealedBid(
+	ctx contractapi.TransactionContextInterface,
+	bid *SealedBid) error {
+	bidAsBytes, _ := json.Marshal(bid)
+
+	return putState(ctx, bidAsBytes, SealedBidObjectType, bid.CompactId, bid.PowerPlantName)
+}
+
+// checkAuction 拍卖中的compact在出清前不能公开报价、接受、拒绝或取消报价
+func (c *Compact) checkAuction() error {
+	if c.Auction != nil && !c.Auction.Settled {
+		return fmt.Errorf("Compact is in a sealed auction ! ")
+	}
+
+	return nil
+}
+
+// sealMessage 密封报价承诺的内容 compactId|powerPlantName|quantity|price|salt，price为保留3位小数的元/kWh
+func sealMessage(compactId string, powerPlantName string, quantity int, price Price, salt string) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%d|%s|%s", compactId, powerPlantName, quantity, price.String(), salt))
+}
+
+// hasLeg 判断powerPlant是否已有compact的分段
+func (c *Compact) hasLeg(powerPlantName string) bool {
+	for _, leg := range c.Legs {
+		if leg.PowerPlantName == powerPlantName {
+			return true
+		}
+	}
+
+	return false
+}
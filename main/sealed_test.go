@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// sealHash 计算powerPlant报价承诺
+func sealHash(compactId string, powerPlantName string, quantity int, priceText string, salt string) string {
+	price, _ := ParsePrice(priceText)
+	digest := sha256.Sum256(sealMessage(compactId, powerPlantName, quantity, price, salt))
+
+	return hex.EncodeToString(digest[:])
+}
+
+func TestSealedAuction(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1", "p2", "p3", "p4")
+	deposit(t, s, "alice", 1000000)
+
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "alice", "PowerTXContract:StartSealedAuction", "c1", "2026-01-01 12:00:00", "2026-01-02 00:00:00")
+
+	// powerPlant只能以自己的名义提交承诺
+	if _, err := s.invoke("p2", "PowerTXContract:SealBid", "c1", "p1", sealHash("c1", "p1", 60, "0.6", "s1")); err == nil {
+		t.Fatal("sealed a bid for another power plant")
+	}
+
+	// 高于compact价格的报价、全部剩余电量的报价、两个部分报价
+	call(t, s, "p1", "PowerTXContract:SealBid", "c1", "p1", sealHash("c1", "p1", 60, "0.6", "s1"))
+	call(t, s, "p2", "PowerTXContract:SealBid", "c1", "p2", sealHash("c1", "p2", 0, "0.45", "s2"))
+	call(t, s, "p3", "PowerTXContract:SealBid", "c1", "p3", sealHash("c1", "p3", 50, "0.4", "s3"))
+	call(t, s, "p4", "PowerTXContract:SealBid", "c1", "p4", sealHash("c1", "p4", 50, "0.3", "s4"))
+
+	// 拍卖期间不能公开报价
+	if _, err := s.invoke("p1", "PowerTXContract:Bid", "c1", "p1", "0.6"); err == nil {
+		t.Fatal("open bid during the auction")
+	}
+
+	if _, err := s.invoke("p1", "PowerTXContract:RevealBid", "c1", "p1", "60", "0.6", "s1"); err == nil {
+		t.Fatal("revealed before the commit deadline")
+	}
+
+	s.advance(6 * time.Hour)
+
+	if _, err := s.invoke("p1", "PowerTXContract:SealBid", "c1", "p1", sealHash("c1", "p1", 60, "0.6", "s1")); err == nil {
+		t.Fatal("sealed after the commit deadline")
+	}
+
+	// 公开的报价与承诺不一致
+	if _, err := s.invoke("p3", "PowerTXContract:RevealBid", "c1", "p3", "50", "0.4", "bad"); err == nil {
+		t.Fatal("revealed a bid not matching the commitment")
+	}
+
+	call(t, s, "p1", "PowerTXContract:RevealBid", "c1", "p1", "60", "0.6", "s1")
+	call(t, s, "p2", "PowerTXContract:RevealBid", "c1", "p2", "0", "0.45", "s2")
+	call(t, s, "p3", "PowerTXContract:RevealBid", "c1", "p3", "50", "0.4", "s3")
+
+	if _, err := s.invoke("alice", "PowerTXContract:SettleSealedAuction", "c1"); err == nil {
+		t.Fatal("settled before the reveal deadline")
+	}
+
+	// p4未公开，按价格从低到高接受p3与p2
+	s.advance(12 * time.Hour)
+	compact := new(Compact)
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:SettleSealedAuction", "c1"), compact))
+
+	if compact.State != CompactAccepted || len(compact.Legs) != 2 || compact.Legs[0].PowerPlantName != "p3" || compact.Legs[1].PowerPlantName != "p2" {
+		t.Fatalf("compact = %+v", compact)
+	}
+
+	if compact.Legs[0].Quantity != 50 || compact.Legs[1].Quantity != 50 {
+		t.Fatalf("legs = %+v", compact.Legs)
+	}
+
+	var sealedBids []*SealedBid
+	noError(t, json.Unmarshal(call(t, s, "alice", "PowerTXContract:QuerySealedBids", "c1"), &sealedBids))
+
+	states := make(map[string]string)
+	for _, sealedBid := range sealedBids {
+		states[sealedBid.PowerPlantName] = sealedBid.State
+	}
+
+	if states["p2"] != "Settled" || states["p3"] != "Settled" || states["p4"] != "Unrevealed" {
+		t.Fatalf("sealed bid states = %v", states)
+	}
+
+	if _, err := s.invoke("alice", "PowerTXContract:SettleSealedAuction", "c1"); err == nil {
+		t.Fatal("auction settled twice")
+	}
+}
+
+func TestSealedAuctionAfterPartialFill(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1", "p2")
+	deposit(t, s, "alice", 1000000)
+
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "p1", "PowerTXContract:PartialBid", "c1", "p1", "40", "0.5")
+	call(t, s, "alice", "PowerTXContract:Accept", "c1")
+	call(t, s, "alice", "PowerTXContract:StartSealedAuction", "c1", "2026-01-01 12:00:00", "2026-01-02 00:00:00")
+
+	// 已有分段的powerPlant不能再参与拍卖
+	if _, err := s.invoke("p1", "PowerTXContract:SealBid", "c1", "p1", sealHash("c1", "p1", 0, "0.4", "s1")); err == nil {
+		t.Fatal("sealed a bid from a plant holding a leg")
+	}
+
+	call(t, s, "p2", "PowerTXContract:SealBid", "c1", "p2", sealHash("c1", "p2", 0, "0.45", "s2"))
+
+	s.advance(6 * time.Hour)
+	call(t, s, "p2", "PowerTXContract:RevealBid", "c1", "p2", "0", "0.45", "s2")
+
+	s.advance(12 * time.Hour)
+	call(t, s, "alice", "PowerTXContract:SettleSealedAuction", "c1")
+
+	if compact := queryCompact(t, s, "c1"); compact.State != CompactAccepted || len(compact.Legs) != 2 || compact.Legs[1].PowerPlantName != "p2" || compact.Legs[1].Quantity != 60 {
+		t.Fatalf("compact = %+v", compact)
+	}
+}
+
+func TestSealedAuctionWithoutValidBids(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "p1")
+	deposit(t, s, "alice", 1000000)
+
+	commitCompact(t, s, "c1", "alice", 100)
+	call(t, s, "alice", "PowerTXContract:StartSealedAuction", "c1", "2026-01-01 12:00:00", "2026-01-02 00:00:00")
+	call(t, s, "p1", "PowerTXContract:SealBid", "c1", "p1", sealHash("c1", "p1", 0, "0.45", "s1"))
+
+	// p1未公开报价，出清后compact回到提交状态
+	s.advance(18 * time.Hour)
+	call(t, s, "alice", "PowerTXContract:SettleSealedAuction", "c1")
+
+	if compact := queryCompact(t, s, "c1"); compact.State != CompactCommitting || len(compact.Legs) != 0 {
+		t.Fatalf("compact = %+v", compact)
+	}
+
+	var p PowerTXContract
+	histories, err := p.QueryCompactHistory(s.ctx("alice"), "c1")
+	noError(t, err)
+
+	if last := histories[len(histories)-1]; last.Event != "SettleAuction" || last.FromState != CompactBiding || last.ToState != CompactCommitting {
+		t.Fatalf("last history = %+v", last)
+	}
+}
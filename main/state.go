@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+)
+
+// compact的状态
+const CompactCommitting string = "Committing"
+const CompactBiding string = "Biding"
+const CompactAccepted string = "Accepted"
+const CompactDeal string = "Deal"
+const CompactDone string = "Done"
+const CompactCancelCommit string = "CancelCommit"
+const CompactExpired string = "Expired"
+
+// CompactTransition compact的一条合法状态转换，From为空表示新建compact
+type CompactTransition struct {
+	Event 			string
+	From 			string
+	To 				string
+	Roles 			[]string
+}
+
+// compactTransitions compact状态转换表，Event为触发转换的交易，Roles为允许触发的角色
+var compactTransitions = []CompactTransition{
+	{Event: "Commit", From: "", To: CompactCommitting, Roles: []string{PowerUser}},
+	{Event: "ClearMarket", From: "", To: CompactDeal, Roles: []string{ADMIN}},
+	{Event: "GenerateDeliveries", From: "", To: CompactDeal, Roles: []string{ADMIN}},
+	{Event: "ClearMarket", From: "", To: CompactAccepted, Roles: []string{ADMIN}},
+	{Event: "GenerateDeliveries", From: "", To: CompactAccepted, Roles: []string{ADMIN}},
+	{Event: "Bid", From: CompactCommitting, To: CompactBiding, Roles: []string{PowerPlant}},
+	{Event: "Bid", From: CompactBiding, To: CompactBiding, Roles: []string{PowerPlant}},
+	{Event: "SealBid", From: CompactCommitting, To: CompactBiding, Roles: []string{PowerPlant}},
+	{Event: "SealBid", From: CompactBiding, To: CompactBiding, Roles: []string{PowerPlant}},
+	{Event: "SettleAuction", From: CompactBiding, To: CompactCommitting, Roles: []string{PowerUser}},
+	{Event: "CancelBid", From: CompactBiding, To: CompactBiding, Roles: []string{PowerPlant}},
+	{Event: "CancelBid", From: CompactBiding, To: CompactCommitting, Roles: []string{PowerPlant}},
+	{Event: "Reject", From: CompactBiding, To: CompactCommitting, Roles: []string{PowerUser}},
+	{Event: "Accept", From: CompactBiding, To: CompactBiding, Roles: []string{PowerUser}},
+	{Event: "Accept", From: CompactBiding, To: CompactCommitting, Roles: []string{PowerUser}},
+	{Event: "Accept", From: CompactBiding, To: CompactAccepted, Roles: []string{PowerUser}},
+	{Event: "CancelCommit", From: CompactCommitting, To: CompactCancelCommit, Roles: []string{PowerUser}},
+	{Event: "Deal", From: CompactAccepted, To: CompactDeal, Roles: []string{ADMIN, CommitteeMember}},
+	{Event: "CheckCompact", From: CompactDeal, To: CompactDone, Roles: []string{ADMIN, CommitteeMember}},
+	{Event: "ExpireDue", From: CompactCommitting, To: CompactExpired, Roles: []string{ADMIN}},
+	{Event: "ExpireDue", From: CompactBiding, To: CompactExpired, Roles: []string{ADMIN}},
+	{Event: "ExpireDue", From: CompactAccepted, To: CompactExpired, Roles: []string{ADMIN}},
+}
+
+// CompactHistory compact状态转换记录，只追加不修改
+type CompactHistory struct {
+	CompactId 		string		`json:"compact_id"`
+	Event 			string		`json:"event"`
+	FromState 		string		`json:"from_state"`
+	ToState 		string		`json:"to_state"`
+	Actor 			string		`json:"actor"`
+	TxId 			string		`json:"tx_id"`
+	TransitionTime 	string		`json:"transition_time"`
+}
+
+// QueryCompactHistory 获取compact的状态转换记录，按时间排序
+func (p *PowerTXContract) QueryCompactHistory(
+	ctx contractapi.TransactionContextInterface,
+	compactId string) ([]*CompactHistory, error) {
+	// 1.按compactId查询转换记录
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(CompactHistoryObjectType, []string{compactId})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query CompactHistory Info from world state. %s ", err.Error())
+	}
+
+	defer iterator.Close()
+
+	// 2.赋值
+	histories := []*CompactHistory{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		history := new(CompactHistory)
+		_ = json.Unmarshal(kv.Value, history)
+		histories = append(histories, history)
+	}
+
+	return histories, nil
+}
+
+// checkState 判断compact当前状态下能否执行event
+func (p *PowerTXContract) checkState(
+	compact *Compact,
+	event string) error {
+	for _, transition := range compactTransitions {
+		if transition.Event == event && transition.From == compact.State {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Compact %s is %s, can not %s ! ", compact.CompactId, compact.State, event)
+}
+
+// transition 按状态转换表把compact转换到toState，并记录转换历史
+// 状态不变时只检查转换是否合法，不记录历史
+func (p *PowerTXContract) transition(
+	ctx contractapi.TransactionContextInterface,
+	compact *Compact,
+	event string,
+	toState string) error {
+	// 1.查找合法的状态转换
+	var found *CompactTransition
+	for i, transition := range compactTransitions {
+		if transition.Event == event && transition.From == compact.State && transition.To == toState {
+			found = &compactTransitions[i]
+			break
+		}
+	}
+
+	if found == nil {
+		return fmt.Errorf("Compact %s can not %s from %s to %s ! ", compact.CompactId, event, compact.State, toState)
+	}
+
+	// 2.判断调用者角色
+	var r RoleContract
+	caller, err := r.QueryCaller(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	allowed := false
+	for _, role := range found.Roles {
+		if role == caller.UserRole {
+			allowed = true
+		}
+	}
+
+	// 2.1委员会成员可以触发允许委员会成员的转换
+	if !allowed && hasRole(found.Roles, CommitteeMember) {
+		var e ElectionContract
+		allowed = e.isCommitteeMember(ctx, caller.UserName)
+	}
+
+	if !allowed {
+		return fmt.Errorf("%s can not %s compact %s ! ", caller.UserRole, event, compact.CompactId)
+	}
+
+	if compact.State == toState {
+		return nil
+	}
+
+	// 3.记录转换历史
+	var t TimeContract
+	transitionTime, err := t.Now(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	txId := ctx.GetStub().GetTxID()
+	history := CompactHistory{
+		CompactId: compact.CompactId,
+		Event: event,
+		FromState: compact.State,
+		ToState: toState,
+		Actor: caller.UserName,
+		TxId: txId,
+		TransitionTime: transitionTime,
+	}
+
+	historyAsBytes, _ := json.Marshal(history)
+	err = putState(ctx, historyAsBytes, CompactHistoryObjectType, compact.CompactId, transitionTime, txId)
+
+	if err != nil {
+		return err
+	}
+
+	// 4.发出事件
+	actors := []string{caller.UserName}
+	for _, name := range append([]string{compact.PowerUserName, compact.AdminName}, compact.PowerPlantName) {
+		if name != "" && name != caller.UserName {
+			actors = append(actors, name)
+		}
+	}
+
+	err = emitEvent(ctx, events.CompactStateChanged, events.CompactEntity, compact.CompactId,
+		compact.State, toState, actors, map[string]string{"event": event})
+
+	if err != nil {
+		return err
+	}
+
+	// 5.更改状态
+	compact.State = toState
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompactHistory(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 1000000)
+	dealCompact(t, s, "c1", "alice", "plant", 100)
+
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+	meterReading(t, s, "alice", "c1", 100)
+	meterReading(t, s, "plant", "c1", 100)
+	call(t, s, "admin", "PowerTXContract:CheckCompact", "c1")
+
+	var p PowerTXContract
+	histories, err := p.QueryCompactHistory(s.ctx("admin"), "c1")
+	noError(t, err)
+
+	want := []CompactHistory{
+		{Event: "Commit", FromState: "", ToState: CompactCommitting, Actor: "alice"},
+		{Event: "Bid", FromState: CompactCommitting, ToState: CompactBiding, Actor: "plant"},
+		{Event: "Accept", FromState: CompactBiding, ToState: CompactAccepted, Actor: "alice"},
+		{Event: "Deal", FromState: CompactAccepted, ToState: CompactDeal, Actor: "admin"},
+		{Event: "CheckCompact", FromState: CompactDeal, ToState: CompactDone, Actor: "admin"},
+	}
+
+	if len(histories) != len(want) {
+		t.Fatalf("history = %+v", histories)
+	}
+
+	for i, history := range want {
+		got := histories[i]
+
+		if got.Event != history.Event || got.FromState != history.FromState || got.ToState != history.ToState || got.Actor != history.Actor {
+			t.Errorf("history %d = %+v, want %+v", i, got, history)
+		}
+	}
+
+	// 已完成的compact不能再次结算或取消
+	if _, err := s.invoke("admin", "PowerTXContract:CheckCompact", "c1"); err == nil {
+		t.Fatal("compact checked twice")
+	}
+
+	if _, err := s.invoke("alice", "PowerTXContract:CancelCommit", "c1"); err == nil {
+		t.Fatal("done compact cancelled")
+	}
+}
+
+func TestTransitionRejectsIllegalMoves(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	commitCompact(t, s, "c1", "alice", 100)
+
+	if _, err := s.invoke("admin", "PowerTXContract:Deal", "c1", "admin"); err == nil {
+		t.Fatal("deal on a committing compact")
+	}
+
+	var p PowerTXContract
+	compact := queryCompact(t, s, "c1")
+
+	if err := p.transition(s.ctx("alice"), compact, "Commit", CompactDone); err == nil {
+		t.Fatal("transition missing from the table")
+	}
+
+	// 转换表限制触发转换的角色
+	call(t, s, "plant", "PowerTXContract:Bid", "c1", "plant", "0.5")
+	compact = queryCompact(t, s, "c1")
+
+	if err := p.transition(s.ctx("plant"), compact, "Accept", CompactAccepted); err == nil {
+		t.Fatal("plant accepted a compact")
+	}
+
+	if compact.State != CompactBiding {
+		t.Fatalf("state = %s after a rejected transition", compact.State)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// testStub 测试用的账本，交易时间由now决定，记录发出的链码事件
+type testStub struct {
+	*shimtest.MockStub
+	now    time.Time
+	txs    int
+	events map[string][]byte
+}
+
+// newTestStub 创建测试账本，交易时间从2026-01-01 08:00:00开始
+func newTestStub() *testStub {
+	return &testStub{
+		MockStub: shimtest.NewMockStub("powerTx", nil),
+		now:      time.Date(2026, 1, 1, 8, 0, 0, 0, TimeLocation),
+		events:   make(map[string][]byte),
+	}
+}
+
+// ctx 以userName的证书身份开始一个新交易
+func (s *testStub) ctx(userName string) *contractapi.TransactionContext {
+	s.txs++
+	s.MockTransactionStart(fmt.Sprintf("tx%06d", s.txs))
+	s.Creator = creator(userName)
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(s)
+	identity, _ := cid.New(s)
+	ctx.SetClientIdentity(identity)
+
+	return ctx
+}
+
+// GetStateByRange 与Fabric一致，范围查询不返回组合键
+func (s *testStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if startKey == "" {
+		startKey = "\x01"
+	}
+
+	return s.iterate(func(key string) bool {
+		return key >= startKey && (endKey == "" || key < endKey)
+	}), nil
+}
+
+func (s *testStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.iterate(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}), nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination 书签为下一页第一条记录的键
+func (s *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iterator := s.iterate(func(key string) bool {
+		return strings.HasPrefix(key, prefix) && key >= bookmark
+	})
+
+	next := ""
+	if len(iterator.kvs) > int(pageSize) {
+		next = iterator.kvs[pageSize].Key
+		iterator.kvs = iterator.kvs[:pageSize]
+	}
+
+	return iterator, &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(iterator.kvs)), Bookmark: next}, nil
+}
+
+// iterate 按键排序返回满足条件的记录
+func (s *testStub) iterate(match func(key string) bool) *testIterator {
+	keys := []string{}
+	for key := range s.State {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	iterator := new(testIterator)
+	for _, key := range keys {
+		iterator.kvs = append(iterator.kvs, &queryresult.KV{Key: key, Value: s.State[key]})
+	}
+
+	return iterator
+}
+
+// testIterator 测试账本的查询结果
+type testIterator struct {
+	kvs   []*queryresult.KV
+	index int
+}
+
+func (i *testIterator) HasNext() bool {
+	return i.index < len(i.kvs)
+}
+
+func (i *testIterator) Next() (*queryresult.KV, error) {
+	i.index++
+
+	return i.kvs[i.index-1], nil
+}
+
+func (i *testIterator) Close() error {
+	return nil
+}
+
+// router 与main相同的合约路由，每个交易执行前检查调用者权限，交易成功后发出业务事件
+var router = newRouter()
+
+// newRouter 创建合约路由
+func newRouter() *contractapi.ContractChaincode {
+	chaincodeContracts := contracts()
+	for _, contract := range chaincodeContracts {
+		value := reflect.ValueOf(contract).Elem()
+		value.FieldByName("BeforeTransaction").Set(reflect.ValueOf(CheckPermission))
+		value.FieldByName("AfterTransaction").Set(reflect.ValueOf(FlushEvents))
+		value.FieldByName("TransactionContextHandler").Set(reflect.ValueOf(new(TransactionContext)))
+	}
+
+	chaincode, err := contractapi.NewChaincode(chaincodeContracts...)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return chaincode
+}
+
+// invokeStub 按指定的交易名与参数调用的测试账本
+type invokeStub struct {
+	*testStub
+	function string
+	args     []string
+}
+
+func (s *invokeStub) GetFunctionAndParameters() (string, []string) {
+	return s.function, s.args
+}
+
+// invoke 以userName的证书身份经合约路由调用function，返回交易结果
+// 交易失败时与Fabric一样丢弃交易的全部写入
+func (s *testStub) invoke(userName string, function string, args ...string) ([]byte, error) {
+	state := make(map[string][]byte, len(s.State))
+	for key, value := range s.State {
+		state[key] = value
+	}
+	keys := list.New()
+	keys.PushBackList(s.Keys)
+
+	s.ctx(userName)
+	response := router.Invoke(&invokeStub{testStub: s, function: function, args: args})
+
+	if response.Status != shim.OK {
+		s.State, s.Keys = state, keys
+		return nil, errors.New(response.Message)
+	}
+
+	return response.Payload, nil
+}
+
+// advance 交易时间向后推移
+func (s *testStub) advance(d time.Duration) {
+	s.now = s.now.Add(d)
+}
+
+// timeNow 当前交易时间的字符串形式
+func (s *testStub) timeNow() string {
+	return s.now.In(TimeLocation).Format(TimeLayout)
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return ptypes.TimestampProto(s.now)
+}
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	s.events[name] = payload
+
+	return nil
+}
+
+// creators 测试用户的证书身份，同名用户的证书相同
+var creators = make(map[string][]byte)
+
+// creator 生成userName的自签名证书，以Org1MSP的身份序列化
+func creator(userName string) []byte {
+	if identity, ok := creators[userName]; ok {
+		return identity
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(creators) + 1)),
+		Subject:      pkix.Name{CommonName: userName},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	certificate, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	identity, _ := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   "Org1MSP",
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+	})
+	creators[userName] = identity
+
+	return identity
+}
+
+// noError 调用出错时终止测试
+func noError(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errOf 丢弃调用结果，只返回错误
+func errOf(_ interface{}, err error) error {
+	return err
+}
+
+// call 经合约路由调用function，出错时终止测试
+func call(t *testing.T, s *testStub, userName string, function string, args ...string) []byte {
+	t.Helper()
+
+	payload, err := s.invoke(userName, function, args...)
+	noError(t, err)
+
+	return payload
+}
+
+// register 以本人证书注册用户
+func register(t *testing.T, s *testStub, userRole string, userNames ...string) {
+	t.Helper()
+
+	for _, userName := range userNames {
+		call(t, s, userName, "RoleContract:Register", userName, userRole)
+	}
+}
+
+// initAdmin 注册admin
+func initAdmin(t *testing.T, s *testStub, userName string) {
+	t.Helper()
+
+	call(t, s, userName, "RoleContract:InitAdmin", userName)
+}
+
+// deposit admin为用户充值
+func deposit(t *testing.T, s *testStub, userName string, amount int64) {
+	t.Helper()
+
+	call(t, s, "admin", "AccountContract:Deposit", userName, fmt.Sprint(amount))
+}
+
+// queryAccount 获取用户账户
+func queryAccount(t *testing.T, s *testStub, userName string) *Account {
+	t.Helper()
+
+	var a AccountContract
+	account, err := a.QueryAccount(s.ctx(userName), userName)
+	noError(t, err)
+
+	return account
+}
+
+// queryCompact 获取compact
+func queryCompact(t *testing.T, s *testStub, compactId string) *Compact {
+	t.Helper()
+
+	var p PowerTXContract
+	compact, err := p.QueryCompact(s.ctx("admin"), compactId)
+	noError(t, err)
+
+	return compact
+}
+
+// queryUser 获取用户
+func queryUser(t *testing.T, s *testStub, userName string) *User {
+	t.Helper()
+
+	var r RoleContract
+	user, err := r.QueryUser(s.ctx(userName), userName)
+	noError(t, err)
+
+	return user
+}
+
+// certId 获取userName证书的证书ID
+func certId(s *testStub, userName string) string {
+	id, _ := s.ctx(userName).GetClientIdentity().GetID()
+
+	return id
+}
+
+// commitCompact powerUser以0.5的价格发起交割时段为2026年1月的compact
+func commitCompact(t *testing.T, s *testStub, compactId string, powerUserName string, transaction int) {
+	t.Helper()
+
+	call(t, s, powerUserName, "PowerTXContract:Commit", compactId, powerUserName, fmt.Sprint(transaction), "0.5",
+		"2026-01-01 00:00:00", "2026-02-01 00:00:00")
+}
+
+// registerMeter admin为用户登记电表，返回电表私钥
+func registerMeter(t *testing.T, s *testStub, meterId string, userName string) ed25519.PrivateKey {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	noError(t, err)
+
+	call(t, s, "admin", "MeterContract:RegisterMeter", meterId, userName, base64.StdEncoding.EncodeToString(publicKey))
+
+	return privateKey
+}
+
+// submitReading 采集网关以当前交易时间提交电表签名的累计读数
+func submitReading(s *testStub, key ed25519.PrivateKey, meterId string, compactId string, energy int, nonce int64) error {
+	readingTime := s.timeNow()
+	signature := ed25519.Sign(key, meterMessage(meterId, compactId, energy, nonce, readingTime))
+
+	_, err := s.invoke("gateway", "MeterContract:SubmitMeterReading", meterId, compactId, fmt.Sprint(energy),
+		fmt.Sprint(nonce), readingTime, base64.StdEncoding.EncodeToString(signature))
+
+	return err
+}
+
+// meterReading 为用户登记电表并提交一条读数
+func meterReading(t *testing.T, s *testStub, userName string, compactId string, energy int) {
+	t.Helper()
+
+	meterId := "meter-" + userName + "-" + compactId
+	key := registerMeter(t, s, meterId, userName)
+	noError(t, submitReading(s, key, meterId, compactId, energy, 1))
+}
+
+// dealCompact powerUser发起compact，powerPlant报价，接受后由admin执行Deal
+func dealCompact(t *testing.T, s *testStub, compactId string, powerUserName string, powerPlantName string, transaction int) {
+	t.Helper()
+
+	commitCompact(t, s, compactId, powerUserName, transaction)
+	call(t, s, powerPlantName, "PowerTXContract:Bid", compactId, powerPlantName, "0.5")
+	call(t, s, powerUserName, "PowerTXContract:Accept", compactId)
+	call(t, s, "admin", "PowerTXContract:Deal", compactId, "admin")
+}
@@ -1,25 +1,90 @@
 package main
 
 import (
+	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 	"time"
 )
 
+// TimeLayout 合约中时间字符串的格式
+const TimeLayout string = "2006-01-02 15:04:05"
+
+// TimeLocation 合约中时间字符串所在的时区，北京时间
+var TimeLocation = time.FixedZone("CST", 8*60*60)
+
 type TimeContract struct {
 	contractapi.Contract
 }
 
 // CompareTime 比较time1 和 time2，如果time1 在time2 时间前面，返回true
 func (t *TimeContract) CompareTime(time1 string, time2 string) bool {
-	time1Obj, _ := time.Parse("2006-01-02 15:04:05", time1)
-	time2Obj, _ := time.Parse("2006-01-02 15:04:05", time2)
+	time1Obj, _ := time.ParseInLocation(TimeLayout, time1, TimeLocation)
+	time2Obj, _ := time.ParseInLocation(TimeLayout, time2, TimeLocation)
 
 	return time1Obj.Before(time2Obj)
 }
 
-// CompareWithNow time1 与现有时间比较，如果现有时间比time1晚，返回true
-func (t *TimeContract) CompareWithNow(time1 string) bool {
-	timeNow := time.Now().Format("2006-01-02 15:04:05")
+// Now 获取交易时间，所有背书节点得到的结果一致
+func (t *TimeContract) Now(
+	ctx contractapi.TransactionContextInterface) (string, error) {
+	// 1.获取交易提案中的时间戳
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to get transaction timestamp. %s ", err.Error())
+	}
+
+	// 2.转换为合约时间格式
+	timeNow := time.Unix(txTimestamp.Seconds, int64(txTimestamp.Nanos)).In(TimeLocation)
+
+	return timeNow.Format(TimeLayout), nil
+}
+
+// CompareWithNow time1 与交易时间比较，如果交易时间比time1晚，返回true
+func (t *TimeContract) CompareWithNow(
+	ctx contractapi.TransactionContextInterface,
+	time1 string) (bool, error) {
+	timeNow, err := t.Now(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	return t.CompareTime(time1, timeNow), nil
+}
+
+// InPeriod 判断交易时间是否在startTime 与endTime 之间
+func (t *TimeContract) InPeriod(
+	ctx contractapi.TransactionContextInterface,
+	startTime string,
+	endTime string) (bool, error) {
+	// 1.交易时间晚于开始时间
+	started, err := t.CompareWithNow(ctx, startTime)
+
+	if err != nil {
+		return false, err
+	}
+
+	// 2.交易时间早于结束时间
+	ended, err := t.CompareWithNow(ctx, endTime)
+
+	if err != nil {
+		return false, err
+	}
+
+	return started && !ended, nil
+}
+
+// addMinutes time1 加上minutes分钟后的时间
+func (t *TimeContract) addMinutes(time1 string, minutes int) string {
+	time1Obj, _ := time.ParseInLocation(TimeLayout, time1, TimeLocation)
+
+	return time1Obj.Add(time.Duration(minutes) * time.Minute).Format(TimeLayout)
+}
+
+// addHours time1 加上hours小时后的时间
+func (t *TimeContract) addHours(time1 string, hours int) string {
+	time1Obj, _ := time.ParseInLocation(TimeLayout, time1, TimeLocation)
 
-	return t.CompareTime(time1, timeNow)
+	return time1Obj.Add(time.Duration(hours) * time.Hour).Format(TimeLayout)
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNowUsesTransactionTimestamp(t *testing.T) {
+	s := newTestStub()
+	s.now = time.Date(2026, 3, 1, 4, 30, 0, 0, time.UTC)
+
+	var tc TimeContract
+	now, err := tc.Now(s.ctx("alice"))
+	noError(t, err)
+
+	if now != "2026-03-01 12:30:00" {
+		t.Fatalf("now = %s, want 2026-03-01 12:30:00", now)
+	}
+
+	ended, err := tc.CompareWithNow(s.ctx("alice"), "2026-03-01 12:29:59")
+	noError(t, err)
+
+	if !ended {
+		t.Fatal("earlier time not passed")
+	}
+
+	inPeriod, err := tc.InPeriod(s.ctx("alice"), "2026-03-01 12:30:00", "2026-03-01 13:00:00")
+	noError(t, err)
+
+	if inPeriod {
+		t.Fatal("period started at now")
+	}
+
+	inPeriod, err = tc.InPeriod(s.ctx("alice"), "2026-03-01 12:00:00", "2026-03-01 13:00:00")
+	noError(t, err)
+
+	if !inPeriod {
+		t.Fatal("now not in period")
+	}
+}
+
+func TestBidOutsideTradingWindow(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+
+	var p PowerTXContract
+	noError(t, errOf(p.Commit(s.ctx("alice"), "c1", "alice", 100, "0.5", "2026-01-02 00:00:00", "2026-01-03 00:00:00")))
+
+	if _, err := p.Bid(s.ctx("plant"), "c1", "plant", "0.5"); err == nil {
+		t.Fatal("bid before the trading window")
+	}
+
+	s.advance(36 * time.Hour)
+	noError(t, errOf(p.Bid(s.ctx("plant"), "c1", "plant", "0.5")))
+
+	s.advance(24 * time.Hour)
+	if _, err := p.Accept(s.ctx("alice"), "c1"); err == nil {
+		t.Fatal("accepted after the trading window")
+	}
+}
+
+func TestVoteOutsideProposalPeriod(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice", "bob")
+
+	var b BallotContract
+	noError(t, errOf(b.CreateBallotProposal(s.ctx("alice"), "b1", "alice", "Public", "2026-01-01 09:00:00", "2026-01-01 10:00:00")))
+
+	if _, err := b.VoteBallotProposal(s.ctx("bob"), "b1", "bob", true); err == nil {
+		t.Fatal("voted before the proposal started")
+	}
+
+	if _, err := b.CheckBallotProposal(s.ctx("alice"), "b1"); err == nil {
+		t.Fatal("checked before the proposal ended")
+	}
+
+	s.advance(90 * time.Minute)
+	noError(t, errOf(b.VoteBallotProposal(s.ctx("bob"), "b1", "bob", true)))
+
+	s.advance(time.Hour)
+	if _, err := b.VoteBallotProposal(s.ctx("alice"), "b1", "alice", true); err == nil {
+		t.Fatal("voted after the proposal ended")
+	}
+
+	noError(t, errOf(b.CheckBallotProposal(s.ctx("alice"), "b1")))
+}
+
+func TestCheckCompactBeforeEnd(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+	deposit(t, s, "alice", 50000)
+	dealCompact(t, s, "c1", "alice", "plant", 100)
+
+	var p PowerTXContract
+	if _, err := p.CheckCompact(s.ctx("admin"), "c1"); err == nil {
+		t.Fatal("compact checked before end")
+	}
+
+	s.now = time.Date(2026, 2, 1, 12, 0, 0, 0, TimeLocation)
+	meterReading(t, s, "alice", "c1", 100)
+	meterReading(t, s, "plant", "c1", 100)
+	noError(t, errOf(p.CheckCompact(s.ctx("admin"), "c1")))
+}
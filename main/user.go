@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
 )
 
 type RoleContract struct {
@@ -16,6 +17,17 @@ type User struct {
 	UserRole		string	`json:"user_role"`
 	UserCredit      int		`json:"user_credit"`
 	Power           int		`json:"power"`
+	MspId           string	`json:"msp_id"`
+	CertId          string	`json:"cert_id"`
+	Zone            string	`json:"zone,omitempty" metadata:"zone,optional"`
+	SourceType      string	`json:"source_type,omitempty" metadata:"source_type,optional"`
+}
+
+// Identity 证书身份与用户的绑定关系
+type Identity struct {
+	MspId           string	`json:"msp_id"`
+	CertId          string	`json:"cert_id"`
+	UserName        string	`json:"user_name"`
 }
 
 // UserList 用户列表
@@ -28,30 +40,178 @@ const ADMIN string = "admin"
 const PowerPlant string = "powerPlant"
 const PowerUser string = "powerUser"
 
-// Register 注册用户
+// Register 注册用户，调用者只能注册powerPlant或powerUser，admin由InitAdmin或RegisterAdmin注册
 func (r *RoleContract) Register(
 	ctx contractapi.TransactionContextInterface,
 	userName string,
 	userRole string) (*User, error) {
+	// 1.检查角色是否符合标准
+	if userRole != PowerPlant && userRole != PowerUser {
+		return nil, fmt.Errorf("userRole %s is not right", userRole)
+	}
+
+	// 2.获取调用者证书身份
+	mspId, certId, err := r.getClientIdentity(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.register(ctx, userName, userRole, mspId, certId)
+}
+
+// InitAdmin 链码部署后注册第一个admin，只能执行一次
+func (r *RoleContract) InitAdmin(
+	ctx contractapi.TransactionContextInterface,
+	userName string) (*User, error) {
+	// 1.判断是否已经初始化
+	bootstrapAsBytes, err := getState(ctx, AdminBootstrapObjectType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bootstrapAsBytes != nil {
+		return nil, fmt.Errorf("Admin has been initialized ! ")
+	}
+
+	// 2.获取调用者证书身份
+	mspId, certId, err := r.getClientIdentity(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.注册admin，并记录初始化
+	user, err := r.register(ctx, userName, ADMIN, mspId, certId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = putState(ctx, []byte(userName), AdminBootstrapObjectType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// RegisterAdmin admin为指定证书身份注册新的admin
+func (r *RoleContract) RegisterAdmin(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	mspId string,
+	certId string) (*User, error) {
+	// 1.判断证书身份
+	if mspId == "" || certId == "" {
+		return nil, fmt.Errorf("MspId and CertId can not be blank ! ")
+	}
+
+	return r.register(ctx, userName, ADMIN, mspId, certId)
+}
+
+// BindIdentity admin为迁移前注册、没有绑定证书身份的用户绑定证书身份，每个用户只能绑定一次
+func (r *RoleContract) BindIdentity(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	mspId string,
+	certId string) (*User, error) {
+	// 1.判断证书身份
+	if mspId == "" || certId == "" {
+		return nil, fmt.Errorf("MspId and CertId can not be blank ! ")
+	}
+
+	// 2.获取用户，只有没有绑定证书身份的用户可以绑定
+	user, err := r.QueryUser(ctx, userName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MspId != "" || user.CertId != "" {
+		return nil, fmt.Errorf("%s has bound an identity ! ", userName)
+	}
+
+	// 3.一个证书只能绑定一个用户
+	identityAsBytes, err := getState(ctx, IdentityObjectType, mspId, certId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Identity Info from world state. %s ", err.Error())
+	}
+
+	if identityAsBytes != nil {
+		return nil, fmt.Errorf("The identity has registered a user ! ")
+	}
+
+	// 4.上链
+	user.MspId = mspId
+	user.CertId = certId
+
+	identity := &Identity{
+		MspId: mspId,
+		CertId: certId,
+		UserName: userName,
+	}
+
+	userAsBytes, _ := json.Marshal(user)
+	identityAsBytes, _ = json.Marshal(identity)
+
+	err1 := putState(ctx, userAsBytes, UserObjectType, userName)
+	err2 := putState(ctx, identityAsBytes, IdentityObjectType, mspId, certId)
+
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("Failed to put user %s to world state ! ", userName)
+	}
+
+	return user, nil
+}
+
+// register 注册用户并绑定证书身份
+func (r *RoleContract) register(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	userRole string,
+	mspId string,
+	certId string) (*User, error) {
 	// 0.判断用户是否存在
 	if r.UserExist(ctx, userName) {
 		return nil, fmt.Errorf("The user is exist ! ")
 	}
 
-	// 1.用户加入用户列表
-	userListAsBytes, _ := ctx.GetStub().GetState("UserList")
+	// 1.一个证书只能绑定一个用户
+	identity, err := r.QueryIdentity(ctx, mspId, certId)
 
-	// 2.检查角色是否符合标准
-	if userRole != ADMIN && userRole != PowerPlant && userRole != PowerUser {
-		return nil, fmt.Errorf("userRole %s is not right", userRole)
+	if err == nil {
+		return nil, fmt.Errorf("The identity has registered user %s ! ", identity.UserName)
+	}
+
+	// 1.1获取用户列表
+	userListAsBytes, _ := getState(ctx, UserListObjectType)
+
+	// 2.读取治理参数
+	var v VarChangeContract
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return nil, err
 	}
 
 	// 3.用户结构体赋值
 	user := User{
 		UserName: userName,
 		UserRole: userRole,
-		UserCredit: InitCredit,
+		UserCredit: variables[InitCredit],
 		Power: 0,
+		MspId: mspId,
+		CertId: certId,
+	}
+
+	identity = &Identity{
+		MspId: mspId,
+		CertId: certId,
+		UserName: userName,
 	}
 
 	// 4.用户加入用户列表
@@ -61,13 +221,22 @@ func (r *RoleContract) Register(
 
 	userAsBytes, _ := json.Marshal(user)
 	userListAsBytes, _ = json.Marshal(userList)
+	identityAsBytes, _ := json.Marshal(identity)
 
 	// 5.用户上链
-	err1 := ctx.GetStub().PutState("UserList", userListAsBytes)
-	err2 := ctx.GetStub().PutState(userName, userAsBytes)
+	err1 := putState(ctx, userListAsBytes, UserListObjectType)
+	err2 := putState(ctx, userAsBytes, UserObjectType, userName)
+	err3 := putState(ctx, identityAsBytes, IdentityObjectType, mspId, certId)
 
-	if err1 != nil && err2 != nil {
-		return nil, fmt.Errorf(err1.Error(), err2.Error())
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, fmt.Errorf("Failed to put user %s to world state ! ", userName)
+	}
+
+	// 6.发出事件
+	err = emitEvent(ctx, events.UserRegistered, events.UserEntity, userName, "", userRole, []string{userName}, nil)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return &user, nil
@@ -78,7 +247,7 @@ func (r *RoleContract) Register(
 func (r *RoleContract) QueryUser(
 	ctx contractapi.TransactionContextInterface,
 	userName string) (*User, error) {
-	userAsBytes, err := ctx.GetStub().GetState(userName)
+	userAsBytes, err := getState(ctx, UserObjectType, userName)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to query User Info from world state. %s ", err.Error())
@@ -94,51 +263,27 @@ func (r *RoleContract) QueryUser(
 	return user, nil
 }
 
-// ChangeCredit 更改用户信用值
-func (r *RoleContract) ChangeCredit(
+// changeCredit 更改用户信用值，仅供合约内部调用
+func (r *RoleContract) changeCredit(
 	ctx contractapi.TransactionContextInterface,
 	userName string,
 	userCredit int) error {
-	// 1.获取用户
-	user, err := r.QueryUser(ctx, userName)
-
-	if err != nil {
-		return err
-	}
-
-	// 2.更改信用值
-	user.UserCredit = user.UserCredit + userCredit
-	userAsBytes, _ := json.Marshal(user)
-
-	// 3.重新上链
-	return ctx.GetStub().PutState(userName, userAsBytes)
+	return r.changeCreditAndPower(ctx, userName, userCredit, 0)
 }
 
-// ChangePower 更改用户交易量
-func (r *RoleContract) ChangePower(
+// changePower 更改用户交易量，仅供合约内部调用
+func (r *RoleContract) changePower(
 	ctx contractapi.TransactionContextInterface,
 	userName string,
 	power int) error {
-	// 1.获取用户
-	user, err := r.QueryUser(ctx, userName)
-
-	if err != nil {
-		return err
-	}
-
-	// 2.更改能量
-	user.Power = user.Power + power
-	userAsBytes, _ := json.Marshal(user)
-
-	// 3.重新上链
-	return ctx.GetStub().PutState(userName, userAsBytes)
+	return r.changeCreditAndPower(ctx, userName, 0, power)
 }
 
 // QueryUserList 获取用户列表
 func (r *RoleContract) QueryUserList(
 	ctx contractapi.TransactionContextInterface) *UserList {
 	// 1.获取用户列表
-	userListAsBytes, _ := ctx.GetStub().GetState("UserList")
+	userListAsBytes, _ := getState(ctx, UserListObjectType)
 
 	// 2.赋值
 	userList := new(UserList)
@@ -153,7 +298,7 @@ func(r *RoleContract) UserExist(
 	ctx contractapi.TransactionContextInterface,
 	userName string) bool {
 	// 1.获取用户
-	userAsBytes, _ := ctx.GetStub().GetState(userName)
+	userAsBytes, _ := getState(ctx, UserObjectType, userName)
 
 	// 2.如果返回值为空，用户不存在，则返回false
 	if userAsBytes == nil {
@@ -162,4 +307,145 @@ func(r *RoleContract) UserExist(
 
 	// 3.用户存在，返回true
 	return true
-}
\ No newline at end of file
+}
+
+// QueryIdentity 根据证书身份获取绑定的用户名
+func (r *RoleContract) QueryIdentity(
+	ctx contractapi.TransactionContextInterface,
+	mspId string,
+	certId string) (*Identity, error) {
+	// 1.获取绑定关系
+	identityAsBytes, err := getState(ctx, IdentityObjectType, mspId, certId)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Identity Info from world state. %s ", err.Error())
+	}
+
+	if identityAsBytes == nil {
+		return nil, fmt.Errorf("The identity is not registered ! ")
+	}
+
+	// 2.赋值
+	identity := new(Identity)
+	_ = json.Unmarshal(identityAsBytes, identity)
+
+	return identity, nil
+}
+
+// QueryCaller 根据调用者证书获取当前用户
+func (r *RoleContract) QueryCaller(
+	ctx contractapi.TransactionContextInterface) (*User, error) {
+	// 1.获取调用者证书身份
+	mspId, certId, err := r.getClientIdentity(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.获取证书绑定的用户
+	identity, err := r.QueryIdentity(ctx, mspId, certId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 3.获取用户
+	user, err := r.QueryUser(ctx, identity.UserName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 4.证书与用户记录不一致，拒绝
+	if user.MspId != mspId || user.CertId != certId {
+		return nil, fmt.Errorf("The identity does not match user %s ! ", user.UserName)
+	}
+
+	return user, nil
+}
+
+// checkCaller 判断调用者是否为userName本人
+func (r *RoleContract) checkCaller(
+	ctx contractapi.TransactionContextInterface,
+	userName string) (*User, error) {
+	// 1.获取调用者
+	caller, err := r.QueryCaller(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.调用者与用户名不一致，拒绝
+	if caller.UserName != userName {
+		return nil, fmt.Errorf("The caller %s is not %s ! ", caller.UserName, userName)
+	}
+
+	return caller, nil
+}
+
+// checkAdmin 判断调用者是否为admin
+func (r *RoleContract) checkAdmin(
+	ctx contractapi.TransactionContextInterface) (*User, error) {
+	// 1.获取调用者
+	caller, err := r.QueryCaller(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 2.调用者不是admin，拒绝
+	if caller.UserRole != ADMIN {
+		return nil, fmt.Errorf("The caller %s is not an admin ! ", caller.UserName)
+	}
+
+	return caller, nil
+}
+
+// getClientIdentity 获取调用者的MSP ID与证书ID
+func (r *RoleContract) getClientIdentity(
+	ctx contractapi.TransactionContextInterface) (string, string, error) {
+	mspId, err := ctx.GetClientIdentity().GetMSPID()
+
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get client MSP ID. %s ", err.Error())
+	}
+
+	certId, err := ctx.GetClientIdentity().GetID()
+
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to get client ID. %s ", err.Error())
+	}
+
+	return mspId, certId, nil
+}
+
+// changeCreditAndPower 同时更改用户信用值和交易量，同一交易中对同一用户只写一次
+func (r *RoleContract) changeCreditAndPower(
+	ctx contractapi.TransactionContextInterface,
+	userName string,
+	userCredit int,
+	power int) error {
+	// 1.获取用户
+	user, err := r.QueryUser(ctx, userName)
+
+	if err != nil {
+		return err
+	}
+
+	// 2.更改信用值和能量
+	oldState := fmt.Sprintf("%d/%d", user.UserCredit, user.Power)
+	user.UserCredit = user.UserCredit + userCredit
+	user.Power = user.Power + power
+	userAsBytes, _ := json.Marshal(user)
+
+	// 3.重新上链
+	err = putState(ctx, userAsBytes, UserObjectType, userName)
+
+	if err != nil {
+		return err
+	}
+
+	// 4.发出事件
+	return emitEvent(ctx, events.UserCreditChanged, events.UserEntity, userName,
+		oldState, fmt.Sprintf("%d/%d", user.UserCredit, user.Power), []string{userName}, nil)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRegisterBindsCaller(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+
+	var r RoleContract
+	caller, err := r.QueryCaller(s.ctx("alice"))
+	noError(t, err)
+
+	if caller.UserName != "alice" || caller.MspId != "Org1MSP" || caller.CertId != certId(s, "alice") {
+		t.Fatalf("caller = %+v", caller)
+	}
+
+	// 一个证书只能注册一个用户
+	if _, err := r.Register(s.ctx("alice"), "alice2", PowerUser); err == nil {
+		t.Fatal("identity registered twice")
+	}
+
+	// 用户名不能重复
+	if _, err := r.Register(s.ctx("bob"), "alice", PowerUser); err == nil {
+		t.Fatal("user name registered twice")
+	}
+
+	if _, err := r.QueryCaller(s.ctx("bob")); err == nil {
+		t.Fatal("unregistered caller identified")
+	}
+}
+
+func TestCheckCallerRejectsImpersonation(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice")
+	register(t, s, PowerPlant, "plant")
+
+	var r RoleContract
+	if _, err := r.checkCaller(s.ctx("plant"), "alice"); err == nil {
+		t.Fatal("plant acted as alice")
+	}
+
+	if _, err := r.checkCaller(s.ctx("mallory"), "alice"); err == nil {
+		t.Fatal("unregistered caller acted as alice")
+	}
+
+	var p PowerTXContract
+	if _, err := p.Commit(s.ctx("plant"), "c1", "alice", 100, "0.5", "2026-01-01 00:00:00", "2026-02-01 00:00:00"); err == nil {
+		t.Fatal("plant committed for alice")
+	}
+
+	noError(t, errOf(p.Commit(s.ctx("alice"), "c1", "alice", 100, "0.5", "2026-01-01 00:00:00", "2026-02-01 00:00:00")))
+}
+
+func TestInitAdminOnce(t *testing.T) {
+	s := newTestStub()
+	initAdmin(t, s, "admin")
+	register(t, s, PowerUser, "alice")
+
+	if _, err := s.invoke("mallory", "RoleContract:InitAdmin", "mallory"); err == nil {
+		t.Fatal("admin initialized twice")
+	}
+
+	// ADMIN角色不能通过Register注册，只能由已有admin登记
+	if _, err := s.invoke("mallory", "RoleContract:Register", "mallory", ADMIN); err == nil {
+		t.Fatal("admin registered itself")
+	}
+
+	if _, err := s.invoke("alice", "RoleContract:RegisterAdmin", "admin2", "Org1MSP", certId(s, "admin2")); err == nil {
+		t.Fatal("power user registered an admin")
+	}
+
+	user := new(User)
+	noError(t, json.Unmarshal(call(t, s, "admin", "RoleContract:RegisterAdmin", "admin2", "Org1MSP", certId(s, "admin2")), user))
+
+	if user.UserRole != ADMIN {
+		t.Fatalf("admin2 role = %s", user.UserRole)
+	}
+
+	caller := new(User)
+	noError(t, json.Unmarshal(call(t, s, "admin2", "RoleContract:QueryCaller"), caller))
+
+	if caller.UserName != "admin2" {
+		t.Fatalf("caller = %s, want admin2", caller.UserName)
+	}
+}
@@ -4,35 +4,232 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"myChaincode/events"
+	"sort"
 )
 
 // InitCredit 初始信用值
-var InitCredit int = 100
+const InitCredit string = "InitCredit"
 
 // CreditBorder 初始信用值边界
-var CreditBorder int = 50
+const CreditBorder string = "CreditBorder"
 
 // TxAwardCredit 初始交易奖励信用值
-var TxAwardCredit int = 5
+const TxAwardCredit string = "TxAwardCredit"
 
 // PowerBorder 初始电能分档边界
-var PowerBorder int = 50
-
-// AwardCredit 奖励分
-func (v *VarChangeContract) AwardCredit(power int) int {
-	return (power /PowerBorder + 1) * TxAwardCredit
-}
+const PowerBorder string = "PowerBorder"
 
 // BallotAwardCredit 初始化投票奖励信用值
-var BallotAwardCredit int = 6
+const BallotAwardCredit string = "BallotAwardCredit"
 
 // CommitteeMemberNumber 初始化委员会成员数量
-var CommitteeMemberNumber = 5
+const CommitteeMemberNumber string = "CommitteeMemberNumber"
+
+// AdminFeeRate admin手续费率，单位为千分之一
+const AdminFeeRate string = "AdminFeeRate"
+
+// ToleranceBand 履约偏差容忍区间，实际电量偏离合同电量不超过该比例时视为按约履行，单位为千分之一
+const ToleranceBand string = "ToleranceBand"
+
+// PenaltyBand 超出容忍区间后每一档罚分的宽度，单位为千分之一
+const PenaltyBand string = "PenaltyBand"
+
+// UnderDeliveryPenalty powerPlant少供电每档扣除的信用值
+const UnderDeliveryPenalty string = "UnderDeliveryPenalty"
+
+// OverDeliveryPenalty powerPlant多供电每档扣除的信用值
+const OverDeliveryPenalty string = "OverDeliveryPenalty"
+
+// UnderConsumptionPenalty powerUser少用电每档扣除的信用值
+const UnderConsumptionPenalty string = "UnderConsumptionPenalty"
+
+// OverConsumptionPenalty powerUser多用电每档扣除的信用值
+const OverConsumptionPenalty string = "OverConsumptionPenalty"
+
+// ExpiryGraceHours compact与提案超过结束时间多少小时后可以被置为过期
+const ExpiryGraceHours string = "ExpiryGraceHours"
+
+// ExpiryPenalty powerUser未处理报价导致compact过期时扣除的信用值
+const ExpiryPenalty string = "ExpiryPenalty"
+
+// MaxNegotiationRounds compact最多的协商轮次，powerUser每次拒绝报价进入下一轮
+const MaxNegotiationRounds string = "MaxNegotiationRounds"
+
+// DefaultEmissionFactor 未经提案设置排放因子的powerPlant使用的排放因子，单位为gCO2/kWh
+const DefaultEmissionFactor string = "DefaultEmissionFactor"
+
+// DealAssignmentHours 被指派的admin必须在多少小时内执行Deal，超过后可以重新指派
+const DealAssignmentHours string = "DealAssignmentHours"
+
+// LargeCompactTransaction 交易电量超过该值的compact执行Deal前需要委员会成员批准，单位为kWh
+const LargeCompactTransaction string = "LargeCompactTransaction"
+
+// CompactApprovalThreshold 大额compact执行Deal前需要批准的委员会成员数量
+const CompactApprovalThreshold string = "CompactApprovalThreshold"
+
+// MeterGraceHours compact结束后等待各方上报最终电表读数的小时数，超过后缺少读数的一方按已上报电量结算
+const MeterGraceHours string = "MeterGraceHours"
+
+// defaultVariables 治理参数默认值，链上没有记录时使用
+var defaultVariables = map[string]int{
+	InitCredit:               100,
+	CreditBorder:             50,
+	TxAwardCredit:            5,
+	PowerBorder:              50,
+	BallotAwardCredit:        6,
+	CommitteeMemberNumber:    5,
+	AdminFeeRate:             10,
+	ToleranceBand:            50,
+	PenaltyBand:              100,
+	UnderDeliveryPenalty:     10,
+	OverDeliveryPenalty:      5,
+	UnderConsumptionPenalty:  10,
+	OverConsumptionPenalty:   5,
+	ExpiryGraceHours:         24,
+	ExpiryPenalty:            5,
+	MaxNegotiationRounds:     5,
+	DefaultEmissionFactor:    581,
+	DealAssignmentHours:      24,
+	LargeCompactTransaction:  10000,
+	CompactApprovalThreshold: 3,
+	MeterGraceHours:          24,
+}
+
+// VariableBound 治理参数的取值范围，包括两端
+type VariableBound struct {
+	Min int
+	Max int
+}
+
+// variableBounds 治理参数的取值范围，创建与执行修改变量的提案时检查
+var variableBounds = map[string]VariableBound{
+	InitCredit:               {0, 1000},
+	CreditBorder:             {0, 1000},
+	TxAwardCredit:            {0, 100},
+	PowerBorder:              {1, 1000000},
+	BallotAwardCredit:        {0, 100},
+	CommitteeMemberNumber:    {1, 100},
+	AdminFeeRate:             {0, 1000},
+	ToleranceBand:            {0, 1000},
+	PenaltyBand:              {1, 1000},
+	UnderDeliveryPenalty:     {0, 100},
+	OverDeliveryPenalty:      {0, 100},
+	UnderConsumptionPenalty:  {0, 100},
+	OverConsumptionPenalty:   {0, 100},
+	ExpiryGraceHours:         {0, 720},
+	ExpiryPenalty:            {0, 100},
+	MaxNegotiationRounds:     {1, 100},
+	DefaultEmissionFactor:    {0, 2000},
+	DealAssignmentHours:      {1, 720},
+	LargeCompactTransaction:  {0, 1000000000},
+	CompactApprovalThreshold: {1, 100},
+	MeterGraceHours:          {0, 720},
+}
+
+// Variable 治理参数记录
+type Variable struct {
+	Name         string `json:"name"`
+	Value        int    `json:"value"`
+	Version      int    `json:"version"`
+	ProposalName string `json:"proposal_name"`
+	TxId         string `json:"tx_id"`
+}
 
 type VarChangeContract struct {
 	contractapi.Contract
 }
 
+// AwardCredit 奖励分
+func (v *VarChangeContract) AwardCredit(
+	ctx contractapi.TransactionContextInterface,
+	power int) (int, error) {
+	// 1.读取治理参数
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// 2.计算奖励分
+	return (power/variables[PowerBorder] + 1) * variables[TxAwardCredit], nil
+}
+
+// PerformanceCredit 按履约偏差计算信用值变化
+// 偏差在容忍区间内时按合同电量奖励，超出时按档扣分，supplier为true时按powerPlant供电计算，否则按powerUser用电计算
+func (v *VarChangeContract) PerformanceCredit(
+	ctx contractapi.TransactionContextInterface,
+	contracted int,
+	actual int,
+	supplier bool) (int, error) {
+	// 1.读取治理参数
+	variables, err := v.loadVariables(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return v.totalCredit(variables, contracted, actual, supplier)
+}
+
+// totalCredit 按总电量偏差计算信用值变化，variables为交易开始时读取的治理参数
+func (v *VarChangeContract) totalCredit(
+	variables map[string]int,
+	contracted int,
+	actual int,
+	supplier bool) (int, error) {
+	// 1.计算少用(供)与多用(供)的电量
+	under, over := 0, 0
+	if actual < contracted {
+		under = contracted - actual
+	} else {
+		over = actual - contracted
+	}
+
+	return v.deviationCredit(variables, contracted, under, over, supplier)
+}
+
+// deviationCredit 按偏差电量计算信用值变化，under与over为少用(供)与多用(供)的电量
+// 偏差比例为两者之和占合同电量的比例，扣分按偏差较多的方向计算
+func (v *VarChangeContract) deviationCredit(
+	variables map[string]int,
+	contracted int,
+	under int,
+	over int,
+	supplier bool) (int, error) {
+	// 1.计算偏差比例
+	if contracted <= 0 {
+		return 0, fmt.Errorf("Contracted power must be positive ! ")
+	}
+
+	deviationRate := (under + over) * 1000 / contracted
+
+	// 2.容忍区间内按约履行，给予奖励
+	if deviationRate <= variables[ToleranceBand] {
+		return (contracted/variables[PowerBorder] + 1) * variables[TxAwardCredit], nil
+	}
+
+	// 3.超出容忍区间，按档扣分，不足一档按一档计算
+	grade := 1
+	if variables[PenaltyBand] > 0 {
+		grade = (deviationRate - variables[ToleranceBand] + variables[PenaltyBand] - 1) / variables[PenaltyBand]
+	}
+
+	var penalty int
+	switch {
+	case supplier && under >= over:
+		penalty = variables[UnderDeliveryPenalty]
+	case supplier:
+		penalty = variables[OverDeliveryPenalty]
+	case under >= over:
+		penalty = variables[UnderConsumptionPenalty]
+	default:
+		penalty = variables[OverConsumptionPenalty]
+	}
+
+	return -grade * penalty, nil
+}
+
 // CreateChangeVariableProposal 创建更改变量投票提案
 func (v *VarChangeContract) CreateChangeVariableProposal(
 	ctx contractapi.TransactionContextInterface,
@@ -44,14 +241,9 @@ func (v *VarChangeContract) CreateChangeVariableProposal(
 	variable string,
 	value int) (*BallotProposal, error) {
 	var b BallotContract
-	// 1.检查要更改的变量的名称是否准确
-	if variable != "InitCredit" &&
-		variable != "CreditBorder" &&
-		variable != "TxAwardCredit" &&
-		variable != "PowerBorder" &&
-		variable != "BallotAwardCredit" &&
-		variable != "CommitteeMemberNumber"{
-		return nil, fmt.Errorf("The variable is not right ! ")
+	// 1.检查要更改的变量的名称是否准确，值是否在取值范围内
+	if err := checkVariable(variable, value); err != nil {
+		return nil, err
 	}
 
 	// 2.发起提案
@@ -67,7 +259,7 @@ func (v *VarChangeContract) CreateChangeVariableProposal(
 	ballotProposalAsBytes, _ := json.Marshal(ballotProposal)
 
 	// 3.上链
-	err = ctx.GetStub().PutState(ballotProposalName, ballotProposalAsBytes)
+	err = putState(ctx, ballotProposalAsBytes, BallotProposalObjectType, ballotProposalName)
 
 	if err != nil {
 		return nil, err
@@ -80,32 +272,151 @@ func (v *VarChangeContract) CreateChangeVariableProposal(
 func (v *VarChangeContract) CheckChangeVariableProposal(
 	ctx contractapi.TransactionContextInterface,
 	ballotProposalName string,) (*BallotProposal, error){
-	// 1.检查结果
+	// 1.判断是否为修改变量的提案
 	var b BallotContract
-	ballotProposal, err := b.CheckBallotProposal(ctx, ballotProposalName)
+	ballotProposal, err := b.QueryBallotProposal(ctx, ballotProposalName)
 
 	if err != nil {
-		return nil, fmt.Errorf(err.Error())
+		return nil, err
+	}
+
+	if _, ok := defaultVariables[ballotProposal.Variable]; !ok {
+		return nil, fmt.Errorf("The variable is not right ! ")
 	}
 
-	variable := ballotProposal.Variable
+	// 1.1检查结果，每个提案只结算一次
+	ballotProposal, err = b.checkBallotProposal(ctx, ballotProposalName)
+
+	if err != nil {
+		return nil, fmt.Errorf(err.Error())
+	}
 
 	// 2.如果提案结果为true,更改对应变量的值
 	if ballotProposal.Result {
-		if variable == "InitCredit" {
-			InitCredit = ballotProposal.Value
-		} else if variable == "CreditBorder" {
-			CreditBorder = ballotProposal.Value
-		} else if variable == "TxAwardCredit" {
-			TxAwardCredit = ballotProposal.Value
-		} else if variable == "PowerBorder" {
-			PowerBorder = ballotProposal.Value
-		} else if variable == "BallotAwardCredit" {
-			BallotAwardCredit = ballotProposal.Value
-		} else if variable == "CommitteeMemberNumber" {
-			CommitteeMemberNumber = ballotProposal.Value
+		if err := checkVariable(ballotProposal.Variable, ballotProposal.Value); err != nil {
+			return nil, err
+		}
+
+		variable, err := v.QueryVariable(ctx, ballotProposal.Variable)
+
+		if err != nil {
+			return nil, err
+		}
+
+		oldValue := variable.Value
+		variable.Value = ballotProposal.Value
+		variable.Version++
+		variable.ProposalName = ballotProposalName
+		variable.TxId = ctx.GetStub().GetTxID()
+
+		// 2.1上链
+		variableAsBytes, _ := json.Marshal(variable)
+		err = putState(ctx, variableAsBytes, VariableObjectType, variable.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// 2.2发出事件
+		err = emitEvent(ctx, events.VariableChanged, events.VariableEntity, variable.Name,
+			fmt.Sprintf("%d", oldValue), fmt.Sprintf("%d", variable.Value), []string{ballotProposal.ProposerName},
+			map[string]string{"ballot_proposal_name": ballotProposalName, "version": fmt.Sprintf("%d", variable.Version)})
+
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	return ballotProposal, nil
-}
\ No newline at end of file
+}
+
+// QueryVariable 获取治理参数，链上没有记录时返回默认值
+func (v *VarChangeContract) QueryVariable(
+	ctx contractapi.TransactionContextInterface,
+	name string) (*Variable, error) {
+	// 1.判断参数名称是否准确
+	defaultValue, ok := defaultVariables[name]
+
+	if !ok {
+		return nil, fmt.Errorf("The variable %s is not right ! ", name)
+	}
+
+	// 2.获取参数记录
+	variableAsBytes, err := getState(ctx, VariableObjectType, name)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Variable Info from world state. %s ", err.Error())
+	}
+
+	// 3.链上没有记录，返回默认值
+	if variableAsBytes == nil {
+		return &Variable{
+			Name: name,
+			Value: defaultValue,
+			Version: 0,
+		}, nil
+	}
+
+	// 4.赋值
+	variable := new(Variable)
+	_ = json.Unmarshal(variableAsBytes, variable)
+
+	return variable, nil
+}
+
+// QueryVariables 获取当前生效的全部治理参数
+func (v *VarChangeContract) QueryVariables(
+	ctx contractapi.TransactionContextInterface) ([]*Variable, error) {
+	// 1.参数名称排序，保证返回顺序确定
+	names := make([]string, 0, len(defaultVariables))
+	for name := range defaultVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// 2.逐个获取参数
+	variables := []*Variable{}
+	for _, name := range names {
+		variable, err := v.QueryVariable(ctx, name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		variables = append(variables, variable)
+	}
+
+	return variables, nil
+}
+
+// loadVariables 交易开始时读取全部治理参数
+func (v *VarChangeContract) loadVariables(
+	ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	variables, err := v.QueryVariables(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int)
+	for _, variable := range variables {
+		values[variable.Name] = variable.Value
+	}
+
+	return values, nil
+}
+
+// checkVariable 判断治理参数的名称是否准确，值是否在取值范围内
+func checkVariable(name string, value int) error {
+	if _, ok := defaultVariables[name]; !ok {
+		return fmt.Errorf("The variable is not right ! ")
+	}
+
+	bound, ok := variableBounds[name]
+
+	if ok && (value < bound.Min || value > bound.Max) {
+		return fmt.Errorf("%s must be between %d and %d ! ", name, bound.Min, bound.Max)
+	}
+
+	return nil
+}
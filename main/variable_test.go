@@ -0,0 +1,174 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// changeVariable 全体用户投票通过修改变量的提案并结算
+func changeVariable(t *testing.T, s *testStub, proposalName string, variable string, value int, voters ...string) *BallotProposal {
+	t.Helper()
+
+	var v VarChangeContract
+	var b BallotContract
+	noError(t, errOf(v.CreateChangeVariableProposal(s.ctx(voters[0]), proposalName, voters[0], "Public",
+		s.timeNow(), s.now.Add(time.Hour).Format(TimeLayout), variable, value)))
+
+	s.advance(time.Minute)
+	for _, voter := range voters {
+		noError(t, errOf(b.VoteBallotProposal(s.ctx(voter), proposalName, voter, true)))
+	}
+
+	s.advance(2 * time.Hour)
+	proposal, err := v.CheckChangeVariableProposal(s.ctx(voters[0]), proposalName)
+	noError(t, err)
+
+	return proposal
+}
+
+func TestQueryVariableDefault(t *testing.T) {
+	s := newTestStub()
+
+	var v VarChangeContract
+	variable, err := v.QueryVariable(s.ctx("alice"), InitCredit)
+	noError(t, err)
+
+	if variable.Value != defaultVariables[InitCredit] || variable.Version != 0 {
+		t.Fatalf("default %s = %+v", InitCredit, variable)
+	}
+
+	if _, err := v.QueryVariable(s.ctx("alice"), "Unknown"); err == nil {
+		t.Fatal("unknown variable queried")
+	}
+
+	variables, err := v.QueryVariables(s.ctx("alice"))
+	noError(t, err)
+
+	if len(variables) != len(defaultVariables) {
+		t.Fatalf("variables = %d, want %d", len(variables), len(defaultVariables))
+	}
+}
+
+func TestChangeVariablePersists(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice", "bob", "carol")
+
+	proposal := changeVariable(t, s, "v1", InitCredit, 200, "alice", "bob", "carol")
+
+	if !proposal.Result {
+		t.Fatal("proposal rejected")
+	}
+
+	var v VarChangeContract
+	variable, err := v.QueryVariable(s.ctx("alice"), InitCredit)
+	noError(t, err)
+
+	if variable.Value != 200 || variable.Version != 1 || variable.ProposalName != "v1" {
+		t.Fatalf("changed %s = %+v", InitCredit, variable)
+	}
+
+	// 新注册的用户使用链上的变量值
+	register(t, s, PowerUser, "dave")
+
+	if user := queryUser(t, s, "dave"); user.UserCredit != 200 {
+		t.Fatalf("dave credit = %d, want 200", user.UserCredit)
+	}
+
+	// 同一提案只生效一次
+	if _, err := v.CheckChangeVariableProposal(s.ctx("alice"), "v1"); err == nil {
+		t.Fatal("proposal checked twice")
+	}
+
+	// 修改变量的提案也不能通过普通投票的检查交易结算
+	setCommittee(t, s, "alice")
+
+	if _, err := s.invoke("alice", "BallotContract:CheckBallotProposal", "v1"); err == nil {
+		t.Fatal("variable proposal checked as a ballot")
+	}
+
+	if variable, _ := v.QueryVariable(s.ctx("alice"), InitCredit); variable.Version != 1 {
+		t.Fatalf("proposal applied twice, version %d", variable.Version)
+	}
+}
+
+func TestChangeVariableBounds(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice", "bob")
+	setCommittee(t, s, "alice")
+
+	// 只有admin与委员会成员可以发起修改变量的提案
+	if _, err := s.invoke("bob", "VarChangeContract:CreateChangeVariableProposal", "v1", "bob", "Public",
+		"2026-01-01 00:00:00", "2026-01-01 01:00:00", InitCredit, "200"); err == nil {
+		t.Fatal("variable proposal created by a power user")
+	}
+
+	// 名称错误与超出取值范围的变量都不能发起提案
+	for _, c := range []struct {
+		variable string
+		value    string
+	}{
+		{"Unknown", "1"},
+		{PenaltyBand, "0"},
+		{InitCredit, "1001"},
+	} {
+		if _, err := s.invoke("alice", "VarChangeContract:CreateChangeVariableProposal", "v1", "alice", "Public",
+			"2026-01-01 00:00:00", "2026-01-01 01:00:00", c.variable, c.value); err == nil {
+			t.Errorf("%s = %s accepted", c.variable, c.value)
+		}
+	}
+
+	call(t, s, "alice", "VarChangeContract:CreateChangeVariableProposal", "v1", "alice", "Public",
+		"2026-01-01 00:00:00", "2026-01-01 01:00:00", InitCredit, "1000")
+}
+
+func TestAwardCreditUsesVariables(t *testing.T) {
+	s := newTestStub()
+	register(t, s, PowerUser, "alice", "bob")
+
+	var v VarChangeContract
+	credit, err := v.AwardCredit(s.ctx("alice"), 120)
+	noError(t, err)
+
+	if credit != 15 {
+		t.Fatalf("award for 120 = %d, want 15", credit)
+	}
+
+	changeVariable(t, s, "v1", TxAwardCredit, 2, "alice", "bob")
+
+	if credit, _ := v.AwardCredit(s.ctx("alice"), 120); credit != 6 {
+		t.Fatalf("award after the change = %d, want 6", credit)
+	}
+}
+
+func TestPerformanceCredit(t *testing.T) {
+	s := newTestStub()
+
+	cases := []struct {
+		actual   int
+		supplier bool
+		credit   int
+	}{
+		{100, true, 15},
+		{96, true, 15},
+		{105, false, 15},
+		{90, true, -10},
+		{70, true, -30},
+		{120, true, -10},
+		{80, false, -20},
+		{120, false, -10},
+	}
+
+	var v VarChangeContract
+	for _, c := range cases {
+		credit, err := v.PerformanceCredit(s.ctx("alice"), 100, c.actual, c.supplier)
+		noError(t, err)
+
+		if credit != c.credit {
+			t.Errorf("credit for %d of 100 (supplier %v) = %d, want %d", c.actual, c.supplier, credit, c.credit)
+		}
+	}
+
+	if _, err := v.PerformanceCredit(s.ctx("alice"), 0, 10, true); err == nil {
+		t.Fatal("credit computed for zero contracted power")
+	}
+}